@@ -0,0 +1,119 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// CERT_VALIDITY 生成的证书只用于测试，有效期一年
+const CERT_VALIDITY = 365 * 24 * time.Hour
+
+// GenerateCerts 在 dir 中生成自签名 CA 以及由它签发的服务端和客户端证书，
+// hosts 为服务端证书中的 IP 或域名，文件名见 CA_CERT_FILE 等常量
+func GenerateCerts(dir string, hosts []string) error {
+	errFormat := "api.GenerateCerts: %w"
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	caTmpl, err := certTemplate("mydocker CA")
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := writeCert(dir, CA_CERT_FILE, CA_KEY_FILE, caDER, caKey); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+
+	serverTmpl, err := certTemplate("mydocker daemon")
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	serverTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			serverTmpl.IPAddresses = append(serverTmpl.IPAddresses, ip)
+		} else {
+			serverTmpl.DNSNames = append(serverTmpl.DNSNames, h)
+		}
+	}
+	if err := issueCert(dir, SERVER_CERT_FILE, SERVER_KEY_FILE, serverTmpl, caCert, caKey); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+
+	clientTmpl, err := certTemplate("mydocker client")
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	clientTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if err := issueCert(dir, CLIENT_CERT_FILE, CLIENT_KEY_FILE, clientTmpl, caCert, caKey); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func certTemplate(cn string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+func issueCert(dir, certFile, keyFile string, tmpl, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeCert(dir, certFile, keyFile, der, key)
+}
+
+// writeCert 证书可以公开，私钥只允许所有者读取
+func writeCert(dir, certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := utils.WriteFileAtomic(filepath.Join(dir, certFile), certPEM, 0644); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return utils.WriteFileAtomic(filepath.Join(dir, keyFile), keyPEM, 0600)
+}
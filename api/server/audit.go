@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/audit"
+	"golang.org/x/sys/unix"
+)
+
+// maxAuditBody 记录到审计日志中的请求体的最大长度
+const maxAuditBody = 4096
+
+type connKey struct{}
+
+// saveConn 把连接保存到请求的 context 中，审计时用来获取客户端的身份
+func saveConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// statusRecorder 记录响应状态码，同时保留 Flusher 和 Hijacker，日志流和 exec 需要它们
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// err 是响应头发送之后才出现的错误，如流式输出的构建失败
+	err error
+}
+
+// streamFailed 记录流式响应中途出现的错误，审计记录据此标记为失败
+func streamFailed(w http.ResponseWriter, err error) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.err = err
+	}
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if fl, ok := s.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection does not support hijacking")
+	}
+	conn, buf, err := hj.Hijack()
+	if err == nil {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// audited 修改状态的请求处理完成后追加一条审计记录，target 为路径或查询参数中目标参数的名称
+func audited(targetType, target string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 审计日志无法写入时不执行操作
+		if err := audit.Check(); err != nil {
+			writeError(w, err)
+			return
+		}
+		start := time.Now().UTC()
+		// JSON 请求体是创建容器、网络等操作的参数，读出来之后重新放回给 handler。
+		// 构建上下文等归档直接交给 handler 流式读取，不记录
+		var body []byte
+		if r.Body != nil && r.Header.Get("Content-Type") == "application/json" {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				writeError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		ar := &audit.Record{
+			Time:       start,
+			Source:     audit.SOURCE_API,
+			Command:    r.Method + " " + r.URL.Path,
+			TargetType: targetType,
+			UID:        -1,
+		}
+		if target != "" {
+			if ar.Target = r.PathValue(target); ar.Target == "" {
+				ar.Target = r.URL.Query().Get(target)
+			}
+		}
+		if q := r.URL.RawQuery; q != "" {
+			ar.Args = append(ar.Args, redactQuery(q))
+		}
+		if body := redactBody(body); len(body) > 0 {
+			if len(body) > maxAuditBody {
+				body = body[:maxAuditBody]
+			}
+			ar.Args = append(ar.Args, string(body))
+		}
+		// exec 会接管连接，需要在执行 handler 之前获取客户端身份
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+			ar.UID, ar.Remote = peer(c)
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		err := rec.err
+		if rec.status >= http.StatusBadRequest {
+			err = fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status))
+		}
+		if err := audit.Log(ar, err); err != nil {
+			logrus.Errorln(err)
+		}
+	}
+}
+
+// redactQuery 隐藏构建参数 buildarg 的取值
+func redactQuery(q string) string {
+	values, _ := url.ParseQuery(q)
+	for i, v := range values["buildarg"] {
+		values["buildarg"][i] = audit.RedactVar(v)
+	}
+	return values.Encode()
+}
+
+// redactBody 隐藏创建容器请求中环境变量的取值，不是 JSON 对象的请求体不记录
+func redactBody(body []byte) []byte {
+	if len(body) == 0 {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil
+	}
+	if env, ok := m["env"].([]any); ok {
+		for i, e := range env {
+			if s, ok := e.(string); ok {
+				env[i] = audit.RedactVar(s)
+			}
+		}
+	}
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return bs
+}
+
+// peer 返回连接对端的身份：unix socket 通过 SO_PEERCRED 获取对端进程的 uid，
+// TLS 连接返回客户端证书的 CN，其他情况返回对端地址
+func peer(c net.Conn) (uid int, remote string) {
+	uid = -1
+	switch conn := c.(type) {
+	case *net.UnixConn:
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return uid, ""
+		}
+		raw.Control(func(fd uintptr) {
+			if cred, err := unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED); err == nil {
+				uid = int(cred.Uid)
+			}
+		})
+		return uid, ""
+	case *tls.Conn:
+		if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			return uid, certs[0].Subject.CommonName
+		}
+	}
+	return uid, c.RemoteAddr().String()
+}
@@ -0,0 +1,768 @@
+// Package server 实现 mydocker daemon 的 HTTP API。所有修改状态的请求都在 daemon 内串行执行，
+// 由 daemon 启动的容器进程也由 daemon 等待回收并记录退出状态
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/archive"
+	"github.com/wlbyte/mydocker/audit"
+	"github.com/wlbyte/mydocker/builder"
+	"github.com/wlbyte/mydocker/cgroups/subsystems"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/network"
+	"github.com/wlbyte/mydocker/registry"
+	"github.com/wlbyte/mydocker/runtime"
+	"golang.org/x/sys/unix"
+)
+
+type Server struct {
+	rt *runtime.Runtime
+	// 串行化所有修改状态的请求
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+func New(rt *runtime.Runtime) *Server {
+	s := &Server{rt: rt}
+	s.srv = &http.Server{Handler: s.Handler(), ConnContext: saveConn}
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /_ping", s.ping)
+
+	mux.HandleFunc("GET /containers/json", s.listContainers)
+	mux.HandleFunc("POST /containers/create", audited(audit.TARGET_CONTAINER, "", s.locked(s.createContainer)))
+	mux.HandleFunc("GET /containers/{id}/json", s.inspectContainer)
+	mux.HandleFunc("POST /containers/{id}/start", audited(audit.TARGET_CONTAINER, "id", s.startContainer))
+	mux.HandleFunc("POST /containers/{id}/stop", audited(audit.TARGET_CONTAINER, "id", s.locked(s.stopContainer)))
+	mux.HandleFunc("POST /containers/{id}/kill", audited(audit.TARGET_CONTAINER, "id", s.locked(s.killContainer)))
+	mux.HandleFunc("POST /containers/{id}/pause", audited(audit.TARGET_CONTAINER, "id", s.locked(s.pauseContainer)))
+	mux.HandleFunc("POST /containers/{id}/unpause", audited(audit.TARGET_CONTAINER, "id", s.locked(s.unpauseContainer)))
+	mux.HandleFunc("GET /containers/{id}/logs", s.containerLogs)
+	mux.HandleFunc("GET /containers/{id}/attach", s.attachContainer)
+	mux.HandleFunc("POST /containers/{id}/exec", audited(audit.TARGET_CONTAINER, "id", s.execContainer))
+	mux.HandleFunc("DELETE /containers/{id}", audited(audit.TARGET_CONTAINER, "id", s.locked(s.removeContainer)))
+
+	mux.HandleFunc("GET /networks", s.listNetworks)
+	mux.HandleFunc("POST /networks/create", audited(audit.TARGET_NETWORK, "", s.locked(s.createNetwork)))
+	mux.HandleFunc("DELETE /networks/{name}", audited(audit.TARGET_NETWORK, "name", s.locked(s.removeNetwork)))
+
+	mux.HandleFunc("GET /images/json", s.listImages)
+	mux.HandleFunc("POST /build", audited(audit.TARGET_IMAGE, "t", s.buildImage))
+	mux.HandleFunc("POST /commit", audited(audit.TARGET_IMAGE, "ref", s.locked(s.commit)))
+	mux.HandleFunc("GET /images/verify", s.verifyImages)
+	mux.HandleFunc("GET /images/get", s.saveImages)
+	mux.HandleFunc("POST /images/load", audited("", "", s.locked(s.loadImages)))
+	mux.HandleFunc("GET /images/{name}/json", s.inspectImage)
+	mux.HandleFunc("POST /images/pull", audited(audit.TARGET_IMAGE, "name", s.locked(s.pullImage)))
+	mux.HandleFunc("POST /images/{name}/push", audited(audit.TARGET_IMAGE, "name", s.locked(s.pushImage)))
+	mux.HandleFunc("POST /images/{name}/tag", audited(audit.TARGET_IMAGE, "target", s.locked(s.tagImage)))
+	mux.HandleFunc("DELETE /images/{name}", audited(audit.TARGET_IMAGE, "name", s.locked(s.removeImage)))
+
+	// 登录的请求体中有密码，和命令行一样不记录审计日志
+	mux.HandleFunc("POST /auth", s.locked(s.login))
+	mux.HandleFunc("DELETE /auth", s.locked(s.logout))
+
+	mux.HandleFunc("GET /events", s.getEvents)
+	mux.HandleFunc("POST /system/reconcile", audited("", "", s.locked(s.reconcile)))
+	return mux
+}
+
+// Listen 监听 unix:///path 或 tcp://host:port，tlsConfig 不为 nil 时 TCP 连接使用 TLS。
+// 没有校验客户端证书的 TCP 端口任何能连接的用户都可以创建特权容器，回环地址上本机的
+// 普通用户也可以，因此默认拒绝。unsafeLoopback 为 true 时允许回环地址上不认证的 TCP，
+// 只用于调试
+func Listen(host string, tlsConfig *tls.Config, unsafeLoopback bool) (net.Listener, error) {
+	errFormat := "server.Listen: %w"
+	proto, addr, err := api.ParseHost(host)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if proto == "tcp" && !verifiesClients(tlsConfig) && !(unsafeLoopback && isLoopback(addr)) {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: refusing to listen on %s without --tlsverify", errdefs.ErrInvalidArgument, host))
+	}
+	if proto == "unix" {
+		// 删除上次异常退出残留的 socket 文件
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	}
+	l, err := net.Listen(proto, addr)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if proto == "unix" {
+		if err := os.Chmod(addr, 0660); err != nil {
+			l.Close()
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	}
+	if proto == "tcp" && tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	return l, nil
+}
+
+func verifiesClients(cfg *tls.Config) bool {
+	return cfg != nil && cfg.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server.Serve: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// locked 修改状态的请求持有 s.mu 执行
+func (s *Server) locked(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h(w, r)
+	}
+}
+
+// supervise 等待 daemon 启动的容器退出，回收进程并记录退出状态
+func (s *Server) supervise(id string) {
+	exitCode, err := s.rt.Wait(id)
+	if err != nil {
+		logrus.WithField("container", id).Errorln("supervise:", err)
+		return
+	}
+	logrus.WithFields(logrus.Fields{"container": id, "exitCode": exitCode}).Info("container exited")
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, "OK")
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	cs, err := s.rt.List(runtime.ListOptions{All: boolValue(r, "all")})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cs)
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) {
+	var req api.ContainerCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	// 没有 tty 的容器输出写入日志文件，tty 容器启动时接到客户端的连接上
+	c, err := s.rt.Create(runtime.CreateOptions{
+		Name:        req.Name,
+		Image:       req.Image,
+		Cmd:         req.Cmd,
+		TTY:         req.Tty,
+		Detach:      !req.Tty,
+		Volume:      req.Volume,
+		Env:         req.Env,
+		Network:     req.Network,
+		PortMapping: req.PortMapping,
+		Resources: &subsystems.ResourceConfig{
+			MemoryLimit: req.Memory,
+			Cpus:        req.Cpus,
+			CpuSet:      req.CpuSet,
+		},
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.ContainerCreateResponse{Id: c.Id})
+}
+
+func (s *Server) inspectContainer(w http.ResponseWriter, r *http.Request) {
+	c, err := s.rt.Inspect(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// startContainer 启动容器。请求带 Upgrade 头时接管连接，tty 容器的标准输入输出接到连接上，
+// 容器退出后关闭连接，客户端从容器的 exitCode 得到退出码。等待期间不持有 s.mu
+func (s *Server) startContainer(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	c, err := s.rt.Inspect(r.PathValue("id"))
+	if err != nil {
+		s.mu.Unlock()
+		writeError(w, err)
+		return
+	}
+	if !isUpgrade(r) {
+		defer s.mu.Unlock()
+		if c.TTY {
+			writeError(w, fmt.Errorf("%w: container %s has a tty, start it with attach", runtime.ErrInvalidArgument, c.Name))
+			return
+		}
+		if err := s.rt.Start(c.Id, runtime.StartOptions{}); err != nil {
+			writeError(w, err)
+			return
+		}
+		go s.supervise(c.Id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !c.TTY {
+		s.mu.Unlock()
+		writeError(w, fmt.Errorf("%w: container %s has no tty, start it without attach", runtime.ErrInvalidArgument, c.Name))
+		return
+	}
+	conn, buf, ok := hijack(w)
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	defer conn.Close()
+	err = s.rt.Start(c.Id, runtime.StartOptions{Stdin: buf, Stdout: conn, Stderr: conn})
+	s.mu.Unlock()
+	if err != nil {
+		fmt.Fprintln(conn, err)
+		return
+	}
+	if _, err := s.rt.Wait(c.Id); err != nil {
+		logrus.WithField("container", c.Id).Errorln("start:", err)
+	}
+}
+
+func isUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Connection"), "Upgrade") && r.Header.Get("Upgrade") == "tcp"
+}
+
+// hijack 接管连接并回复 101，之后连接上是原始的数据流。失败时已经写好了响应
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, bool) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, errors.New("connection does not support hijacking"))
+		return nil, nil, false
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		logrus.Errorln("hijack:", err)
+		return nil, nil, false
+	}
+	io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	return conn, buf, true
+}
+
+func (s *Server) stopContainer(w http.ResponseWriter, r *http.Request) {
+	opts := runtime.StopOptions{}
+	if v := r.URL.Query().Get("t"); v != "" {
+		t, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, fmt.Errorf("%w: t: %s", runtime.ErrInvalidArgument, err))
+			return
+		}
+		opts.Timeout = time.Duration(t) * time.Second
+	}
+	if err := s.rt.Stop(r.PathValue("id"), opts); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) killContainer(w http.ResponseWriter, r *http.Request) {
+	sig := unix.SIGKILL
+	if v := r.URL.Query().Get("signal"); v != "" {
+		sig = unix.SignalNum(v)
+		if sig == 0 {
+			writeError(w, fmt.Errorf("%w: unknown signal %s", runtime.ErrInvalidArgument, v))
+			return
+		}
+	}
+	if err := s.rt.Kill(r.PathValue("id"), sig); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) pauseContainer(w http.ResponseWriter, r *http.Request) {
+	if err := s.rt.Pause(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unpauseContainer(w http.ResponseWriter, r *http.Request) {
+	if err := s.rt.Unpause(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) containerLogs(w http.ResponseWriter, r *http.Request) {
+	rc, err := s.rt.Logs(r.PathValue("id"), runtime.LogsOptions{Follow: boolValue(r, "follow")})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// 客户端断开时关闭日志，结束 follow
+	go func() {
+		<-r.Context().Done()
+		rc.Close()
+	}()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(flushWriter{w}, rc)
+}
+
+func (s *Server) attachContainer(w http.ResponseWriter, r *http.Request) {
+	rc, err := s.rt.Attach(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	go func() {
+		<-r.Context().Done()
+		rc.Close()
+	}()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(flushWriter{w}, rc)
+}
+
+// execContainer 接管 HTTP 连接，之后连接上的数据就是命令的标准输入和输出（stdout 和 stderr 合并）
+func (s *Server) execContainer(w http.ResponseWriter, r *http.Request) {
+	var req api.ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	c, err := s.rt.Inspect(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	conn, buf, ok := hijack(w)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	err = s.rt.Exec(c.Id, runtime.ExecOptions{
+		Cmd:    req.Cmd,
+		Stdin:  buf,
+		Stdout: conn,
+		Stderr: conn,
+	})
+	if err != nil {
+		fmt.Fprintln(conn, err)
+	}
+}
+
+func (s *Server) removeContainer(w http.ResponseWriter, r *http.Request) {
+	if err := s.rt.Remove(r.PathValue("id"), runtime.RemoveOptions{Force: boolValue(r, "force")}); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listNetworks(w http.ResponseWriter, r *http.Request) {
+	networks, err := network.ListNetworks()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, networks)
+}
+
+func (s *Server) createNetwork(w http.ResponseWriter, r *http.Request) {
+	var req api.NetworkCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	if err := network.CreateNetwork(req.Driver, req.Name, req.Subnet); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) removeNetwork(w http.ResponseWriter, r *http.Request) {
+	if err := network.RemoveNetwork(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	images, err := image.List()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+func (s *Server) inspectImage(w http.ResponseWriter, r *http.Request) {
+	d, err := image.Inspect(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// verifyImages 重新计算所有 blob 的摘要，发现的问题放在响应中返回，不作为请求错误
+func (s *Server) verifyImages(w http.ResponseWriter, r *http.Request) {
+	checked, problems, err := image.Verify()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.ImageVerifyResponse{Checked: checked, Problems: problems})
+}
+
+// buildImage 请求体是构建上下文的 tar 归档，可以压缩。查询参数 t、dockerfile、buildarg、nocache
+// 和 mydocker build 的选项对应，dockerfile 是相对构建上下文的路径。
+// 构建进度按 StreamMessage 输出，解压上下文期间不持有 s.mu
+func (s *Server) buildImage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := os.MkdirAll(consts.PATH_IMAGE, 0755); err != nil {
+		writeError(w, err)
+		return
+	}
+	dir, err := os.MkdirTemp(consts.PATH_IMAGE, ".build-")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	rd, err := archive.DecompressStream(r.Body)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: build context: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	err = archive.Extract(rd, dir)
+	rd.Close()
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: build context: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	opts := builder.Options{
+		ContextDir: dir,
+		Tags:       query["t"],
+		BuildArgs:  map[string]string{},
+		NoCache:    boolValue(r, "nocache"),
+	}
+	if f := query.Get("dockerfile"); f != "" {
+		opts.Dockerfile = filepath.Join(dir, filepath.Clean("/"+f))
+	}
+	for _, a := range query["buildarg"] {
+		name, value, _ := strings.Cut(a, "=")
+		opts.BuildArgs[name] = value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	streamJSON(w, func(out io.Writer) error {
+		opts.Output = out
+		_, err := builder.Build(s.rt, opts)
+		return err
+	})
+}
+
+// commit 把查询参数 container 指定的容器提交为镜像 ref，参数和 mydocker commit 的选项对应
+func (s *Server) commit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ref := query.Get("ref")
+	if _, err := image.ParseTag(ref); err != nil {
+		writeError(w, err)
+		return
+	}
+	// pause 默认为 true，和命令行的 --pause 相同
+	pause := true
+	if v := query.Get("pause"); v != "" {
+		pause = boolValue(r, "pause")
+	}
+	img, err := s.rt.Commit(query.Get("container"), ref, runtime.CommitOptions{
+		Author:  query.Get("author"),
+		Message: query.Get("message"),
+		Changes: query["change"],
+		Pause:   pause,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, img)
+}
+
+// saveImages 把查询参数 names 中的镜像按 OCI image layout 打包输出
+func (s *Server) saveImages(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["names"]
+	if len(names) == 0 {
+		writeError(w, fmt.Errorf("%w: no image names", runtime.ErrInvalidArgument))
+		return
+	}
+	aw := &archiveWriter{w: w}
+	if err := image.Save(aw, names); err != nil {
+		if !aw.started {
+			writeError(w, err)
+			return
+		}
+		// 归档已经发送了一部分，中断连接，客户端读到不完整的响应而不是一个截断的归档
+		logrus.Errorln("save:", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// archiveWriter 第一次写入时才发送响应头，之前出现的错误仍然可以返回错误状态码
+type archiveWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	if !a.started {
+		a.started = true
+		a.w.Header().Set("Content-Type", "application/x-tar")
+		a.w.WriteHeader(http.StatusOK)
+	}
+	return a.w.Write(p)
+}
+
+// loadImages 导入请求体中 save 或 docker save 生成的归档，输出导入的镜像名
+func (s *Server) loadImages(w http.ResponseWriter, r *http.Request) {
+	streamJSON(w, func(out io.Writer) error {
+		images, err := image.Import(r.Body)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			fmt.Fprintln(out, "Loaded image:", img.Name)
+		}
+		return nil
+	})
+}
+
+// pullImage 从镜像仓库拉取查询参数 name 指定的镜像，进度按 StreamMessage 输出
+func (s *Server) pullImage(w http.ResponseWriter, r *http.Request) {
+	streamJSON(w, func(out io.Writer) error {
+		_, err := registry.Pull(r.URL.Query().Get("name"), registry.Options{Output: out})
+		return err
+	})
+}
+
+// pushImage 把镜像推送到名称中的仓库，进度按 StreamMessage 输出
+func (s *Server) pushImage(w http.ResponseWriter, r *http.Request) {
+	streamJSON(w, func(out io.Writer) error {
+		return registry.Push(r.PathValue("name"), registry.Options{Output: out})
+	})
+}
+
+// login 检查并保存 daemon 访问镜像仓库使用的凭据
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req api.AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeError(w, fmt.Errorf("%w: username and password are required", runtime.ErrInvalidArgument))
+		return
+	}
+	if err := registry.Login(req.ServerAddress, req.Username, req.Password, registry.Options{}); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logout 删除查询参数 server 指定的仓库的凭据
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if err := registry.Logout(r.URL.Query().Get("server")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tagImage 给镜像 name 增加查询参数 target 指定的引用
+func (s *Server) tagImage(w http.ResponseWriter, r *http.Request) {
+	if err := image.Tag(r.PathValue("name"), r.URL.Query().Get("target")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) removeImage(w http.ResponseWriter, r *http.Request) {
+	res, err := s.rt.RemoveImage(r.PathValue("name"), runtime.RemoveImageOptions{Force: boolValue(r, "force")})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// getEvents 按行输出 JSON 格式的事件，follow 为 true 时持续输出新的事件直到客户端断开
+func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+	var f events.Filter
+	var err error
+	if f.Since, err = events.ParseTime(query.Get("since"), now); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	if f.Until, err = events.ParseTime(query.Get("until"), now); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	if f.Filters, err = events.ParseFilters(query["filter"]); err != nil {
+		writeError(w, fmt.Errorf("%w: %s", runtime.ErrInvalidArgument, err))
+		return
+	}
+	var done <-chan struct{}
+	if boolValue(r, "follow") {
+		done = r.Context().Done()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(flushWriter{w})
+	if err := events.Follow(f, done, func(e *events.Event) error {
+		return enc.Encode(e)
+	}); err != nil {
+		logrus.Errorln("events:", err)
+	}
+}
+
+// reconcile 修复残留的容器、网络和挂载状态，与 daemon 周期性执行的不同，这里做完整的检查
+func (s *Server) reconcile(w http.ResponseWriter, r *http.Request) {
+	if err := s.rt.Reconcile(true); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func boolValue(r *http.Request, key string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorln("writeJSON:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	resp := api.ErrorResponse{Message: err.Error()}
+	if c := errdefs.Category(err); c != nil {
+		resp.Kind = c.Error()
+	}
+	writeJSON(w, statusCode(err), resp)
+}
+
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errdefs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errdefs.ErrInvalidArgument), errors.Is(err, errdefs.ErrAmbiguous):
+		return http.StatusBadRequest
+	case errors.Is(err, errdefs.ErrConflict), errors.Is(err, errdefs.ErrNotRunning), errors.Is(err, errdefs.ErrAlreadyExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// streamJSON 把 fn 写入的输出逐次作为 StreamMessage 发送，fn 返回的错误作为最后一条消息
+func streamJSON(w http.ResponseWriter, fn func(out io.Writer) error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	sw := &streamWriter{enc: json.NewEncoder(flushWriter{w})}
+	if err := fn(sw); err != nil {
+		streamFailed(w, err)
+		msg := api.StreamMessage{Error: err.Error()}
+		if c := errdefs.Category(err); c != nil {
+			msg.Kind = c.Error()
+		}
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		if err := sw.enc.Encode(msg); err != nil {
+			logrus.Errorln("streamJSON:", err)
+		}
+	}
+}
+
+// streamWriter 把每次写入编码为一条 StreamMessage，RUN 步骤的 stdout 和 stderr 可能并发写入
+type streamWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(api.StreamMessage{Stream: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flushWriter 每次写入后立即 flush，用于流式输出日志
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
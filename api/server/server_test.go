@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/client"
+)
+
+func TestListenMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := api.GenerateCerts(dir, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	// 另一个 CA 签发的证书不被 daemon 信任
+	other := t.TempDir()
+	if err := api.GenerateCerts(other, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(api.ENV_CERT_PATH, dir)
+	serverConfig, err := api.ServerTLSConfig(api.TLSOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("tcp://127.0.0.1:0", serverConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+	host := "tcp://" + l.Addr().String()
+
+	tests := []struct {
+		name    string
+		opts    api.TLSOptions
+		noCert  bool
+		wantErr bool
+	}{
+		{name: "trusted certificate", opts: api.TLSOptions{Verify: true}},
+		{name: "untrusted certificate", opts: api.TLSOptions{Verify: true, CertFile: other + "/cert.pem", KeyFile: other + "/key.pem"}, wantErr: true},
+		{name: "no certificate", opts: api.TLSOptions{Verify: true}, noCert: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig, err := api.ClientTLSConfig(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.noCert {
+				clientConfig.Certificates = nil
+			}
+			c, err := client.New(host, clientConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Ping(); (err != nil) != tt.wantErr {
+				t.Errorf("Ping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("plain http", func(t *testing.T) {
+		c, err := client.New(host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Ping(); err == nil {
+			t.Error("Ping() without TLS succeeded")
+		}
+	})
+}
+
+func TestListenRefusesUnauthenticatedTCP(t *testing.T) {
+	tests := []struct {
+		host           string
+		unsafeLoopback bool
+		wantErr        bool
+	}{
+		{host: "tcp://0.0.0.0:0", wantErr: true},
+		{host: "tcp://127.0.0.1:0", wantErr: true},
+		{host: "tcp://0.0.0.0:0", unsafeLoopback: true, wantErr: true},
+		{host: "tcp://127.0.0.1:0", unsafeLoopback: true},
+	}
+	for _, tt := range tests {
+		l, err := Listen(tt.host, nil, tt.unsafeLoopback)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "--tlsverify") {
+				t.Errorf("Listen(%s, %v) error = %v, want refusal", tt.host, tt.unsafeLoopback, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Listen(%s, %v) error = %v", tt.host, tt.unsafeLoopback, err)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	ENV_CERT_PATH  = "MYDOCKER_CERT_PATH"
+	ENV_TLS_VERIFY = "MYDOCKER_TLS_VERIFY"
+
+	// 证书目录中的默认文件名，与 GenerateCerts 生成的文件一致
+	CA_CERT_FILE     = "ca.pem"
+	CA_KEY_FILE      = "ca-key.pem"
+	SERVER_CERT_FILE = "server-cert.pem"
+	SERVER_KEY_FILE  = "server-key.pem"
+	CLIENT_CERT_FILE = "cert.pem"
+	CLIENT_KEY_FILE  = "key.pem"
+)
+
+// TLSOptions 对应命令行的 --tlscacert/--tlscert/--tlskey/--tlsverify，
+// 文件路径为空时使用 DefaultCertPath 下的默认文件
+type TLSOptions struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Verify   bool
+}
+
+// DefaultCertPath 返回 $MYDOCKER_CERT_PATH，没有设置时返回 ~/.mydocker
+func DefaultCertPath() string {
+	if p := os.Getenv(ENV_CERT_PATH); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/root"
+	}
+	return filepath.Join(home, ".mydocker")
+}
+
+// Enabled 指定了 --tlsverify 或证书文件时启用 TLS
+func (o TLSOptions) Enabled() bool {
+	return o.Verify || o.CertFile != "" || o.KeyFile != ""
+}
+
+func (o TLSOptions) withDefaults(certFile, keyFile string) TLSOptions {
+	dir := DefaultCertPath()
+	if o.CAFile == "" {
+		o.CAFile = filepath.Join(dir, CA_CERT_FILE)
+	}
+	if o.CertFile == "" {
+		o.CertFile = filepath.Join(dir, certFile)
+	}
+	if o.KeyFile == "" {
+		o.KeyFile = filepath.Join(dir, keyFile)
+	}
+	return o
+}
+
+// ServerTLSConfig 返回 daemon 使用的 TLS 配置，Verify 为 true 时只接受持有 CA 签发的客户端证书的连接
+func ServerTLSConfig(o TLSOptions) (*tls.Config, error) {
+	errFormat := "api.ServerTLSConfig: %w"
+	o = o.withDefaults(SERVER_CERT_FILE, SERVER_KEY_FILE)
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.Verify {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientTLSConfig 返回客户端使用的 TLS 配置，Verify 为 true 时用 CA 校验 daemon 的证书，
+// 证书文件存在时向 daemon 出示客户端证书
+func ClientTLSConfig(o TLSOptions) (*tls.Config, error) {
+	errFormat := "api.ClientTLSConfig: %w"
+	explicitCert := o.CertFile != "" || o.KeyFile != ""
+	o = o.withDefaults(CLIENT_CERT_FILE, CLIENT_KEY_FILE)
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.Verify {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		cfg.RootCAs = pool
+	} else {
+		cfg.InsecureSkipVerify = true
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		// 默认位置没有客户端证书时不出示证书，由 daemon 决定是否拒绝
+		if explicitCert || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	} else {
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	bs, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+	return pool, nil
+}
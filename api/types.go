@@ -0,0 +1,85 @@
+// Package api 定义 mydocker daemon HTTP API 的请求和响应结构，服务端和客户端共用。
+// 接口路径参考 Docker Engine API 的一个子集
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DEFAULT_HOST = "unix:///run/mydocker.sock"
+	ENV_HOST     = "MYDOCKER_HOST"
+)
+
+type ContainerCreateRequest struct {
+	Name        string   `json:"name"`
+	Image       string   `json:"image"`
+	Cmd         []string `json:"cmd"`
+	Env         []string `json:"env"`
+	Volume      string   `json:"volume"`
+	Network     string   `json:"network"`
+	PortMapping []string `json:"portMapping"`
+	Memory      string   `json:"memory"`
+	Cpus        string   `json:"cpus"`
+	CpuSet      string   `json:"cpuset"`
+	// Tty 为 true 时容器不写日志，需要通过 attach 方式的 start 把标准输入输出接到客户端
+	Tty bool `json:"tty"`
+}
+
+type ContainerCreateResponse struct {
+	Id string `json:"id"`
+}
+
+type ExecRequest struct {
+	Cmd []string `json:"cmd"`
+}
+
+type NetworkCreateRequest struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Subnet string `json:"subnet"`
+}
+
+// AuthRequest 登录镜像仓库的凭据，ServerAddress 为空表示 Docker Hub
+type AuthRequest struct {
+	ServerAddress string `json:"serveraddress"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+}
+
+// ImageVerifyResponse 是检查镜像存储的结果，Problems 为空表示全部通过
+type ImageVerifyResponse struct {
+	Checked  int      `json:"checked"`
+	Problems []string `json:"problems"`
+}
+
+// StreamMessage 是 build 等耗时操作按行输出的 JSON 消息。响应头发送之后出现的错误
+// 放在最后一条消息的 Error 和 Kind 中，含义和 ErrorResponse 相同
+type StreamMessage struct {
+	Stream string `json:"stream,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+}
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+	// Kind 是错误所属的 errdefs 分类，客户端据此还原错误分类
+	Kind string `json:"kind,omitempty"`
+}
+
+// ParseHost 解析 unix:///path 或 tcp://host:port 形式的地址
+func ParseHost(host string) (proto, addr string, err error) {
+	errFormat := "api.ParseHost %s: %w"
+	proto, addr, ok := strings.Cut(host, "://")
+	if !ok || addr == "" {
+		return "", "", fmt.Errorf(errFormat, host, errors.New("host must be unix:///path or tcp://host:port"))
+	}
+	switch proto {
+	case "unix", "tcp":
+		return proto, addr, nil
+	default:
+		return "", "", fmt.Errorf(errFormat, host, fmt.Errorf("unsupported protocol %q", proto))
+	}
+}
@@ -0,0 +1,278 @@
+// Package archive 解压和打包镜像层的 tar 归档。
+//
+// 层的内容来自镜像仓库或用户导入的文件，不可信：解压时拒绝超出目标目录的路径和硬链接，
+// 路径中的符号链接按目标目录为根解析，绝对路径或 .. 的链接也不会把文件写到目录之外。
+// OCI 的 whiteout 文件在解压时转换为 overlay 的格式，打包时再转换回来
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// WHITEOUT_PREFIX OCI 层中表示删除文件的前缀，.wh.name 表示删除下层的 name
+	WHITEOUT_PREFIX = ".wh."
+	// WHITEOUT_OPAQUE 表示目录不透明，下层中该目录的内容都被隐藏
+	WHITEOUT_OPAQUE = WHITEOUT_PREFIX + ".wh..opq"
+	// MAX_SYMLINKS 解析一个路径时最多经过的符号链接数，和 Linux 的限制相同
+	MAX_SYMLINKS = 40
+	// PAX_XATTR_PREFIX PAX 扩展头中记录扩展属性的前缀
+	PAX_XATTR_PREFIX = "SCHILY.xattr."
+)
+
+var (
+	// ErrUnsafePath 归档中的路径或硬链接超出了解压的目录
+	ErrUnsafePath = errors.New("unsafe path in archive")
+	// ErrUnsupported 不支持的压缩格式或归档条目类型
+	ErrUnsupported = errors.New("unsupported archive")
+)
+
+// overlay 用 xattr 标记不透明目录，挂载时使用 userxattr 选项的 overlay 使用 user. 前缀
+var opaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// overlayXattr 判断扩展属性是否是 overlay 自己使用的，这些属性不能从归档中恢复，
+// 否则层可以伪造不透明目录或重定向，不透明目录只能来自 .wh..wh..opq
+func overlayXattr(attr string) bool {
+	return strings.HasPrefix(attr, "trusted.overlay.") || strings.HasPrefix(attr, "user.overlay.")
+}
+
+// Extract 把 r 中的 tar 归档解压到已经存在的目录 dir，读到归档的结束标记为止。
+// 保留归档中的权限、属主、修改时间和扩展属性，不是 root 时不设置属主。
+// .wh.name 转换为设备号为 0/0 的字符设备 name，.wh..wh..opq 转换为所在目录的
+// trusted.overlay.opaque 属性
+func Extract(r io.Reader, dir string) error {
+	errFormat := "archive.Extract: %w"
+	tr := tar.NewReader(r)
+	// 目录的修改时间在其中的文件都解压之后再设置
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if err := extractEntry(tr, hdr, dir); err != nil {
+			return fmt.Errorf(errFormat, fmt.Errorf("%s: %w", hdr.Name, err))
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := cleanName(dirs[i].Name)
+		target, err := ResolveInRoot(dir, name)
+		if err != nil {
+			return fmt.Errorf(errFormat, fmt.Errorf("%s: %w", dirs[i].Name, err))
+		}
+		if err := setTimes(target, dirs[i]); err != nil {
+			return fmt.Errorf(errFormat, fmt.Errorf("%s: %w", dirs[i].Name, err))
+		}
+	}
+	return nil
+}
+
+// cleanName 把归档中的路径整理为相对路径，开头的 / 去掉，超出根目录的 .. 返回 ErrUnsafePath
+func cleanName(name string) (string, error) {
+	p := path.Clean(strings.TrimLeft(name, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return p, nil
+}
+
+// ResolveInRoot 返回相对路径 name 在 root 下的实际路径。路径中已经存在的符号链接按 root
+// 为根解析：绝对路径的链接从 root 开始，.. 最多回到 root，因此结果总在 root 之内
+func ResolveInRoot(root, name string) (string, error) {
+	cur, rest := "/", name
+	for links := 0; rest != ""; {
+		var comp string
+		comp, rest, _ = strings.Cut(rest, "/")
+		next := path.Join(cur, comp)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.Join(root, path.Join(next, rest)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		if links++; links > MAX_SYMLINKS {
+			return "", fmt.Errorf("%s: %w", name, unix.ELOOP)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			cur = "/"
+		}
+		rest = strings.TrimLeft(path.Join(target, rest), "/")
+	}
+	return filepath.Join(root, cur), nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, root string) error {
+	name, err := cleanName(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		// 归档中的根目录，只设置属性
+		if hdr.Typeflag != tar.TypeDir {
+			return fmt.Errorf("%w: root is not a directory", ErrUnsafePath)
+		}
+		return setAttrs(root, hdr)
+	}
+	// 父目录中的符号链接在根目录内解析，最后一个分量就是要创建的文件本身
+	parent, err := ResolveInRoot(root, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	base := path.Base(name)
+	if base == WHITEOUT_OPAQUE {
+		return unix.Setxattr(parent, opaqueXattrs[0], []byte("y"), 0)
+	}
+	if strings.HasPrefix(base, WHITEOUT_PREFIX) {
+		deleted := strings.TrimPrefix(base, WHITEOUT_PREFIX)
+		// .wh.. 和 .wh... 会指向父目录本身或者父目录的上一级
+		if deleted == "" || deleted == "." || deleted == ".." || strings.Contains(deleted, "/") {
+			return fmt.Errorf("%w: whiteout %s", ErrUnsafePath, hdr.Name)
+		}
+		target := filepath.Join(parent, deleted)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		return unix.Mknod(target, unix.S_IFCHR, 0)
+	}
+
+	target := filepath.Join(parent, base)
+	// 归档中靠后的条目覆盖前面的同名条目，目录之间合并
+	if fi, err := os.Lstat(target); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// 链接的内容原样保留，只在解析路径时按根目录解释
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		link, err := cleanName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		linkParent, err := ResolveInRoot(root, path.Dir(link))
+		if err != nil {
+			return err
+		}
+		src := filepath.Join(linkParent, path.Base(link))
+		if fi, err := os.Lstat(src); err != nil {
+			return err
+		} else if fi.IsDir() {
+			return fmt.Errorf("%w: hard link to directory %s", ErrUnsafePath, hdr.Linkname)
+		}
+		// 硬链接和源文件共用 inode，属性已经在源文件上设置过
+		return os.Link(src, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			mode = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			mode = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, mode|0600, int(dev)); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return fmt.Errorf("%w: entry type %q", ErrUnsupported, hdr.Typeflag)
+	}
+	if err := setAttrs(target, hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setTimes(target, hdr)
+}
+
+// setAttrs 设置属主、扩展属性和权限。修改属主会清除 setuid 位，所以最后设置权限
+func setAttrs(target string, hdr *tar.Header) error {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	for key, value := range hdr.PAXRecords {
+		attr, ok := strings.CutPrefix(key, PAX_XATTR_PREFIX)
+		if !ok || overlayXattr(attr) {
+			continue
+		}
+		// 文件系统不支持或者没有权限设置的扩展属性忽略
+		err := unix.Lsetxattr(target, attr, []byte(value), 0)
+		if err != nil && !errors.Is(err, unix.ENOTSUP) && !errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("set xattr %s: %w", attr, err)
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	mode := hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	return os.Chmod(target, mode)
+}
+
+// setTimes 设置修改时间，符号链接设置链接本身的时间
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{timespec(atime), timespec(hdr.ModTime)}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func timespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		return unix.Timespec{Nsec: unix.UTIME_OMIT}
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
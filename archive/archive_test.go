@@ -0,0 +1,276 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+)
+
+type entry struct {
+	hdr     tar.Header
+	content string
+}
+
+func makeTar(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	outside := t.TempDir()
+	tests := []struct {
+		name    string
+		entries []entry
+		// escaped 解压后不能出现在 outside 中的文件
+		escaped string
+		wantErr error
+	}{
+		{
+			name:    "dot dot",
+			entries: []entry{{hdr: tar.Header{Name: "../../evil", Typeflag: tar.TypeReg}, content: "x"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name: "hard link outside",
+			entries: []entry{
+				{hdr: tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+			},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name: "absolute symlink parent",
+			entries: []entry{
+				{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside}},
+				{hdr: tar.Header{Name: "link/evil", Typeflag: tar.TypeReg}, content: "x"},
+			},
+			escaped: "evil",
+		},
+		{
+			name: "relative symlink parent",
+			entries: []entry{
+				{hdr: tar.Header{Name: "a/up", Typeflag: tar.TypeSymlink, Linkname: "../../../../../../../../" + outside}},
+				{hdr: tar.Header{Name: "a/up/evil2", Typeflag: tar.TypeReg}, content: "x"},
+			},
+			escaped: "evil2",
+		},
+		{
+			name: "hard link through symlink",
+			entries: []entry{
+				{hdr: tar.Header{Name: "root", Typeflag: tar.TypeSymlink, Linkname: "/"}},
+				{hdr: tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "root/etc/passwd"}},
+			},
+			wantErr: os.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := Extract(bytes.NewReader(makeTar(t, tt.entries)), dir)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Extract() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, tt.escaped)); err == nil {
+				t.Fatalf("%s was written outside the directory", tt.escaped)
+			}
+			// 链接按解压目录为根解析
+			if _, err := os.Lstat(filepath.Join(dir, outside, tt.escaped)); err != nil {
+				t.Errorf("%s is not inside the directory: %v", tt.escaped, err)
+			}
+		})
+	}
+}
+
+func TestExtractUnsafeWhiteout(t *testing.T) {
+	for _, name := range []string{".wh..", ".wh...", "sub/.wh..", "sub/.wh..."} {
+		t.Run(name, func(t *testing.T) {
+			// root 的兄弟目录代表共享层目录中的其他层
+			parent := t.TempDir()
+			root := filepath.Join(parent, "root")
+			sibling := filepath.Join(parent, "sibling")
+			for _, d := range []string{root, sibling} {
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data := makeTar(t, []entry{{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}}})
+			if err := Extract(bytes.NewReader(data), root); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("Extract() = %v, want ErrUnsafePath", err)
+			}
+			for _, d := range []string{root, sibling} {
+				if fi, err := os.Lstat(d); err != nil || !fi.IsDir() {
+					t.Errorf("%s was removed: %v", d, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := makeTar(t, []entry{
+		{hdr: tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime}},
+		{hdr: tar.Header{Name: "bin/su", Typeflag: tar.TypeReg, Mode: 04755, ModTime: mtime, Uid: 1, Gid: 2}, content: "su"},
+		{hdr: tar.Header{Name: "bin/passwd", Typeflag: tar.TypeLink, Linkname: "bin/su"}},
+		{hdr: tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "/bin/busybox", ModTime: mtime}},
+		{hdr: tar.Header{Name: "run/pipe", Typeflag: tar.TypeFifo, Mode: 0600}},
+		{hdr: tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg}, content: "old"},
+		{hdr: tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg}, content: "new"},
+		{hdr: tar.Header{Name: "etc/.wh.issue", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "var/cache/.wh..wh..opq", Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "xattr", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.note": "hi"}}},
+		{hdr: tar.Header{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0755, PAXRecords: map[string]string{
+			"SCHILY.xattr.trusted.overlay.opaque": "y",
+			"SCHILY.xattr.user.overlay.opaque":    "y",
+		}}},
+	})
+	dir := t.TempDir()
+	if err := Extract(bytes.NewReader(data), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "bin/su"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0755|os.ModeSetuid || !fi.ModTime().Equal(mtime) {
+		t.Errorf("bin/su mode = %v, mtime = %v", fi.Mode(), fi.ModTime())
+	}
+	if os.Geteuid() == 0 {
+		if st := fi.Sys().(*syscall.Stat_t); st.Uid != 1 || st.Gid != 2 {
+			t.Errorf("bin/su owner = %d:%d, want 1:2", st.Uid, st.Gid)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "bin")); err != nil || fi.Mode().Perm() != 0750 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("bin = %v, %v", fi, err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, "bin/passwd")); err != nil || !os.SameFile(fi, st) {
+		t.Errorf("bin/passwd is not a hard link of bin/su: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "bin/sh")); err != nil || link != "/bin/busybox" {
+		t.Errorf("bin/sh -> %q, %v", link, err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "run/pipe")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("run/pipe = %v, %v", fi, err)
+	}
+	if bs, err := os.ReadFile(filepath.Join(dir, "etc/motd")); err != nil || string(bs) != "new" {
+		t.Errorf("etc/motd = %q, %v", bs, err)
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(dir, "etc/issue"), &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != 0 {
+		t.Errorf("etc/issue is not a whiteout: %+v, %v", st, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "etc/.wh.issue")); err == nil {
+		t.Error("etc/.wh.issue was extracted")
+	}
+	if !isOpaque(filepath.Join(dir, "var/cache")) {
+		t.Error("var/cache is not opaque")
+	}
+	// overlay 的属性只能由 whiteout 生成，归档中记录的不恢复
+	if isOpaque(filepath.Join(dir, "lib")) {
+		t.Error("lib became opaque from its xattr records")
+	}
+	buf := make([]byte, 8)
+	if n, err := unix.Lgetxattr(filepath.Join(dir, "xattr"), "user.note", buf); err == nil && string(buf[:n]) != "hi" {
+		t.Errorf("user.note = %q", buf[:n])
+	}
+}
+
+func TestDecompressStream(t *testing.T) {
+	data := makeTar(t, []entry{{hdr: tar.Header{Name: "f", Typeflag: tar.TypeReg}, content: "content"}})
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll(data, nil)
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{name: "plain", input: data, want: COMPRESSION_NONE},
+		{name: "gzip", input: gz.Bytes(), want: COMPRESSION_GZIP},
+		{name: "zstd", input: zst, want: COMPRESSION_ZSTD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCompression(bufio.NewReader(bytes.NewReader(tt.input))); got != tt.want {
+				t.Errorf("DetectCompression() = %q, want %q", got, tt.want)
+			}
+			r, err := DecompressStream(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("DecompressStream() = %d bytes, %v", len(got), err)
+			}
+		})
+	}
+}
+
+func TestTar(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "sub/f"), []byte("content"), 0640)
+	os.Symlink("sub/f", filepath.Join(src, "link"))
+	// 普通目录中的 .wh. 文件不是 whiteout，原样打包
+	os.WriteFile(filepath.Join(src, ".wh.keep"), nil, 0644)
+	var buf bytes.Buffer
+	if err := Tar(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{".wh.keep", "link", "sub/", "sub/f"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Tar() entries = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩格式，和 OCI 层媒体类型的后缀相同
+const (
+	COMPRESSION_NONE = ""
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression 根据开头的魔数判断 br 中数据的压缩格式，不消耗数据
+func DetectCompression(br *bufio.Reader) string {
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return COMPRESSION_GZIP
+	case bytes.HasPrefix(magic, zstdMagic):
+		return COMPRESSION_ZSTD
+	default:
+		return COMPRESSION_NONE
+	}
+}
+
+// DecompressStream 返回 r 解压后的数据，未压缩的数据原样返回。
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	switch DetectCompression(br) {
+	case COMPRESSION_GZIP:
+		return gzip.NewReader(br)
+	case COMPRESSION_ZSTD:
+		return zstdReader(br)
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+func zstdReader(r io.Reader) (io.ReadCloser, error) {
+	// 层是顺序读取的流，单个 goroutine 解码就够了
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// WriteDiff 把 overlay 的 upper 目录打包成 OCI 层写入 w。upper 中主设备号和次设备号都为 0 的
+// 字符设备是 overlay 删除文件的标记，转换为 .wh. 文件；不透明目录增加 .wh..wh..opq 文件
+func WriteDiff(w io.Writer, upper string) error {
+	if err := writeTar(w, upper, true); err != nil {
+		return fmt.Errorf("archive.WriteDiff: %w", err)
+	}
+	return nil
+}
+
+// Tar 把普通目录 dir 原样打包写入 w，用于发送构建上下文
+func Tar(w io.Writer, dir string) error {
+	if err := writeTar(w, dir, false); err != nil {
+		return fmt.Errorf("archive.Tar: %w", err)
+	}
+	return nil
+}
+
+// writeTar 打包 dir 中的内容，overlay 为 true 时按 overlay 的格式转换 whiteout
+func writeTar(w io.Writer, dir string, overlay bool) error {
+	tw := tar.NewWriter(w)
+	// 硬链接的第一个文件正常写入，之后的文件写为指向它的链接
+	links := map[[2]uint64]string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st, _ := fi.Sys().(*syscall.Stat_t)
+		if overlay && fi.Mode()&fs.ModeCharDevice != 0 && st != nil && st.Rdev == 0 {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     filepath.Join(filepath.Dir(rel), WHITEOUT_PREFIX+filepath.Base(rel)),
+				Mode:     0600,
+				ModTime:  fi.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+		if fi.Mode()&fs.ModeSocket != 0 {
+			return nil
+		}
+		link := ""
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		hdr.Format = tar.FormatPAX
+		// 不记录宿主机上的用户名，容器里的名称可能不同
+		hdr.Uname, hdr.Gname = "", ""
+		if hdr.PAXRecords, err = xattrRecords(p); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if st != nil {
+			hdr.Uid, hdr.Gid = int(st.Uid), int(st.Gid)
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if fi.Mode().IsRegular() && st.Nlink > 1 {
+				if first, ok := links[key]; ok {
+					hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+				} else {
+					links[key] = rel
+				}
+			}
+		}
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if overlay && isOpaque(p) {
+				return tw.WriteHeader(&tar.Header{
+					Typeflag: tar.TypeReg,
+					Name:     filepath.Join(rel, WHITEOUT_OPAQUE),
+					Mode:     0600,
+					ModTime:  fi.ModTime(),
+					Format:   tar.FormatPAX,
+				})
+			}
+			return nil
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func isOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	for _, attr := range opaqueXattrs {
+		if n, err := unix.Lgetxattr(dir, attr, buf); err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// xattrRecords 把文件的扩展属性转换为 PAX 扩展头，overlay 自己使用的属性不记录
+func xattrRecords(p string) (map[string]string, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if errors.Is(err, unix.ENOTSUP) || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(p, buf); err != nil {
+		return nil, err
+	}
+	var records map[string]string
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if attr == "" || overlayXattr(attr) {
+			continue
+		}
+		n, err := unix.Lgetxattr(p, attr, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(p, attr, value); err != nil {
+			return nil, err
+		}
+		if records == nil {
+			records = map[string]string{}
+		}
+		records[PAX_XATTR_PREFIX+attr] = string(value[:n])
+	}
+	return records, nil
+}
@@ -0,0 +1,423 @@
+// Package audit 记录修改状态的操作（谁在什么时候对哪个容器、网络或镜像做了什么，结果如何），
+// 用于在多人共用的宿主机上追查操作来源。
+//
+// 审计日志是数据目录下的 JSON Lines 文件，每条记录带有序号、上一条记录的哈希和自身的哈希，
+// 组成一条哈希链：修改、删除或插入中间的任意一条记录都会让 Verify 失败。
+// 截断日志末尾无法从日志本身发现，需要把 Verify 返回的最后一个哈希另外保存用于比对
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+const (
+	SOURCE_CLI = "cli"
+	SOURCE_API = "api"
+
+	TARGET_CONTAINER = "container"
+	TARGET_NETWORK   = "network"
+	TARGET_IMAGE     = "image"
+
+	RESULT_SUCCESS = "success"
+	RESULT_FAILURE = "failure"
+)
+
+// REDACTED 审计记录中代替环境变量和构建参数取值的内容
+const REDACTED = "***"
+
+// tailSize 查找最后一条记录时每次从文件末尾向前读取的字节数
+const tailSize = 4096
+
+var ErrTampered = errors.New("audit log has been tampered with")
+
+type Record struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Source 操作来自本地命令行还是 daemon 的 API
+	Source string `json:"source"`
+	// UID 执行操作的用户，API 请求为 unix socket 对端进程的用户，无法获取时为 -1
+	UID      int    `json:"uid"`
+	User     string `json:"user,omitempty"`
+	SudoUser string `json:"sudoUser,omitempty"`
+	// Remote API 请求的客户端地址，使用 TLS 客户端证书时为证书的 CN
+	Remote     string   `json:"remote,omitempty"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args,omitempty"`
+	TargetType string   `json:"targetType,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Result     string   `json:"result"`
+	Error      string   `json:"error,omitempty"`
+	PrevHash   string   `json:"prevHash"`
+	Hash       string   `json:"hash"`
+}
+
+func (r *Record) String() string {
+	who := r.User
+	if who == "" {
+		who = strconv.Itoa(r.UID)
+	}
+	if r.SudoUser != "" {
+		who += "(sudo " + r.SudoUser + ")"
+	}
+	if r.Remote != "" {
+		who += "@" + r.Remote
+	}
+	s := fmt.Sprintf("%s %d %s %s %s", r.Time.Format(time.RFC3339Nano), r.Seq, r.Source, who, r.Command)
+	if r.Target != "" {
+		s += " " + r.TargetType + "=" + r.Target
+	}
+	s += " " + r.Result
+	if r.Error != "" {
+		s += ": " + r.Error
+	}
+	return s
+}
+
+// hash 计算记录的哈希，覆盖除 Hash 之外的所有字段，包括 PrevHash
+func (r *Record) hash() (string, error) {
+	c := *r
+	c.Hash = ""
+	bs, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RedactVar 把 KEY=value 中的值替换为 REDACTED，环境变量和构建参数中常有密码和令牌。
+// 只有 KEY 时原样返回
+func RedactVar(kv string) string {
+	if key, _, ok := strings.Cut(kv, "="); ok {
+		return key + "=" + REDACTED
+	}
+	return kv
+}
+
+// RedactArgs 返回命令行参数的副本，其中 flags 列出的选项（不含 -）的取值经过 RedactVar 处理，
+// 支持 -e KEY=v、--e KEY=v 和 -e=KEY=v 的写法
+func RedactArgs(args []string, flags ...string) []string {
+	out := slices.Clone(args)
+	for i := 0; i < len(out); i++ {
+		if !strings.HasPrefix(out[i], "-") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(out[i], "-"), "=")
+		if !slices.Contains(flags, name) {
+			continue
+		}
+		if ok {
+			out[i] = strings.TrimSuffix(out[i], value) + RedactVar(value)
+		} else if i+1 < len(out) {
+			i++
+			out[i] = RedactVar(out[i])
+		}
+	}
+	return out
+}
+
+// CurrentUser 返回当前进程的用户和 sudo 前的原始用户，用于填充命令行操作的记录
+func CurrentUser() (uid int, name, sudoUser string) {
+	uid = os.Getuid()
+	return uid, lookupUser(uid), os.Getenv("SUDO_USER")
+}
+
+// lookupUser 返回 uid 对应的用户名，查不到时返回空字符串
+func lookupUser(uid int) string {
+	if uid < 0 {
+		return ""
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// Log 追加一条记录，Time、Seq 和哈希由 Append 填充。写入失败时返回错误，
+// 操作没有留下审计记录，调用方应当让命令失败
+func Log(r *Record, err error) error {
+	r.Result = RESULT_SUCCESS
+	if err != nil {
+		r.Result = RESULT_FAILURE
+		r.Error = err.Error()
+	}
+	if r.User == "" {
+		r.User = lookupUser(r.UID)
+	}
+	return Append(r)
+}
+
+// Check 检查审计日志能否追加记录，修改状态的操作执行之前调用，日志无法写入时不执行操作
+func Check() error {
+	f, unlock, err := openLog()
+	if err != nil {
+		return fmt.Errorf("audit.Check: %w", err)
+	}
+	defer unlock()
+	defer f.Close()
+	if _, err := lastRecord(f); err != nil {
+		return fmt.Errorf("audit.Check: %w", err)
+	}
+	return nil
+}
+
+// openLog 持有文件锁打开审计日志，并去掉末尾写了一半的记录
+func openLog() (*os.File, func(), error) {
+	unlock, err := utils.LockFile(consts.PATH_AUDIT_LOCK)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(consts.PATH_AUDIT, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	if err := dropTornWrite(f); err != nil {
+		f.Close()
+		unlock()
+		return nil, nil, err
+	}
+	return f, unlock, nil
+}
+
+// dropTornWrite 截掉文件末尾没有以换行结束的内容。每条记录连同换行一次写入，
+// 没有换行说明写入时进程崩溃或者磁盘已满，这条记录不完整，留着会让之后的追加都失败
+func dropTornWrite(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	end := size
+	for end > 0 {
+		n := min(int64(tailSize), end)
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, end-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if end == size {
+		return nil
+	}
+	logrus.Warnf("audit: dropping an incomplete record of %d bytes at the end of %s", size-end, f.Name())
+	return f.Truncate(end)
+}
+
+// Append 在文件锁保护下读取最后一条记录，链接到它之后追加 r 并 fsync
+func Append(r *Record) error {
+	errFormat := "audit.Append: %w"
+	f, unlock, err := openLog()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	defer f.Close()
+	last, err := lastRecord(f)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	if last != nil {
+		r.Seq = last.Seq + 1
+		r.PrevHash = last.Hash
+	} else {
+		r.Seq = 1
+		r.PrevHash = ""
+	}
+	if r.Hash, err = r.hash(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// lastRecord 从文件末尾向前查找最后一条完整的记录，文件为空时返回 nil
+func lastRecord(f *os.File) (*Record, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := fi.Size()
+	var buf []byte
+	for end > 0 || len(buf) > 0 {
+		// 去掉末尾的换行后，buf 中最后一个换行之后的内容就是最后一行
+		line := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 || end == 0 {
+			line = line[i+1:]
+			if len(line) == 0 {
+				return nil, nil
+			}
+			var r Record
+			if err := json.Unmarshal(line, &r); err != nil {
+				return nil, fmt.Errorf("%w: bad last record: %v", ErrTampered, err)
+			}
+			return &r, nil
+		}
+		n := min(int64(tailSize), end)
+		end -= n
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, end); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+	}
+	return nil, nil
+}
+
+// Filter 查询条件，零值不限制
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	// User 匹配用户名、sudo 前的用户名或 uid
+	User string
+	// Command 匹配包含该字符串的命令，如 network 匹配 network create 和 API 请求 POST /networks/create
+	Command string
+	// Target 匹配目标名称或 ID 前缀
+	Target string
+	// Failed 只返回失败的操作
+	Failed bool
+}
+
+func (f *Filter) Match(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	if f.User != "" && f.User != r.User && f.User != r.SudoUser && f.User != strconv.Itoa(r.UID) {
+		return false
+	}
+	if f.Command != "" && !strings.Contains(r.Command, f.Command) {
+		return false
+	}
+	if f.Target != "" && !strings.HasPrefix(r.Target, f.Target) {
+		return false
+	}
+	if f.Failed && r.Result != RESULT_FAILURE {
+		return false
+	}
+	return true
+}
+
+// Query 按顺序返回满足 f 的记录，审计日志不存在时返回空
+func Query(f Filter) ([]*Record, error) {
+	errFormat := "audit.Query: %w"
+	file, err := os.Open(consts.PATH_AUDIT)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer file.Close()
+	var rs []*Record
+	err = scan(file, func(r *Record) error {
+		if f.Match(r) {
+			rs = append(rs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return rs, nil
+}
+
+// Verify 校验整条哈希链，返回记录条数和最后一条记录的哈希。
+// 任意一条记录被修改、删除或插入时返回包装了 ErrTampered 的错误，指出第一条出错的行
+func Verify(rd io.Reader) (count int, lastHash string, err error) {
+	errFormat := "audit.Verify: %w"
+	var prev *Record
+	line := 0
+	err = scan(rd, func(r *Record) error {
+		line++
+		want, err := r.hash()
+		if err != nil {
+			return err
+		}
+		switch {
+		case r.Hash != want:
+			return fmt.Errorf("%w: line %d: hash mismatch", ErrTampered, line)
+		case prev == nil && (r.Seq != 1 || r.PrevHash != ""):
+			return fmt.Errorf("%w: line %d: log does not start at the first record", ErrTampered, line)
+		case prev != nil && r.Seq != prev.Seq+1:
+			return fmt.Errorf("%w: line %d: seq %d follows %d", ErrTampered, line, r.Seq, prev.Seq)
+		case prev != nil && r.PrevHash != prev.Hash:
+			return fmt.Errorf("%w: line %d: previous hash mismatch", ErrTampered, line)
+		}
+		prev = r
+		return nil
+	})
+	if err != nil {
+		return line, "", fmt.Errorf(errFormat, err)
+	}
+	if prev != nil {
+		lastHash = prev.Hash
+	}
+	return line, lastHash, nil
+}
+
+// VerifyFile 校验数据目录下的审计日志，日志不存在时视为空日志
+func VerifyFile() (int, string, error) {
+	f, err := os.Open(consts.PATH_AUDIT)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, "", nil
+		}
+		return 0, "", fmt.Errorf("audit.VerifyFile: %w", err)
+	}
+	defer f.Close()
+	return Verify(f)
+}
+
+func scan(rd io.Reader, fn func(r *Record) error) error {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		var r Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrTampered, line, err)
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
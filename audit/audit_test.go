@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func setupLog(t *testing.T) []byte {
+	t.Helper()
+	testutil.SetTestRoot(t)
+	records := []*Record{
+		{Source: SOURCE_CLI, UID: 0, User: "root", SudoUser: "alice", Command: "run", TargetType: TARGET_CONTAINER, Target: "web"},
+		{Source: SOURCE_CLI, UID: 1000, User: "bob", Command: "rm", TargetType: TARGET_CONTAINER, Target: "web"},
+		{Source: SOURCE_API, UID: 0, User: "root", Command: "DELETE /networks/front", TargetType: TARGET_NETWORK, Target: "front"},
+	}
+	for i, r := range records {
+		var err error
+		if i == 1 {
+			err = errors.New("container web must be stopped")
+		}
+		if err := Log(r, err); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bs, err := os.ReadFile(consts.PATH_AUDIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+func TestAppendAfterTornWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    string
+		wantErr error
+	}{
+		{name: "torn record", tail: `{"seq":4,"time":"2024`},
+		{name: "terminated bad record", tail: "{\"seq\":4,\"ti\n", wantErr: ErrTampered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupLog(t)
+			f, err := os.OpenFile(consts.PATH_AUDIT, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+			if err := Check(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Check() = %v, want %v", err, tt.wantErr)
+			}
+			err = Log(&Record{Source: SOURCE_CLI, Command: "stop"}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Log() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if n, _, err := VerifyFile(); err != nil || n != 4 {
+				t.Errorf("VerifyFile() = %d, %v, want 4 records", n, err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := setupLog(t)
+	n, last, err := Verify(bytes.NewReader(data))
+	if err != nil || n != 3 || last == "" {
+		t.Fatalf("Verify() = %d, %q, %v, want 3 records", n, last, err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"modified", strings.Replace(string(data), `"user":"bob"`, `"user":"carol"`, 1)},
+		{"deleted", lines[0] + lines[2]},
+		{"reordered", lines[1] + lines[0] + lines[2]},
+		{"head removed", lines[1] + lines[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Verify(strings.NewReader(tt.data)); !errors.Is(err, ErrTampered) {
+				t.Errorf("Verify() error = %v, want ErrTampered", err)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	setupLog(t)
+	tests := []struct {
+		name string
+		f    Filter
+		want []uint64
+	}{
+		{"all", Filter{}, []uint64{1, 2, 3}},
+		{"sudo user", Filter{User: "alice"}, []uint64{1}},
+		{"uid", Filter{User: "1000"}, []uint64{2}},
+		{"command", Filter{Command: "networks"}, []uint64{3}},
+		{"target", Filter{Target: "we"}, []uint64{1, 2}},
+		{"failed", Filter{Failed: true}, []uint64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := Query(tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for _, r := range rs {
+				got = append(got, r.Seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query() seqs = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Query() seqs = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"run", "-e", "TOKEN=abc", "-d", "app"}, []string{"run", "-e", "TOKEN=***", "-d", "app"}},
+		{[]string{"run", "--e=PASS=a=b", "-e", "HOME", "app"}, []string{"run", "--e=PASS=***", "-e", "HOME", "app"}},
+		{[]string{"build", "--build-arg", "KEY=v", "-t", "a:1", "."}, []string{"build", "--build-arg", "KEY=***", "-t", "a:1", "."}},
+		{[]string{"run", "-d", "app", "env"}, []string{"run", "-d", "app", "env"}},
+		{[]string{"run", "-e"}, []string{"run", "-e"}},
+	}
+	for _, tt := range tests {
+		args := slices.Clone(tt.args)
+		if got := RedactArgs(tt.args, "e", "build-arg"); !slices.Equal(got, tt.want) {
+			t.Errorf("RedactArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+		if !slices.Equal(args, tt.args) {
+			t.Errorf("RedactArgs() modified its argument: %q", tt.args)
+		}
+	}
+}
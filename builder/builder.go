@@ -0,0 +1,411 @@
+package builder
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/runtime"
+)
+
+// Options 构建镜像的参数
+type Options struct {
+	ContextDir string
+	// Dockerfile 为空时使用构建上下文中的 Dockerfile
+	Dockerfile string
+	// Tags 构建完成后登记的镜像引用，至少需要一个
+	Tags      []string
+	BuildArgs map[string]string
+	// NoCache 不使用之前的构建结果，新的结果仍然写入缓存
+	NoCache bool
+	// Output 接收构建进度和 RUN 步骤的输出
+	Output io.Writer
+}
+
+// state 构建过程中的镜像：配置和从最底层到最上层的层
+type state struct {
+	cfg    image.Config
+	layers []image.Descriptor
+}
+
+// id 和镜像 ID 一样是配置的摘要，用作下一步的缓存键
+func (s *state) id() string {
+	bs, _ := json.Marshal(s.cfg)
+	sum := sha256.Sum256(bs)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// clone 深拷贝，之后的修改不影响缓存中的状态
+func (s *state) clone() *state {
+	var cfg image.Config
+	bs, _ := json.Marshal(s.cfg)
+	_ = json.Unmarshal(bs, &cfg)
+	return &state{cfg: cfg, layers: slices.Clone(s.layers)}
+}
+
+// derive 在当前状态上追加一步构建记录，layer 为空表示只修改配置
+func (s *state) derive(createdBy string, layer *image.Descriptor, diffID string) *state {
+	next := s.clone()
+	now := time.Now().UTC()
+	next.cfg.Created = now
+	h := image.History{Created: now, CreatedBy: createdBy}
+	if layer != nil {
+		next.layers = append(next.layers, *layer)
+		next.cfg.RootFS.DiffIDs = append(next.cfg.RootFS.DiffIDs, diffID)
+	} else {
+		h.EmptyLayer = true
+	}
+	next.cfg.History = append(next.cfg.History, h)
+	return next
+}
+
+type builder struct {
+	rt    *runtime.Runtime
+	opts  Options
+	out   io.Writer
+	state *state
+	// args 已声明的 ARG 及其取值，没有默认值也没有传入 --build-arg 的 ARG 不在其中
+	args map[string]string
+	used map[string]bool
+}
+
+// Build 按 Dockerfile 构建镜像并以 opts.Tags 登记。RUN 步骤在 rt 创建的容器中执行，
+// 每一步的结果按父状态和指令缓存，rt 为空时 Dockerfile 不能包含 RUN
+func Build(rt *runtime.Runtime, opts Options) (*image.Image, error) {
+	errFormat := "builder.Build: %w"
+	if len(opts.Tags) == 0 {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: a tag is required", errdefs.ErrInvalidArgument))
+	}
+	for _, tag := range opts.Tags {
+		if _, err := image.ParseTag(tag); err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(opts.ContextDir, "Dockerfile")
+	}
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	insts, err := Parse(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if err := os.MkdirAll(consts.PATH_IMAGE, 0755); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	// 中间层在登记为镜像之前不能被 rmi 回收
+	release, err := image.Lease()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer release()
+
+	b := &builder{rt: rt, opts: opts, out: opts.Output, args: map[string]string{}, used: map[string]bool{}}
+	for i, inst := range insts {
+		fmt.Fprintf(b.out, "Step %d/%d : %s\n", i+1, len(insts), inst)
+		if err := b.step(inst); err != nil {
+			return nil, fmt.Errorf(errFormat, fmt.Errorf("line %d: %s: %w", inst.Line, inst.Cmd, err))
+		}
+	}
+	if b.state == nil {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: no FROM instruction", errdefs.ErrInvalidArgument))
+	}
+	for name := range opts.BuildArgs {
+		if !b.used[name] {
+			fmt.Fprintf(b.out, "[Warning] build-arg %s was not consumed\n", name)
+		}
+	}
+
+	var img *image.Image
+	for _, tag := range opts.Tags {
+		cfg := b.state.cfg
+		if img, err = image.Create(tag, &cfg, b.state.layers); err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	}
+	fmt.Fprintf(b.out, "Successfully built %s\n", shortID(img.ID))
+	for _, tag := range opts.Tags {
+		r, _ := image.ParseTag(tag)
+		fmt.Fprintf(b.out, "Successfully tagged %s\n", r.String())
+	}
+	return img, nil
+}
+
+func (b *builder) step(inst Instruction) error {
+	switch inst.Cmd {
+	case "FROM":
+		return b.from(inst)
+	case "ARG":
+		return b.arg(inst)
+	}
+	if b.state == nil {
+		return fmt.Errorf("%w: FROM must be the first instruction", errdefs.ErrInvalidArgument)
+	}
+	switch inst.Cmd {
+	case "RUN":
+		return b.run(inst)
+	case "COPY", "ADD":
+		return b.copy(inst)
+	default:
+		return b.config(inst)
+	}
+}
+
+// lookup 变量的取值，ENV 优先于 ARG
+func (b *builder) lookup(name string) (string, bool) {
+	if b.state != nil {
+		if value, ok := b.lookupEnv(name); ok {
+			return value, true
+		}
+	}
+	value, ok := b.args[name]
+	return value, ok
+}
+
+func (b *builder) from(inst Instruction) error {
+	if b.state != nil {
+		return fmt.Errorf("%w: multi-stage builds are not supported", errdefs.ErrInvalidArgument)
+	}
+	name, err := expand(inst.Args, b.lookup)
+	if err != nil {
+		return err
+	}
+	if name == "scratch" {
+		b.state = &state{cfg: image.Config{
+			Architecture: goruntime.GOARCH,
+			OS:           "linux",
+			RootFS:       image.RootFS{Type: "layers"},
+		}}
+	} else {
+		_, m, cfg, err := image.Load(name)
+		if err != nil {
+			return err
+		}
+		b.state = &state{cfg: *cfg, layers: m.Layers}
+	}
+	fmt.Fprintf(b.out, " ---> %s\n", shortID(b.state.id()))
+	return nil
+}
+
+// arg 声明构建参数，--build-arg 传入的值优先于默认值
+func (b *builder) arg(inst Instruction) error {
+	name, def, hasDef := strings.Cut(inst.Args, "=")
+	if !isName(name) {
+		return fmt.Errorf("%w: bad name %q", errdefs.ErrInvalidArgument, name)
+	}
+	if v, ok := b.opts.BuildArgs[name]; ok {
+		b.args[name] = v
+		b.used[name] = true
+		return nil
+	}
+	if hasDef {
+		value, err := expand(def, b.lookup)
+		if err != nil {
+			return err
+		}
+		b.args[name] = unquote(value)
+	}
+	return nil
+}
+
+// next 命中缓存时直接使用之前的结果，否则调用 build 并缓存结果
+func (b *builder) next(key string, build func() (*state, error)) error {
+	if !b.opts.NoCache {
+		if s, ok := getCache(key); ok {
+			fmt.Fprintln(b.out, " ---> Using cache")
+			b.state = s
+			fmt.Fprintf(b.out, " ---> %s\n", shortID(s.id()))
+			return nil
+		}
+	}
+	s, err := build()
+	if err != nil {
+		return err
+	}
+	if err := putCache(key, s); err != nil {
+		logrus.Warnln("build:", err)
+	}
+	b.state = s
+	fmt.Fprintf(b.out, " ---> %s\n", shortID(s.id()))
+	return nil
+}
+
+// config 只修改镜像配置的指令，CMD 和 ENTRYPOINT 在运行时才展开变量
+func (b *builder) config(inst Instruction) error {
+	args := inst.Args
+	if inst.Cmd != "CMD" && inst.Cmd != "ENTRYPOINT" {
+		var err error
+		if args, err = expand(args, b.lookup); err != nil {
+			return err
+		}
+	}
+	if inst.Cmd == "WORKDIR" {
+		// 相对路径基于之前的 WORKDIR
+		dir := unquote(args)
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", b.state.cfg.Config.WorkingDir, dir)
+		}
+		args = path.Clean(dir)
+	}
+	line := inst.Cmd + " " + args
+	return b.next(cacheKey(b.state.id(), line), func() (*state, error) {
+		s := b.state.derive("/bin/sh -c #(nop) "+line, nil, "")
+		if err := image.ApplyChange(&s.cfg.Config, line); err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// argEnv 没有被 ENV 覆盖的 ARG，以环境变量的形式传给 RUN
+func (b *builder) argEnv() []string {
+	var env []string
+	for name, value := range b.args {
+		if _, ok := b.lookupEnv(name); !ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (b *builder) lookupEnv(name string) (string, bool) {
+	prefix := name + "="
+	for _, e := range b.state.cfg.Config.Env {
+		if strings.HasPrefix(e, prefix) {
+			return e[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// run 在以当前状态为镜像的容器中执行命令，容器的 upper 目录作为新的一层
+func (b *builder) run(inst Instruction) error {
+	if b.rt == nil {
+		return fmt.Errorf("%w: RUN requires a container runtime", errdefs.ErrInvalidArgument)
+	}
+	argEnv := b.argEnv()
+	key := cacheKey(b.state.id(), inst.String()+"\n"+strings.Join(argEnv, "\n"))
+	return b.next(key, func() (*state, error) {
+		cmd := image.ParseCommand(inst.Args)
+		runCfg := b.state.clone().cfg
+		runCfg.Config.Entrypoint = nil
+		runCfg.Config.Cmd = cmd
+		runCfg.Config.Env = append(runCfg.Config.Env, argEnv...)
+		digest, err := image.WriteUntagged(&runCfg, b.state.layers)
+		if err != nil {
+			return nil, err
+		}
+		c, err := b.rt.Create(runtime.CreateOptions{Image: digest, Detach: true})
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := b.rt.Remove(c.Id, runtime.RemoveOptions{Force: true}); err != nil {
+				logrus.Warnln("build:", err)
+			}
+		}()
+		fmt.Fprintf(b.out, " ---> Running in %s\n", shortID(c.Id))
+		if err := b.rt.Start(c.Id, runtime.StartOptions{}); err != nil {
+			return nil, err
+		}
+		logs, err := b.rt.Logs(c.Id, runtime.LogsOptions{Follow: true})
+		if err != nil {
+			return nil, err
+		}
+		defer logs.Close()
+		done := make(chan struct{})
+		go func() {
+			io.Copy(b.out, logs)
+			close(done)
+		}()
+		code, err := b.rt.Wait(c.Id)
+		if err != nil {
+			return nil, err
+		}
+		// 容器退出后日志读到末尾就结束
+		<-done
+		if code != 0 {
+			return nil, fmt.Errorf("command %q returned a non-zero code: %d", inst.Args, code)
+		}
+		layer, diffID, err := image.DiffLayer(consts.GetPathUpper(c.Id))
+		if err != nil {
+			return nil, err
+		}
+		return b.state.derive(strings.Join(cmd, " "), &layer, diffID), nil
+	})
+}
+
+// copy 把构建上下文中的文件打包成一层，缓存键包括层的内容
+func (b *builder) copy(inst Instruction) error {
+	args, err := expand(inst.Args, b.lookup)
+	if err != nil {
+		return err
+	}
+	ca, err := parseCopyArgs(args)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(consts.PATH_IMAGE, ".build-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	err = copyLayer(zw, b.opts.ContextDir, b.state.cfg.Config.WorkingDir, ca, inst.Cmd == "ADD")
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	layer, diffID, err := image.WriteLayer(tmp.Name())
+	if err != nil {
+		return err
+	}
+	line := inst.Cmd + " " + args
+	return b.next(cacheKey(b.state.id(), line+"\n"+diffID), func() (*state, error) {
+		return b.state.derive("/bin/sh -c #(nop) "+line, &layer, diffID), nil
+	})
+}
+
+// unquote 去掉两端成对的引号
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,92 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func TestBuild(t *testing.T) {
+	dir := testutil.SetTestRoot(t)
+
+	ctx := filepath.Join(dir, "ctx")
+	os.MkdirAll(filepath.Join(ctx, "conf"), 0755)
+	os.WriteFile(filepath.Join(ctx, "conf/app.conf"), []byte("port=80\n"), 0644)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "share/data", Mode: 0644, Size: 2})
+	tw.Write([]byte("d\n"))
+	tw.Close()
+	os.WriteFile(filepath.Join(ctx, "data.tar"), buf.Bytes(), 0644)
+	os.WriteFile(filepath.Join(ctx, "Dockerfile"), []byte(`ARG BASE=scratch
+FROM $BASE
+ARG VERSION=1
+ENV APP=/app
+WORKDIR $APP
+WORKDIR conf
+COPY conf/app.conf .
+ADD data.tar /
+EXPOSE 80
+LABEL version=$VERSION
+CMD ["cat", "app.conf"]
+`), 0644)
+
+	var out bytes.Buffer
+	opts := Options{ContextDir: ctx, Tags: []string{"app:v1"}, BuildArgs: map[string]string{"VERSION": "2", "UNUSED": "x"}, Output: &out}
+	img, err := Build(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "build-arg UNUSED was not consumed") {
+		t.Errorf("output missing the unused build-arg warning:\n%s", out.String())
+	}
+	d, err := image.Inspect("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := d.Config
+	if cfg.WorkingDir != "/app/conf" || cfg.Env[0] != "APP=/app" || cfg.Labels["version"] != "2" ||
+		!reflect.DeepEqual(cfg.Cmd, []string{"cat", "app.conf"}) || len(cfg.ExposedPorts) != 1 {
+		t.Errorf("Inspect().Config = %+v", cfg)
+	}
+	if len(d.Layers) != 2 || len(d.History) != 8 {
+		t.Errorf("Inspect() has %d layers and %d history entries", len(d.Layers), len(d.History))
+	}
+	layers, err := image.Layers(img.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{filepath.Join(consts.GetPathLayer(layers[0]), "app/conf/app.conf"), filepath.Join(consts.GetPathLayer(layers[1]), "share/data")} {
+		if _, err := os.Stat(f); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// 第二次构建全部命中缓存，得到相同的镜像
+	out.Reset()
+	opts.Tags = []string{"app:v2"}
+	img2, err := Build(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img2.ID != img.ID || strings.Count(out.String(), "Using cache") != 8 {
+		t.Errorf("second build = %s, want cached %s:\n%s", img2.ID, img.ID, out.String())
+	}
+	opts.NoCache = true
+	if img3, err := Build(nil, opts); err != nil || img3.ID == img.ID {
+		t.Errorf("Build() with NoCache = %v, %v", img3, err)
+	}
+
+	os.WriteFile(filepath.Join(ctx, "Dockerfile"), []byte("FROM scratch\nRUN true\n"), 0644)
+	if _, err := Build(nil, opts); err == nil {
+		t.Error("Build() with RUN and no runtime succeeded")
+	}
+}
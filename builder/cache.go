@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// cacheEntry 一个构建步骤的结果。直接保存配置和层的描述而不是中间镜像，
+// 中间镜像的 blob 会被 rmi 回收，而层只要还被镜像引用就可以复用
+type cacheEntry struct {
+	Config image.Config       `json:"config"`
+	Layers []image.Descriptor `json:"layers"`
+}
+
+// cacheKey 步骤的缓存键：父状态的 ID 加上展开后的指令，COPY 和 ADD 还包括文件内容的摘要
+func cacheKey(parent, instruction string) string {
+	sum := sha256.Sum256([]byte(parent + "\n" + instruction))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadCache() (map[string]*cacheEntry, error) {
+	entries := map[string]*cacheEntry{}
+	bs, err := os.ReadFile(consts.PATH_BUILD_CACHE)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// valid 条目引用的层都还在存储中
+func (e *cacheEntry) valid() bool {
+	for _, l := range e.Layers {
+		if !image.BlobExists(l.Digest) {
+			return false
+		}
+	}
+	return true
+}
+
+// getCache 返回 key 对应的构建结果，层已经被回收的条目视为未命中
+func getCache(key string) (*state, bool) {
+	unlock, err := utils.LockFile(consts.PATH_BUILD_CACHE_LOCK)
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+	entries, err := loadCache()
+	if err != nil {
+		return nil, false
+	}
+	e, ok := entries[key]
+	if !ok || !e.valid() {
+		return nil, false
+	}
+	return &state{cfg: e.Config, layers: e.Layers}, true
+}
+
+// putCache 记录构建结果，同时删除层已经被回收的条目
+func putCache(key string, s *state) error {
+	errFormat := "putCache: %w"
+	unlock, err := utils.LockFile(consts.PATH_BUILD_CACHE_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	entries, err := loadCache()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	for k, e := range entries {
+		if !e.valid() {
+			delete(entries, k)
+		}
+	}
+	entries[key] = &cacheEntry{Config: s.cfg, Layers: s.layers}
+	bs, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := utils.WriteFileAtomic(consts.PATH_BUILD_CACHE, bs, 0644); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
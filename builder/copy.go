@@ -0,0 +1,314 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wlbyte/mydocker/archive"
+	"github.com/wlbyte/mydocker/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+// copyArgs COPY 和 ADD 的参数
+type copyArgs struct {
+	srcs []string
+	dest string
+	// uid 和 gid 为 -1 时保持为 root
+	uid, gid int
+}
+
+// parseCopyArgs 解析 [--chown=uid[:gid]] src... dest，也支持 JSON 数组形式
+func parseCopyArgs(args string) (*copyArgs, error) {
+	c := &copyArgs{uid: -1, gid: -1}
+	for strings.HasPrefix(args, "--") {
+		flag, rest, _ := strings.Cut(args, " ")
+		args = strings.TrimSpace(rest)
+		name, value, _ := strings.Cut(flag, "=")
+		switch name {
+		case "--chown":
+			u, g, hasGroup := strings.Cut(value, ":")
+			uid, err := strconv.Atoi(u)
+			if err != nil {
+				return nil, fmt.Errorf("%w: --chown only supports numeric ids: %s", errdefs.ErrInvalidArgument, value)
+			}
+			gid := uid
+			if hasGroup {
+				if gid, err = strconv.Atoi(g); err != nil {
+					return nil, fmt.Errorf("%w: --chown only supports numeric ids: %s", errdefs.ErrInvalidArgument, value)
+				}
+			}
+			c.uid, c.gid = uid, gid
+		default:
+			return nil, fmt.Errorf("%w: unsupported flag %s", errdefs.ErrInvalidArgument, name)
+		}
+	}
+	var words []string
+	if strings.HasPrefix(args, "[") {
+		if err := json.Unmarshal([]byte(args), &words); err != nil {
+			return nil, fmt.Errorf("%w: %s", errdefs.ErrInvalidArgument, err)
+		}
+	} else {
+		words = strings.Fields(args)
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("%w: requires at least one source and a destination", errdefs.ErrInvalidArgument)
+	}
+	c.srcs, c.dest = words[:len(words)-1], words[len(words)-1]
+	return c, nil
+}
+
+// copyLayer 把构建上下文中的文件打包成一层写入 w。workDir 用于解析相对的目标路径，
+// extract 为 true（ADD）时本地的 tar 归档解压到目标目录
+func copyLayer(w io.Writer, contextDir, workDir string, c *copyArgs, extract bool) error {
+	errFormat := "copyLayer: %w"
+	var matches []string
+	for _, src := range c.srcs {
+		if strings.Contains(src, "://") {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: remote sources are not supported: %s", errdefs.ErrInvalidArgument, src))
+		}
+		m, err := globContext(contextDir, src)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if len(m) == 0 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: %s not found in build context", errdefs.ErrNotFound, src))
+		}
+		matches = append(matches, m...)
+	}
+	dest := c.dest
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", workDir, dest)
+	}
+	destIsDir := strings.HasSuffix(c.dest, "/") || c.dest == "."
+	if len(matches) > 1 && !destIsDir {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: destination must be a directory ending with / when copying multiple sources", errdefs.ErrInvalidArgument))
+	}
+
+	tw := tar.NewWriter(w)
+	for _, src := range matches {
+		fi, err := os.Lstat(src)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		switch {
+		case fi.IsDir():
+			// 复制目录时复制的是目录的内容
+			err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(src, p)
+				if err != nil {
+					return err
+				}
+				return addFile(tw, p, path.Join(dest, filepath.ToSlash(rel)), c)
+			})
+		case extract && fi.Mode().IsRegular() && isArchive(src):
+			err = extractArchive(tw, src, dest)
+		case destIsDir:
+			err = addFile(tw, src, path.Join(dest, fi.Name()), c)
+		default:
+			err = addFile(tw, src, dest, c)
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// globContext 返回构建上下文中匹配 pattern 的文件。filepath.Glob 会跟随上下文中的符号链接，
+// x -> / 时 x/etc/shadow 会匹配到主机上的文件，所以逐级匹配，中间的目录按上下文为根解析。
+// 最后一级是符号链接时复制链接本身，不需要解析
+func globContext(contextDir, pattern string) ([]string, error) {
+	// 先按根目录清理，源文件不能超出构建上下文
+	name := strings.TrimPrefix(filepath.Clean("/"+pattern), "/")
+	if name == "" {
+		return []string{contextDir}, nil
+	}
+	comps := strings.Split(name, "/")
+	dirs := []string{contextDir}
+	for i, comp := range comps {
+		var next []string
+		for _, dir := range dirs {
+			names := []string{comp}
+			if hasMeta(comp) {
+				var err error
+				if names, err = matchDir(dir, comp); err != nil {
+					return nil, err
+				}
+			}
+			for _, n := range names {
+				p := filepath.Join(dir, n)
+				if i < len(comps)-1 {
+					rel, err := filepath.Rel(contextDir, p)
+					if err != nil {
+						return nil, err
+					}
+					if p, err = archive.ResolveInRoot(contextDir, rel); err != nil {
+						return nil, err
+					}
+				}
+				if _, err := os.Lstat(p); errors.Is(err, fs.ErrNotExist) || errors.Is(err, unix.ENOTDIR) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				next = append(next, p)
+			}
+		}
+		dirs = next
+	}
+	return dirs, nil
+}
+
+// hasMeta 判断路径的一级是否包含通配符
+func hasMeta(comp string) bool {
+	return strings.ContainsAny(comp, `*?[\`)
+}
+
+// matchDir 返回目录 dir 中匹配 pattern 的文件名，dir 不是目录时没有匹配
+func matchDir(dir, pattern string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, unix.ENOTDIR) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		ok, err := filepath.Match(pattern, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// addFile 把文件 src 以 name 为路径写入层，属主为 root 或 --chown 指定的用户
+func addFile(tw *tar.Writer, src, name string, c *copyArgs) error {
+	// 根目录已经存在
+	if name == "/" {
+		return nil
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	link := ""
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(src); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = strings.TrimPrefix(name, "/")
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if c.uid >= 0 {
+		hdr.Uid, hdr.Gid = c.uid, c.gid
+	}
+	// 访问时间在读取文件后就会变化，不能记录到层中，否则相同的内容得到不同的 diff ID
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
+
+// openArchive 打开 tar 文件，gzip 或 zstd 压缩的先解压
+func openArchive(p string) (io.Reader, func(), error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, func() { r.Close(); f.Close() }, nil
+}
+
+// isArchive 判断文件是否是（可能经过压缩的）tar 归档，以 ustar 标记判断
+func isArchive(p string) bool {
+	r, done, err := openArchive(p)
+	if err != nil {
+		return false
+	}
+	defer done()
+	block := make([]byte, 512)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(block[257:], []byte("ustar"))
+}
+
+// extractArchive 把 tar 归档中的文件放到 dest 目录下写入层，保留归档中的属主
+func extractArchive(tw *tar.Writer, src, dest string) error {
+	r, done, err := openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer done()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		// 归档中的路径也不能超出目标目录
+		hdr.Name = strings.TrimPrefix(path.Join(dest, path.Clean("/"+hdr.Name)), "/")
+		if hdr.Name == "" {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(path.Join(dest, path.Clean("/"+hdr.Linkname)), "/")
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
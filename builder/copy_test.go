@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+func TestCopyLayerSymlink(t *testing.T) {
+	ctx := t.TempDir()
+	os.MkdirAll(filepath.Join(ctx, "real"), 0755)
+	os.WriteFile(filepath.Join(ctx, "real/app.conf"), []byte("port=80\n"), 0644)
+	// 上下文中的符号链接按上下文为根解析，绝对路径的链接也不会指向主机
+	os.Symlink("real", filepath.Join(ctx, "rel"))
+	os.Symlink("/real", filepath.Join(ctx, "abs"))
+	os.Symlink("/", filepath.Join(ctx, "host"))
+
+	tests := []struct {
+		src     string
+		want    string
+		wantErr error
+	}{
+		{src: "rel/app.conf", want: "port=80\n"},
+		{src: "abs/app.conf", want: "port=80\n"},
+		{src: "abs/*.conf", want: "port=80\n"},
+		{src: "host/etc/passwd", wantErr: errdefs.ErrNotFound},
+		{src: "host/etc/pass*", wantErr: errdefs.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := copyLayer(&buf, ctx, "/", &copyArgs{srcs: []string{tt.src}, dest: "/out", uid: -1, gid: -1}, false)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("copyLayer() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(&buf)
+			hdr, err := tr.Next()
+			if err != nil || hdr.Name != "out" {
+				t.Fatalf("first entry = %+v, %v", hdr, err)
+			}
+			if bs, _ := io.ReadAll(tr); string(bs) != tt.want {
+				t.Errorf("out = %q, want %q", bs, tt.want)
+			}
+		})
+	}
+}
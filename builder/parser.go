@@ -0,0 +1,158 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+// 支持的 Dockerfile 指令
+var instructions = map[string]bool{
+	"FROM": true, "RUN": true, "COPY": true, "ADD": true, "ENV": true, "WORKDIR": true,
+	"CMD": true, "ENTRYPOINT": true, "LABEL": true, "USER": true, "EXPOSE": true, "ARG": true,
+}
+
+// Instruction Dockerfile 中的一条指令，续行已经合并
+type Instruction struct {
+	Cmd  string
+	Args string
+	// Line 指令在 Dockerfile 中开始的行号，用于错误信息
+	Line int
+}
+
+func (i Instruction) String() string {
+	return i.Cmd + " " + i.Args
+}
+
+// Parse 解析 Dockerfile：忽略空行和 # 开头的注释，行尾的 \ 表示续行，指令不区分大小写
+func Parse(r io.Reader) ([]Instruction, error) {
+	errFormat := "builder.Parse: %w"
+	var result []Instruction
+	var buf strings.Builder
+	start := 0
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if buf.Len() == 0 {
+			start = n
+		} else {
+			buf.WriteByte(' ')
+		}
+		if cont, ok := strings.CutSuffix(line, "\\"); ok {
+			buf.WriteString(strings.TrimSpace(cont))
+			continue
+		}
+		buf.WriteString(line)
+		inst, err := parseLine(buf.String(), start)
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		result = append(result, inst)
+		buf.Reset()
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if buf.Len() > 0 {
+		inst, err := parseLine(buf.String(), start)
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		result = append(result, inst)
+	}
+	return result, nil
+}
+
+func parseLine(line string, n int) (Instruction, error) {
+	cmd, args, _ := strings.Cut(line, " ")
+	cmd = strings.ToUpper(cmd)
+	args = strings.TrimSpace(args)
+	if !instructions[cmd] {
+		return Instruction{}, fmt.Errorf("%w: line %d: unsupported instruction %s", errdefs.ErrInvalidArgument, n, cmd)
+	}
+	if args == "" {
+		return Instruction{}, fmt.Errorf("%w: line %d: %s requires arguments", errdefs.ErrInvalidArgument, n, cmd)
+	}
+	return Instruction{Cmd: cmd, Args: args, Line: n}, nil
+}
+
+// expand 替换 s 中的 $VAR、${VAR}、${VAR:-default} 和 ${VAR:+value}，
+// 单引号中的内容和 \$ 不替换，引号本身保留给之后的分词处理
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	var out strings.Builder
+	inSingle, inDouble := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '$':
+			out.WriteByte('$')
+			i++
+		case c == '"' && !inSingle:
+			inDouble = !inDouble
+			out.WriteByte(c)
+		case c == '\'' && !inDouble:
+			inSingle = !inSingle
+			out.WriteByte(c)
+		case c == '$' && !inSingle && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("%w: missing } in %q", errdefs.ErrInvalidArgument, s)
+			}
+			expr := s[i+2 : i+end]
+			name, word, op := expr, "", ""
+			if j := strings.Index(expr, ":"); j >= 0 {
+				name, op, word = expr[:j], expr[j:min(j+2, len(expr))], expr[min(j+2, len(expr)):]
+			}
+			if !isName(name) || (op != "" && op != ":-" && op != ":+") {
+				return "", fmt.Errorf("%w: bad substitution ${%s}", errdefs.ErrInvalidArgument, expr)
+			}
+			value, ok := lookup(name)
+			switch op {
+			case ":-":
+				if !ok || value == "" {
+					value = word
+				}
+			case ":+":
+				if ok && value != "" {
+					value = word
+				}
+			}
+			out.WriteString(value)
+			i += end
+		case c == '$' && !inSingle && i+1 < len(s) && isNameStart(s[i+1]):
+			j := i + 1
+			for j < len(s) && (isNameStart(s[j]) || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			value, _ := lookup(s[i+1 : j])
+			out.WriteString(value)
+			i = j - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameStart(s[i]) && (s[i] < '0' || s[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile := `# comment
+FROM scratch
+
+run echo a \
+    && echo b
+# inside a continuation
+ENV A=1
+`
+	got, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instruction{
+		{Cmd: "FROM", Args: "scratch", Line: 2},
+		{Cmd: "RUN", Args: "echo a && echo b", Line: 4},
+		{Cmd: "ENV", Args: "A=1", Line: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %+v, want %+v", got, want)
+	}
+	for _, bad := range []string{"FROM", "HEALTHCHECK NONE"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"A": "1", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "$A-${A}", want: "1-1"},
+		{in: "x$Ay", want: "x"},
+		{in: "${NONE:-d} ${EMPTY:-d} ${A:-d}", want: "d d 1"},
+		{in: "${NONE:+s} ${A:+s}", want: " s"},
+		{in: `'$A' "$A" \$A`, want: `'$A' "1" $A`},
+		{in: "${A", wantErr: true},
+		{in: "${A:?x}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := expand(tt.in, lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package cgroups
 
-import "github.com/wlbyte/mydocker/cgroups/subsystems"
+import (
+	"errors"
+	"os"
+
+	"github.com/wlbyte/mydocker/cgroups/subsystems"
+)
 
 type CgroupManager struct {
 	Path     string
@@ -31,9 +36,59 @@ func (c *CgroupManager) Apply(pid int, res *subsystems.ResourceConfig) error {
 	return nil
 }
 
+// Destroy 删除所有子系统中的 cgroup，没有创建过的子系统会被跳过
 func (c *CgroupManager) Destroy() error {
+	var errs []error
 	for _, sub := range subsystems.SubsystemsIns {
-		return sub.Remove(c.Path)
+		if err := sub.Remove(c.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// Freeze 冻结 cgroup 中的所有进程
+func (c *CgroupManager) Freeze() error {
+	return subsystems.SetFreezerState(c.Path, subsystems.FREEZER_FROZEN)
+}
+
+func (c *CgroupManager) Thaw() error {
+	return subsystems.SetFreezerState(c.Path, subsystems.FREEZER_THAWED)
+}
+
+// OOMKilled 返回是否有进程因为超出内存限制被杀死，没有设置内存限制时总是 false
+func (c *CgroupManager) OOMKilled() bool {
+	n, err := subsystems.OOMKillCount(c.Path)
+	return err == nil && n > 0
+}
+
+// Stats cgroup 的资源使用统计，读取失败的项为 nil
+type Stats struct {
+	CPUUsageNanos *uint64
+	MemoryUsage   *uint64
+	MemoryLimit   *uint64
+	Pids          *uint64
+}
+
+// unlimitedMemory memory.limit_in_bytes 不限制时是一个接近 int64 上限的值
+const unlimitedMemory = 1 << 62
+
+func (c *CgroupManager) Stats() *Stats {
+	read := func(subsystem, file string) *uint64 {
+		n, err := subsystems.ReadUint(subsystem, c.Path, file)
+		if err != nil {
+			return nil
+		}
+		return &n
+	}
+	s := &Stats{
+		CPUUsageNanos: read("cpuacct", "cpuacct.usage"),
+		MemoryUsage:   read("memory", "memory.usage_in_bytes"),
+		MemoryLimit:   read("memory", "memory.limit_in_bytes"),
+		Pids:          read("pids", "pids.current"),
+	}
+	if s.MemoryLimit != nil && *s.MemoryLimit >= unlimitedMemory {
+		s.MemoryLimit = nil
+	}
+	return s
 }
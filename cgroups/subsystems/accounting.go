@@ -0,0 +1,63 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// AccountingSubSystem 不限制资源，容器进程总是加入，用于读取 cpuacct、pids 等统计数据
+type AccountingSubSystem struct {
+	name string
+}
+
+func (s *AccountingSubSystem) Name() string {
+	return s.name
+}
+
+func (s *AccountingSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *AccountingSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	errFormat := s.name + "SubSystem.Apply: %w"
+	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.WriteFile(path.Join(subsysPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func (s *AccountingSubSystem) Remove(cgroupPath string) error {
+	errFormat := s.name + "SubSystem.Remove: %w"
+	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.RemoveAll(subsysPath); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// ReadUint 读取 cgroup 中只包含一个整数的文件，比如 cpuacct.usage、pids.current
+func ReadUint(subsystem, cgroupPath, file string) (uint64, error) {
+	subsysPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("readUint: %w", err)
+	}
+	bs, err := os.ReadFile(path.Join(subsysPath, file))
+	if err != nil {
+		return 0, fmt.Errorf("readUint: %w", err)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(bs)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("readUint %s: %w", file, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package subsystems
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FREEZER_FROZEN  = "FROZEN"
+	FREEZER_THAWED  = "THAWED"
+	freezerTimeout  = 10 * time.Second
+	freezerInterval = 10 * time.Millisecond
+)
+
+// FreezerSubSystem 不限制资源，容器进程总是加入 freezer cgroup，用于 pause/unpause
+type FreezerSubSystem struct {
+}
+
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	errFormat := "freezerSubSystem.Apply: %w"
+	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.WriteFile(path.Join(subsysPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	errFormat := "freezerSubSystem.Remove: %w"
+	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.RemoveAll(subsysPath); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// SetFreezerState 写入 FROZEN 或 THAWED，并等待 cgroup 中的所有进程进入该状态
+func SetFreezerState(cgroupPath, state string) error {
+	errFormat := "setFreezerState: %w"
+	subsysPath, err := GetCgroupPath("freezer", cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	stateFile := path.Join(subsysPath, "freezer.state")
+	deadline := time.Now().Add(freezerTimeout)
+	for {
+		// 冻结过程中内核可能停在 FREEZING，需要重复写入
+		if err := os.WriteFile(stateFile, []byte(state), 0644); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		bs, err := os.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if strings.TrimSpace(string(bs)) == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf(errFormat, fmt.Errorf("timeout waiting for %s", state))
+		}
+		time.Sleep(freezerInterval)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubSystem struct {
@@ -29,12 +30,10 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	return nil
 }
 
+// Apply 没有内存限制时也加入 memory cgroup，用于统计内存使用量
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.MemoryLimit == "" {
-		return nil
-	}
 	errFormat := "memorySubSystem.Apply: %w"
-	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	subsysPath, err := GetCgroupPath(s.Name(), cgroupPath, true)
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
@@ -55,3 +54,26 @@ func (s *MemorySubSystem) Remove(cgroupPath string) error {
 	}
 	return nil
 }
+
+// OOMKillCount 返回 cgroup 中被 OOM killer 杀死的进程数，读取 memory.oom_control 的 oom_kill 字段
+func OOMKillCount(cgroupPath string) (int, error) {
+	errFormat := "oomKillCount: %w"
+	subsysPath, err := GetCgroupPath("memory", cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf(errFormat, err)
+	}
+	bs, err := os.ReadFile(path.Join(subsysPath, "memory.oom_control"))
+	if err != nil {
+		return 0, fmt.Errorf(errFormat, err)
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if k, v, ok := strings.Cut(line, " "); ok && k == "oom_kill" {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return 0, fmt.Errorf(errFormat, err)
+			}
+			return n, nil
+		}
+	}
+	return 0, nil
+}
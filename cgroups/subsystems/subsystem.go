@@ -5,6 +5,9 @@ var (
 		&CpuSubSystem{},
 		&MemorySubSystem{},
 		&CpusetSubSystem{},
+		&FreezerSubSystem{},
+		&AccountingSubSystem{name: "cpuacct"},
+		&AccountingSubSystem{name: "pids"},
 	}
 )
 
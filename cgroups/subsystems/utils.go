@@ -3,11 +3,11 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/wlbyte/mydocker/consts"
 )
 
@@ -35,7 +35,7 @@ func FindCgroupMountpoint(subsystem string) string {
 		}
 	}
 	if err = scanner.Err(); err != nil {
-		log.Println("[error] scanner:", err)
+		logrus.Errorln("scanner:", err)
 	}
 	return ""
 }
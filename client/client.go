@@ -0,0 +1,573 @@
+// Package client 是 mydocker daemon HTTP API 的 Go 客户端
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/archive"
+	"github.com/wlbyte/mydocker/builder"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/network"
+	"github.com/wlbyte/mydocker/runtime"
+)
+
+type Client struct {
+	proto string
+	addr  string
+	tls   *tls.Config
+	http  *http.Client
+}
+
+// Error daemon 返回的错误，StatusCode 为 HTTP 状态码，Kind 为错误所属的 errdefs 分类，
+// 可以用 errors.Is(err, errdefs.ErrNotFound) 这样的方式判断
+type Error struct {
+	StatusCode int
+	Message    string
+	Kind       error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	if e.Kind != nil {
+		return e.Kind
+	}
+	// 响应中没有分类时根据状态码推断
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return errdefs.ErrNotFound
+	case http.StatusBadRequest:
+		return errdefs.ErrInvalidArgument
+	case http.StatusConflict:
+		return errdefs.ErrConflict
+	}
+	return nil
+}
+
+// New 创建连接 host 的客户端，host 形如 unix:///run/mydocker.sock 或 tcp://127.0.0.1:2375，
+// tlsConfig 不为 nil 时 TCP 连接使用 TLS
+func New(host string, tlsConfig *tls.Config) (*Client, error) {
+	proto, addr, err := api.ParseHost(host)
+	if err != nil {
+		return nil, fmt.Errorf("client.New: %w", err)
+	}
+	c := &Client{proto: proto, addr: addr}
+	if proto == "tcp" {
+		c.tls = tlsConfig
+	}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	if c.tls != nil {
+		// 握手在这里完成，HTTP 请求直接写入加密后的连接
+		td := &tls.Dialer{NetDialer: d, Config: c.tls}
+		return td.DialContext(ctx, c.proto, c.addr)
+	}
+	return d.DialContext(ctx, c.proto, c.addr)
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	host := c.addr
+	if c.proto == "unix" {
+		// unix socket 不使用 URL 中的主机名，只需要一个合法的占位符
+		host = "mydocker"
+	}
+	// path 中的参数已经转义过，镜像名中的 / 转义为 %2F 后需要通过 RawPath 原样保留
+	u := url.URL{Scheme: "http", Host: host, RawPath: path, RawQuery: query.Encode()}
+	u.Path, _ = url.PathUnescape(path)
+	return u.String()
+}
+
+// do 发送请求，状态码不是 2xx 时把响应体解析为 *Error 返回。body 是 io.Reader 时作为
+// tar 归档原样发送，其他值编码为 JSON
+func (c *Client) do(method, path string, query url.Values, body any) (*http.Response, error) {
+	var r io.Reader
+	contentType := "application/json"
+	if rd, ok := body.(io.Reader); ok {
+		r, contentType = rd, "application/x-tar"
+	} else if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(method, c.url(path, query), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+func (c *Client) doJSON(method, path string, query url.Values, body, out any) error {
+	resp, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream 发送请求并把响应中的 StreamMessage 输出写入 out
+func (c *Client) stream(method, path string, query url.Values, body any, out io.Writer) error {
+	resp, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readStream(resp.Body, out)
+}
+
+// readStream 把 StreamMessage 流中的输出写入 out，遇到错误消息时返回对应的 *Error
+func readStream(r io.Reader, out io.Writer) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg api.StreamMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return &Error{StatusCode: http.StatusOK, Message: msg.Error, Kind: errdefs.FromKind(msg.Kind)}
+		}
+		if _, err := io.WriteString(out, msg.Stream); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	var er api.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&er); err != nil || er.Message == "" {
+		e.Message = resp.Status
+	} else {
+		e.Message = er.Message
+		e.Kind = errdefs.FromKind(er.Kind)
+	}
+	return e
+}
+
+func (c *Client) Ping() error {
+	errFormat := "client.Ping: %w"
+	if err := c.doJSON(http.MethodGet, "/_ping", nil, nil, nil); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func (c *Client) ContainerList(all bool) ([]*container.Container, error) {
+	var cs []*container.Container
+	query := url.Values{"all": {strconv.FormatBool(all)}}
+	if err := c.doJSON(http.MethodGet, "/containers/json", query, nil, &cs); err != nil {
+		return nil, fmt.Errorf("client.ContainerList: %w", err)
+	}
+	return cs, nil
+}
+
+func (c *Client) ContainerCreate(req api.ContainerCreateRequest) (string, error) {
+	var resp api.ContainerCreateResponse
+	if err := c.doJSON(http.MethodPost, "/containers/create", nil, req, &resp); err != nil {
+		return "", fmt.Errorf("client.ContainerCreate: %w", err)
+	}
+	return resp.Id, nil
+}
+
+func (c *Client) ContainerInspect(id string) (*container.Container, error) {
+	var ci container.Container
+	if err := c.doJSON(http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, &ci); err != nil {
+		return nil, fmt.Errorf("client.ContainerInspect: %w", err)
+	}
+	return &ci, nil
+}
+
+func (c *Client) ContainerStart(id string) error {
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerStart: %w", err)
+	}
+	return nil
+}
+
+// ContainerStop timeout 为 0 时使用 daemon 的默认值
+func (c *Client) ContainerStop(id string, timeout time.Duration) error {
+	query := url.Values{}
+	if timeout > 0 {
+		query.Set("t", strconv.Itoa(int(timeout.Seconds())))
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", query, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerStop: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ContainerKill(id, signal string) error {
+	query := url.Values{}
+	if signal != "" {
+		query.Set("signal", signal)
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/kill", query, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerKill: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ContainerRemove(id string, force bool) error {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	if err := c.doJSON(http.MethodDelete, "/containers/"+url.PathEscape(id), query, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerRemove: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ContainerPause(id string) error {
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/pause", nil, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerPause: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ContainerUnpause(id string) error {
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/unpause", nil, nil, nil); err != nil {
+		return fmt.Errorf("client.ContainerUnpause: %w", err)
+	}
+	return nil
+}
+
+// ContainerLogs 返回日志流，follow 为 true 时直到容器退出才结束，调用方负责关闭
+func (c *Client) ContainerLogs(id string, follow bool) (io.ReadCloser, error) {
+	query := url.Values{"follow": {strconv.FormatBool(follow)}}
+	resp, err := c.do(http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs", query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("client.ContainerLogs: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// ContainerAttach 返回容器从现在开始的输出流，调用方负责关闭
+func (c *Client) ContainerAttach(id string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, "/containers/"+url.PathEscape(id)+"/attach", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("client.ContainerAttach: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// ContainerExec 在容器中执行命令，stdin 的内容发送给命令，命令的输出写入 stdout
+func (c *Client) ContainerExec(id string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if err := c.hijack("/containers/"+url.PathEscape(id)+"/exec", api.ExecRequest{Cmd: cmd}, stdin, stdout); err != nil {
+		return fmt.Errorf("client.ContainerExec: %w", err)
+	}
+	return nil
+}
+
+// ContainerStartAttach 启动 tty 容器，stdin 的内容发送给容器，容器的输出写入 stdout，
+// 容器退出后返回，退出码从 ContainerInspect 的 ExitCode 获取
+func (c *Client) ContainerStartAttach(id string, stdin io.Reader, stdout io.Writer) error {
+	if err := c.hijack("/containers/"+url.PathEscape(id)+"/start", nil, stdin, stdout); err != nil {
+		return fmt.Errorf("client.ContainerStartAttach: %w", err)
+	}
+	return nil
+}
+
+// hijack 发送要求升级连接的 POST 请求，服务端接管连接后 stdin 的内容写入连接，
+// 连接上收到的数据写入 stdout，直到服务端关闭连接
+func (c *Client) hijack(path string, body any, stdin io.Reader, stdout io.Writer) error {
+	var r io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url(path, nil), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	// 需要直接使用底层连接双向传输数据，不能经过 http.Client
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return decodeError(resp)
+	}
+
+	if stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			// 通知服务端标准输入已经结束
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+	if _, err := io.Copy(stdout, br); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Events 返回按行编码为 JSON 的事件流，since/until 的格式见 events.ParseTime，
+// filters 为 key=value 形式，follow 为 true 时持续输出新的事件，调用方负责关闭
+func (c *Client) Events(since, until string, filters []string, follow bool) (io.ReadCloser, error) {
+	query := url.Values{"filter": filters, "follow": {strconv.FormatBool(follow)}}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if until != "" {
+		query.Set("until", until)
+	}
+	resp, err := c.do(http.MethodGet, "/events", query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("client.Events: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// SystemReconcile 让 daemon 修复残留的容器、网络和挂载状态
+func (c *Client) SystemReconcile() error {
+	if err := c.doJSON(http.MethodPost, "/system/reconcile", nil, nil, nil); err != nil {
+		return fmt.Errorf("client.SystemReconcile: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) NetworkList() ([]*network.Network, error) {
+	var networks []*network.Network
+	if err := c.doJSON(http.MethodGet, "/networks", nil, nil, &networks); err != nil {
+		return nil, fmt.Errorf("client.NetworkList: %w", err)
+	}
+	return networks, nil
+}
+
+func (c *Client) NetworkCreate(req api.NetworkCreateRequest) error {
+	if err := c.doJSON(http.MethodPost, "/networks/create", nil, req, nil); err != nil {
+		return fmt.Errorf("client.NetworkCreate: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) NetworkRemove(name string) error {
+	if err := c.doJSON(http.MethodDelete, "/networks/"+url.PathEscape(name), nil, nil, nil); err != nil {
+		return fmt.Errorf("client.NetworkRemove: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ImageList() ([]*image.Image, error) {
+	var images []*image.Image
+	if err := c.doJSON(http.MethodGet, "/images/json", nil, nil, &images); err != nil {
+		return nil, fmt.Errorf("client.ImageList: %w", err)
+	}
+	return images, nil
+}
+
+// ImageBuild 把 opts.ContextDir 打包发送给 daemon 构建，构建进度写入 opts.Output。
+// opts.Dockerfile 必须在构建上下文之内
+func (c *Client) ImageBuild(opts builder.Options) error {
+	errFormat := "client.ImageBuild: %w"
+	query := url.Values{"t": opts.Tags, "nocache": {strconv.FormatBool(opts.NoCache)}}
+	if opts.Dockerfile != "" {
+		dir, _ := filepath.Abs(opts.ContextDir)
+		file, _ := filepath.Abs(opts.Dockerfile)
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: Dockerfile %s is outside the build context", errdefs.ErrInvalidArgument, opts.Dockerfile))
+		}
+		query.Set("dockerfile", filepath.ToSlash(rel))
+	}
+	for name, value := range opts.BuildArgs {
+		query.Add("buildarg", name+"="+value)
+	}
+	out := opts.Output
+	if out == nil {
+		out = io.Discard
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Tar(pw, opts.ContextDir))
+	}()
+	defer pr.Close()
+	cr := &countReader{r: pr}
+	resp, err := c.do(http.MethodPost, "/build", query, cr)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer resp.Body.Close()
+	// daemon 解压完整个上下文之后才返回响应头
+	fmt.Fprintf(out, "Sent build context to daemon: %d bytes\n", cr.n)
+	if err := readStream(resp.Body, out); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// countReader 统计读取的字节数
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *Client) Commit(id, ref string, opts runtime.CommitOptions) (*image.Image, error) {
+	query := url.Values{
+		"container": {id},
+		"ref":       {ref},
+		"author":    {opts.Author},
+		"message":   {opts.Message},
+		"change":    opts.Changes,
+		"pause":     {strconv.FormatBool(opts.Pause)},
+	}
+	var img image.Image
+	if err := c.doJSON(http.MethodPost, "/commit", query, nil, &img); err != nil {
+		return nil, fmt.Errorf("client.Commit: %w", err)
+	}
+	return &img, nil
+}
+
+// ImageSave 返回 names 中镜像打包成的 OCI image layout 归档，调用方负责关闭
+func (c *Client) ImageSave(names []string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, "/images/get", url.Values{"names": names}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("client.ImageSave: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// ImageLoad 把归档 r 发送给 daemon 导入，导入的镜像名写入 out
+func (c *Client) ImageLoad(r io.Reader, out io.Writer) error {
+	if err := c.stream(http.MethodPost, "/images/load", nil, r, out); err != nil {
+		return fmt.Errorf("client.ImageLoad: %w", err)
+	}
+	return nil
+}
+
+// ImagePull 让 daemon 从镜像仓库拉取镜像，进度写入 out
+func (c *Client) ImagePull(name string, out io.Writer) error {
+	if err := c.stream(http.MethodPost, "/images/pull", url.Values{"name": {name}}, nil, out); err != nil {
+		return fmt.Errorf("client.ImagePull: %w", err)
+	}
+	return nil
+}
+
+// ImagePush 让 daemon 把镜像推送到名称中的仓库，进度写入 out
+func (c *Client) ImagePush(name string, out io.Writer) error {
+	if err := c.stream(http.MethodPost, "/images/"+url.PathEscape(name)+"/push", nil, nil, out); err != nil {
+		return fmt.Errorf("client.ImagePush: %w", err)
+	}
+	return nil
+}
+
+// Login 让 daemon 检查并保存访问 server 的凭据
+func (c *Client) Login(server, username, password string) error {
+	req := api.AuthRequest{ServerAddress: server, Username: username, Password: password}
+	if err := c.doJSON(http.MethodPost, "/auth", nil, req, nil); err != nil {
+		return fmt.Errorf("client.Login: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Logout(server string) error {
+	if err := c.doJSON(http.MethodDelete, "/auth", url.Values{"server": {server}}, nil, nil); err != nil {
+		return fmt.Errorf("client.Logout: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ImageTag(source, target string) error {
+	query := url.Values{"target": {target}}
+	if err := c.doJSON(http.MethodPost, "/images/"+url.PathEscape(source)+"/tag", query, nil, nil); err != nil {
+		return fmt.Errorf("client.ImageTag: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ImageRemove(name string, force bool) (*image.RemoveResult, error) {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	var res image.RemoveResult
+	if err := c.doJSON(http.MethodDelete, "/images/"+url.PathEscape(name), query, nil, &res); err != nil {
+		return nil, fmt.Errorf("client.ImageRemove: %w", err)
+	}
+	return &res, nil
+}
+
+func (c *Client) ImageInspect(name string) (*image.Detail, error) {
+	var d image.Detail
+	if err := c.doJSON(http.MethodGet, "/images/"+url.PathEscape(name)+"/json", nil, nil, &d); err != nil {
+		return nil, fmt.Errorf("client.ImageInspect: %w", err)
+	}
+	return &d, nil
+}
+
+func (c *Client) ImageVerify() (*api.ImageVerifyResponse, error) {
+	var res api.ImageVerifyResponse
+	if err := c.doJSON(http.MethodGet, "/images/verify", nil, nil, &res); err != nil {
+		return nil, fmt.Errorf("client.ImageVerify: %w", err)
+	}
+	return &res, nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+var AttachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to the output of a running container, eg: attach ID",
+	Action: func(context *cli.Context) error {
+		errFormat := "attachCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: no container ID", errdefs.ErrInvalidArgument))
+		}
+		var rc io.ReadCloser
+		var err error
+		if apiClient != nil {
+			rc, err = apiClient.ContainerAttach(context.Args().Get(0))
+		} else {
+			rc, err = rt.Attach(context.Args().Get(0))
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		defer rc.Close()
+		if _, err := io.Copy(os.Stdout, rc); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	},
+}
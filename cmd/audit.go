@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/audit"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/events"
+)
+
+// mydocker audit --since 24h --user alice --command rm
+// mydocker audit --verify
+var AuditCommand = cli.Command{
+	Name:  "audit",
+	Usage: "show the audit log of commands that changed containers, networks or images",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show records since timestamp, eg: --since 2024-01-02T15:04:05Z, --since 1704207845 or --since 24h",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "show records until timestamp, same format as --since",
+		},
+		cli.StringFlag{
+			Name:  "user",
+			Usage: "only records of this user name, sudo user or uid, eg: --user alice",
+		},
+		cli.StringFlag{
+			Name:  "command",
+			Usage: "only records of commands containing this, eg: --command rm, --command network or --command /containers",
+		},
+		cli.StringFlag{
+			Name:  "target",
+			Usage: "only records whose target container, network or image starts with this, eg: --target web",
+		},
+		cli.BoolFlag{
+			Name:  "failed",
+			Usage: "only failed operations",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, text or json",
+		},
+		cli.BoolFlag{
+			Name:  "verify",
+			Usage: "verify the hash chain of the whole log and print the number of records and the last hash",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "auditCommand: %w"
+		if apiClient != nil {
+			return fmt.Errorf(errFormat, errRemoteNotSupported)
+		}
+		if context.Bool("verify") {
+			n, last, err := audit.VerifyFile()
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			fmt.Printf("%d records verified, last hash %s\n", n, last)
+			return nil
+		}
+		format := context.String("format")
+		if format != "" && format != "text" && format != "json" {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: unsupported format %q", errdefs.ErrInvalidArgument, format))
+		}
+		now := time.Now()
+		f := audit.Filter{
+			User:    context.String("user"),
+			Command: context.String("command"),
+			Target:  context.String("target"),
+			Failed:  context.Bool("failed"),
+		}
+		var err error
+		if f.Since, err = events.ParseTime(context.String("since"), now); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if f.Until, err = events.ParseTime(context.String("until"), now); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		rs, err := audit.Query(f)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		for _, r := range rs {
+			if format == "json" {
+				bs, err := json.Marshal(r)
+				if err != nil {
+					return fmt.Errorf(errFormat, err)
+				}
+				fmt.Println(string(bs))
+				continue
+			}
+			fmt.Println(r.String())
+		}
+		return nil
+	},
+}
+
+// audited 包装修改状态的命令，命令执行结束后追加一条审计记录，target 返回操作的目标类型和名称。
+// 通过 -H 访问 daemon 时由 daemon 记录，本地不重复记录。审计日志无法写入时命令失败
+func audited(target func(*cli.Context) (string, string), action func(*cli.Context) error) func(*cli.Context) error {
+	return func(context *cli.Context) error {
+		local := apiClient == nil && rt != nil
+		if local {
+			if err := audit.Check(); err != nil {
+				return fmt.Errorf("%s: %w", context.Command.FullName(), err)
+			}
+		}
+		start := time.Now().UTC()
+		err := action(context)
+		if !local {
+			return err
+		}
+		r := &audit.Record{
+			Time:    start,
+			Source:  audit.SOURCE_CLI,
+			Command: context.Command.FullName(),
+			Args:    audit.RedactArgs(os.Args[1:], "e", "build-arg"),
+		}
+		r.UID, r.User, r.SudoUser = audit.CurrentUser()
+		r.TargetType, r.Target = target(context)
+		if lerr := audit.Log(r, err); lerr != nil {
+			if err != nil {
+				logrus.Errorln(lerr)
+				return err
+			}
+			return fmt.Errorf("%s: %w", context.Command.FullName(), lerr)
+		}
+		return err
+	}
+}
+
+// containerTarget 第一个参数是容器 ID 或名称的命令
+func containerTarget(context *cli.Context) (string, string) {
+	return audit.TARGET_CONTAINER, context.Args().First()
+}
+
+// networkTarget 第一个参数是网络名称的命令
+func networkTarget(context *cli.Context) (string, string) {
+	return audit.TARGET_NETWORK, context.Args().First()
+}
+
+// imageTarget 第一个参数是镜像名称的命令
+func imageTarget(context *cli.Context) (string, string) {
+	return audit.TARGET_IMAGE, context.Args().First()
+}
+
+// runTarget 指定了 --name 时目标为容器，否则容器 ID 在创建前未知，记录使用的镜像
+func runTarget(context *cli.Context) (string, string) {
+	if name := context.String("name"); name != "" {
+		return audit.TARGET_CONTAINER, name
+	}
+	return imageTarget(context)
+}
+
+// commitTarget commit 和 tag 的目标是生成的镜像引用
+func commitTarget(context *cli.Context) (string, string) {
+	return audit.TARGET_IMAGE, context.Args().Get(1)
+}
+
+// buildTarget build 的目标是第一个 -t 指定的镜像引用
+func buildTarget(context *cli.Context) (string, string) {
+	var tag string
+	if tags := context.StringSlice("tag"); len(tags) > 0 {
+		tag = tags[0]
+	}
+	return audit.TARGET_IMAGE, tag
+}
+
+func noTarget(*cli.Context) (string, string) {
+	return "", ""
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/builder"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+var BuildCommand = cli.Command{
+	Name:  "build",
+	Usage: "mydocker build -t [registry/]repo[:tag] [-f Dockerfile] context",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "t, tag",
+			Usage: "name and optionally a tag of the image, can be repeated",
+		},
+		cli.StringFlag{
+			Name:  "f, file",
+			Usage: "path of the Dockerfile, default is context/Dockerfile",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "set a build-time variable declared by ARG, eg: --build-arg VERSION=1",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cached results of previous builds",
+		},
+	},
+	Action: audited(buildTarget, func(ctx *cli.Context) error {
+		logrus.Debugln("build image")
+		errFormat := "build: %w"
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: requires exactly one context directory", errdefs.ErrInvalidArgument))
+		}
+		args := map[string]string{}
+		for _, a := range ctx.StringSlice("build-arg") {
+			name, value, ok := strings.Cut(a, "=")
+			if !ok {
+				// 只给出名称时使用同名的环境变量
+				if value, ok = os.LookupEnv(name); !ok {
+					continue
+				}
+			}
+			args[name] = value
+		}
+		opts := builder.Options{
+			ContextDir: ctx.Args().First(),
+			Dockerfile: ctx.String("file"),
+			Tags:       ctx.StringSlice("tag"),
+			BuildArgs:  args,
+			NoCache:    ctx.Bool("no-cache"),
+			Output:     os.Stdout,
+		}
+		var err error
+		if apiClient != nil {
+			err = apiClient.ImageBuild(opts)
+		} else {
+			_, err = builder.Build(rt, opts)
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	}),
+}
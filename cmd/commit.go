@@ -1,33 +1,62 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
-	"log"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/errdefs"
 	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var CommitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "mydocker commit containerID imageName",
-	Action: func(ctx *cli.Context) error {
-		log.Println("[debug] build image")
+	Usage: "mydocker commit containerID [registry/]repo[:tag]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "a, author",
+			Usage: "image author, eg: commit -a 'name <email>'",
+		},
+		cli.StringFlag{
+			Name:  "m, message",
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "c, change",
+			Usage: "apply a Dockerfile instruction (CMD, ENTRYPOINT, ENV, LABEL, USER, WORKDIR), eg: commit -c 'ENV a=b'",
+		},
+		cli.BoolTFlag{
+			Name:  "p, pause",
+			Usage: "pause the container during commit, disable with --pause=false",
+		},
+	},
+	Action: audited(commitTarget, func(ctx *cli.Context) error {
+		logrus.Debugln("build image")
 		errFormat := "build image: %w"
 		if len(ctx.Args()) < 2 {
-			return fmt.Errorf(errFormat, errors.New("too few args"))
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
 		}
 		containerID := ctx.Args().Get(0)
 		imageName := ctx.Args().Get(1)
-		c := GetContainerInfo(containerID)
-		if c == nil {
-			return fmt.Errorf(errFormat, container.ErrContainerNotExist)
+		if _, err := image.ParseTag(imageName); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		opts := runtime.CommitOptions{
+			Author:  ctx.String("author"),
+			Message: ctx.String("message"),
+			Changes: ctx.StringSlice("change"),
+			Pause:   ctx.BoolT("pause"),
 		}
-		if err := image.BuildImage(c.Id, imageName); err != nil {
+		commit := rt.Commit
+		if apiClient != nil {
+			commit = apiClient.Commit
+		}
+		img, err := commit(containerID, imageName, opts)
+		if err != nil {
 			return fmt.Errorf(errFormat, err)
 		}
+		fmt.Println(img.ID)
 		return nil
-	},
+	}),
 }
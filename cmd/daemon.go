@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/api/server"
+	"golang.org/x/sys/unix"
+)
+
+// daemon 周期性地检查容器状态，回收不是由本 daemon 启动的容器
+const reconcileInterval = 10 * time.Second
+
+var DaemonCommand = cli.Command{
+	Name:  "daemon",
+	Usage: "run mydocker daemon serving the HTTP API",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "host, H",
+			Usage: "address to listen on, eg: daemon -H unix:///run/mydocker.sock -H tcp://127.0.0.1:2375, default " + api.DEFAULT_HOST,
+		},
+		cli.BoolFlag{
+			Name:  "unsafe-unauthenticated-loopback-tcp",
+			Usage: "allow tcp:// on a loopback address without --tlsverify, any local user can then control the daemon",
+		},
+		cli.StringFlag{
+			Name:  "metrics-addr",
+			Usage: "serve prometheus metrics on /metrics at this address, eg: daemon --metrics-addr " + defaultMetricsAddr,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "daemonCommand: %w"
+		hosts := context.StringSlice("host")
+		// 没有指定监听地址时使用全局的 -H/MYDOCKER_HOST，客户端不需要额外配置就能连上
+		if len(hosts) == 0 && context.GlobalString("host") != "" {
+			hosts = []string{context.GlobalString("host")}
+		}
+		if len(hosts) == 0 {
+			hosts = []string{api.DEFAULT_HOST}
+		}
+		var tlsConfig *tls.Config
+		if opts := TLSOptions(context); opts.Enabled() {
+			var err error
+			if tlsConfig, err = api.ServerTLSConfig(opts); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+		if err := rt.Reconcile(true); err != nil {
+			logrus.Warnln("daemon:", err)
+		}
+
+		unsafeLoopback := context.Bool("unsafe-unauthenticated-loopback-tcp")
+		if unsafeLoopback {
+			logrus.Warnln("daemon: unauthenticated tcp on loopback is enabled, every local user can start privileged containers")
+		}
+
+		s := server.New(rt)
+		errCh := make(chan error, len(hosts)+1)
+		for _, host := range hosts {
+			l, err := server.Listen(host, tlsConfig, unsafeLoopback)
+			if err != nil {
+				s.Close()
+				return fmt.Errorf(errFormat, err)
+			}
+			logrus.Infof("daemon listening on %s", host)
+			go func() {
+				errCh <- s.Serve(l)
+			}()
+		}
+
+		if addr := context.String("metrics-addr"); addr != "" {
+			ms := newMetricsServer(addr)
+			defer ms.Close()
+			go func() {
+				if err := ms.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					errCh <- fmt.Errorf("metrics: %w", err)
+				}
+			}()
+			logrus.Infof("metrics listening on %s", addr)
+		}
+		if webhooks != nil {
+			done := make(chan struct{})
+			defer close(done)
+			go webhooks.Run(done, webhookInterval)
+		}
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, unix.SIGINT, unix.SIGTERM)
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := rt.Reconcile(false); err != nil {
+					logrus.Warnln("daemon:", err)
+				}
+			case sig := <-sigCh:
+				logrus.Infof("daemon received %s, shutting down", sig)
+				return s.Close()
+			case err := <-errCh:
+				s.Close()
+				if err != nil {
+					return fmt.Errorf(errFormat, err)
+				}
+				return nil
+			}
+		}
+	},
+}
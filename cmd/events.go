@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/events"
+)
+
+// mydocker events --since 10m --filter type=container --filter event=die --format json
+var EventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "show container and network events, follow new events until --until is reached",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show events created since timestamp, eg: --since 2024-01-02T15:04:05Z, --since 1704207845 or --since 10m",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "stream events until timestamp, same format as --since, eg: --until 0s to stop at the current end",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "filter events, keys: type, event, container, network, eg: --filter type=container --filter event=die",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, text or json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "eventsCommand: %w"
+		format := context.String("format")
+		if format != "" && format != "text" && format != "json" {
+			return fmt.Errorf(errFormat, fmt.Errorf("unsupported format %q", format))
+		}
+		printEvent := func(e *events.Event) error {
+			if format == "json" {
+				bs, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(bs))
+				return nil
+			}
+			fmt.Println(e.String())
+			return nil
+		}
+		since, until, filters := context.String("since"), context.String("until"), context.StringSlice("filter")
+		// 和 docker events 一样，没有指定 --until 时持续等待新的事件
+		follow := until == ""
+
+		if apiClient != nil {
+			rc, err := apiClient.Events(since, until, filters, follow)
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			defer rc.Close()
+			dec := json.NewDecoder(rc)
+			for {
+				var e events.Event
+				if err := dec.Decode(&e); err != nil {
+					if errors.Is(err, io.EOF) {
+						return nil
+					}
+					return fmt.Errorf(errFormat, err)
+				}
+				if err := printEvent(&e); err != nil {
+					return fmt.Errorf(errFormat, err)
+				}
+			}
+		}
+
+		now := time.Now()
+		var f events.Filter
+		var err error
+		if f.Since, err = events.ParseTime(since, now); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if f.Until, err = events.ParseTime(until, now); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if f.Filters, err = events.ParseFilters(filters); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		var done chan struct{}
+		if follow {
+			// 一直等待，直到进程被 Ctrl-C 结束
+			done = make(chan struct{})
+		}
+		if err := events.Follow(f, done, printEvent); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/runtime"
+)
+
+func init() {
+	ImageCommand.Subcommands = []cli.Command{
+		ImageInspectCommand,
+		ImageVerifyCommand,
+	}
+}
+
+var ImageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image management",
+}
+
+// mydocker images
+var ImagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(context *cli.Context) error {
+		errFormat := "imagesCommand: %w"
+		var images []*image.Image
+		var err error
+		if apiClient != nil {
+			images, err = apiClient.ImageList()
+		} else {
+			images, err = image.List()
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		printImages(images)
+		return nil
+	},
+}
+
+func printImages(images []*image.Image) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		repo, tag := "<none>", "<none>"
+		if r, err := image.ParseReference(img.Name); err == nil {
+			repo, tag = r.Name(), r.Tag
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			repo,
+			tag,
+			shortID(img.ID),
+			img.Created.Local().Format("2006-01-02 15:04:05"),
+			humanSize(img.Size),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorln("printImages:", err)
+	}
+}
+
+// shortID 去掉摘要的算法前缀，保留前 12 位
+func shortID(digest string) string {
+	id := strings.TrimPrefix(digest, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	f := float64(size)
+	i := 0
+	for f >= 1000 && i < len(units)-1 {
+		f /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, units[0])
+	}
+	return fmt.Sprintf("%.3g%s", f, units[i])
+}
+
+// mydocker rmi [-f] IMAGE...
+var RemoveImageCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove images not used by containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "remove images even if containers use them, eg: rmi -f IMAGE",
+		},
+	},
+	Action: audited(imageTarget, func(context *cli.Context) error {
+		errFormat := "rmiCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		opts := runtime.RemoveImageOptions{Force: context.Bool("f")}
+		for _, name := range context.Args() {
+			var res *image.RemoveResult
+			var err error
+			if apiClient != nil {
+				res, err = apiClient.ImageRemove(name, opts.Force)
+			} else {
+				res, err = rt.RemoveImage(name, opts)
+			}
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			for _, ref := range res.Untagged {
+				fmt.Println("Untagged:", ref)
+			}
+			for _, d := range res.Deleted {
+				fmt.Println("Deleted:", d)
+			}
+		}
+		return nil
+	}),
+}
+
+// mydocker tag SOURCE TARGET
+var TagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "create a tag TARGET that refers to SOURCE, eg: tag app:latest registry.local/app:1.2",
+	Action: audited(commitTarget, func(context *cli.Context) error {
+		errFormat := "tagCommand: %w"
+		if len(context.Args()) != 2 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: requires SOURCE and TARGET", errdefs.ErrInvalidArgument))
+		}
+		tag := image.Tag
+		if apiClient != nil {
+			tag = apiClient.ImageTag
+		}
+		if err := tag(context.Args().Get(0), context.Args().Get(1)); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	}),
+}
+
+// mydocker save -o out.tar IMAGE...
+var SaveCommand = cli.Command{
+	Name:  "save",
+	Usage: "save images to a tar archive in OCI image layout, eg: save -o app.tar app:1 app:2",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o, output",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "saveCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		save := image.Save
+		if apiClient != nil {
+			save = saveRemote
+		}
+		output := context.String("output")
+		if output == "" {
+			if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+				return fmt.Errorf(errFormat, fmt.Errorf("%w: refusing to write an archive to a terminal, use -o or redirect stdout", errdefs.ErrInvalidArgument))
+			}
+			if err := save(os.Stdout, context.Args()); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		// 先写临时文件，失败时不留下不完整的归档
+		tmp := output + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		err = save(f, context.Args())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp, output)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	},
+}
+
+// saveRemote 从 daemon 获取 save 的归档写入 w
+func saveRemote(w io.Writer, names []string) error {
+	rc, err := apiClient.ImageSave(names)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
+
+// mydocker load -i in.tar
+var LoadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a tar archive in OCI image layout or created by docker save",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i, input",
+			Usage: "read from a file instead of stdin",
+		},
+	},
+	Action: audited(noTarget, func(context *cli.Context) error {
+		errFormat := "loadCommand: %w"
+		in := os.Stdin
+		if input := context.String("input"); input != "" {
+			f, err := os.Open(input)
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			defer f.Close()
+			in = f
+		}
+		if apiClient != nil {
+			if err := apiClient.ImageLoad(in, os.Stdout); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		images, err := image.Import(in)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		for _, img := range images {
+			fmt.Println("Loaded image:", img.Name)
+		}
+		return nil
+	}),
+}
+
+// mydocker image inspect IMAGE...
+var ImageInspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "show image config, layers and history as json",
+	Action: func(context *cli.Context) error {
+		errFormat := "image.Inspect: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		details := make([]*image.Detail, 0, len(context.Args()))
+		for _, name := range context.Args() {
+			var d *image.Detail
+			var err error
+			if apiClient != nil {
+				d, err = apiClient.ImageInspect(name)
+			} else {
+				d, err = image.Inspect(name)
+			}
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			details = append(details, d)
+		}
+		bs, err := json.MarshalIndent(details, "", "    ")
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		fmt.Println(string(bs))
+		return nil
+	},
+}
+
+// mydocker image verify
+var ImageVerifyCommand = cli.Command{
+	Name:  "verify",
+	Usage: "re-hash every blob in the image store and check image references",
+	Action: func(context *cli.Context) error {
+		errFormat := "image.Verify: %w"
+		var checked int
+		var problems []string
+		if apiClient != nil {
+			res, err := apiClient.ImageVerify()
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			checked, problems = res.Checked, res.Problems
+		} else {
+			var err error
+			if checked, problems, err = image.Verify(); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: %d problems in %d blobs", image.ErrCorrupt, len(problems), checked))
+		}
+		fmt.Printf("%d blobs verified\n", checked)
+		return nil
+	},
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+var KillCommand = cli.Command{
+	Name:  "kill",
+	Usage: "send a signal to running containers",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "signal to send, eg: kill -s SIGTERM ID",
+			Value: "SIGKILL",
+		},
+	},
+	Action: audited(containerTarget, func(context *cli.Context) error {
+		errFormat := "killCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		name := context.String("s")
+		sig := unix.SignalNum(name)
+		if sig == 0 {
+			return fmt.Errorf(errFormat, fmt.Errorf("unknown signal %s", name))
+		}
+		for _, id := range context.Args() {
+			var err error
+			if apiClient != nil {
+				err = apiClient.ContainerKill(id, name)
+			} else {
+				err = rt.Kill(id, sig)
+			}
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+		return nil
+	}),
+}
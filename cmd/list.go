@@ -2,13 +2,13 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"text/tabwriter"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/consts"
 	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var ListCommand = cli.Command{
@@ -21,26 +21,29 @@ var ListCommand = cli.Command{
 		},
 	},
 	Action: func(context *cli.Context) error {
-		fmt.Println("[debug] list container info")
-		all := context.Bool("a")
-		fs := findJsonFilePathAll(consts.PATH_CONTAINER)
-		cis := getContainerInfoAll(fs)
-		printContainerInfo(cis, all)
+		var cis []*container.Container
+		var err error
+		if apiClient != nil {
+			cis, err = apiClient.ContainerList(context.Bool("a"))
+		} else {
+			cis, err = rt.List(runtime.ListOptions{All: context.Bool("a")})
+		}
+		if err != nil {
+			return fmt.Errorf("listCommand: %w", err)
+		}
+		printContainerInfo(cis)
 		return nil
 	},
 }
 
-func printContainerInfo(ci []*container.Container, all bool) {
+func printContainerInfo(ci []*container.Container) {
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	_, err := fmt.Fprint(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPID\tNAME\n")
 	if err != nil {
-		log.Println("[error] printContainerInfo:", err)
+		logrus.Errorln("printContainerInfo:", err)
 	}
 
 	for _, c := range ci {
-		if c.Status != consts.STATUS_RUNNING && !all {
-			continue
-		}
 		printID := c.Id
 		if len(c.Id) > 12 {
 			printID = c.Id[:12]
@@ -55,10 +58,10 @@ func printContainerInfo(ci []*container.Container, all bool) {
 			c.Name,
 		)
 		if err != nil {
-			log.Println("[error] printContainerInfo:", err)
+			logrus.Errorln("printContainerInfo:", err)
 		}
 	}
 	if err := w.Flush(); err != nil {
-		log.Println("[error] printContainerInfo:", err)
+		logrus.Errorln("printContainerInfo:", err)
 	}
 }
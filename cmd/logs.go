@@ -1,33 +1,43 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var LogsCommand = cli.Command{
 	Name:  "logs",
 	Usage: "get container logs",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "follow log output, eg: logs -f ID",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		log.Println("[debug] get container logs")
+		logrus.Debugln("get container logs")
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("logsCommand: %w", errors.New("no container ID"))
+			return fmt.Errorf("logsCommand: %w", fmt.Errorf("%w: no container ID", errdefs.ErrInvalidArgument))
+		}
+		var rc io.ReadCloser
+		var err error
+		if apiClient != nil {
+			rc, err = apiClient.ContainerLogs(context.Args().Get(0), context.Bool("f"))
+		} else {
+			rc, err = rt.Logs(context.Args().Get(0), runtime.LogsOptions{Follow: context.Bool("f")})
+		}
+		if err != nil {
+			return fmt.Errorf("logsCommand: %w", err)
 		}
-		cSubID := context.Args().Get(0)
-		f := findJsonFilePath(cSubID, consts.PATH_CONTAINER)
-		c := getContainerInfo(f)
-		if c != nil {
-			logFile := fmt.Sprintf("%s/%s/%s.log", consts.PATH_CONTAINER, c.Id, c.Id)
-			bs, err := os.ReadFile(logFile)
-			if err != nil {
-				return fmt.Errorf("logsCommand: %w", err)
-			}
-			fmt.Printf("%s\n", bs)
+		defer rc.Close()
+		if _, err := io.Copy(os.Stdout, rc); err != nil {
+			return fmt.Errorf("logsCommand: %w", err)
 		}
 		return nil
 	},
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/metrics"
+	"github.com/wlbyte/mydocker/runtime"
+	"golang.org/x/sys/unix"
+)
+
+const defaultMetricsAddr = "127.0.0.1:9323"
+
+func init() {
+	MetricsCommand.Subcommands = []cli.Command{
+		MetricsServeCommand,
+	}
+}
+
+var MetricsCommand = cli.Command{
+	Name:  "metrics",
+	Usage: "prometheus metrics",
+}
+
+var MetricsServeCommand = cli.Command{
+	Name:  "serve",
+	Usage: "serve prometheus metrics on /metrics without running the daemon",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "addr",
+			Usage: "address to listen on, eg: metrics serve --addr 0.0.0.0:9323",
+			Value: defaultMetricsAddr,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "metrics.Serve: %w"
+		if apiClient != nil {
+			return fmt.Errorf(errFormat, errRemoteNotSupported)
+		}
+		srv := newMetricsServer(context.String("addr"))
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, unix.SIGINT, unix.SIGTERM)
+		go func() {
+			<-sigCh
+			srv.Close()
+		}()
+		logrus.Infof("metrics listening on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	},
+}
+
+func newMetricsServer(addr string) *http.Server {
+	collector := metrics.NewCollector(func() ([]*container.Container, error) {
+		return rt.List(runtime.ListOptions{All: true})
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", collector)
+	return &http.Server{Addr: addr, Handler: mux}
+}
@@ -3,10 +3,9 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/api"
 	"github.com/wlbyte/mydocker/network"
 )
 
@@ -43,30 +42,42 @@ var NetworkCreateCommand = cli.Command{
 			Usage: "subnet cidr",
 		},
 	},
-	Action: func(context *cli.Context) error {
+	Action: audited(networkTarget, func(context *cli.Context) error {
 		errFormat := "network.Create: %w"
 		if len(context.Args()) < 1 {
 			return fmt.Errorf(errFormat, fmt.Errorf("missing network name"))
 		}
 		networkName := context.Args().Get(0)
-		driverStr := context.String("driver")
-		subnetStr := context.String("subnet")
-
-		if err := network.ConfigBridge(driverStr, networkName, subnetStr); err != nil {
+		if apiClient != nil {
+			req := api.NetworkCreateRequest{Name: networkName, Driver: context.String("driver"), Subnet: context.String("subnet")}
+			if err := apiClient.NetworkCreate(req); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		if err := network.CreateNetwork(context.String("driver"), networkName, context.String("subnet")); err != nil {
 			return fmt.Errorf(errFormat, err)
 		}
-
 		return nil
-	},
+	}),
 }
 
 var NetworkListCommand = cli.Command{
 	Name:  "list",
 	Usage: "list container network",
 	Action: func(context *cli.Context) error {
-		jsonFiles := findJsonFilePathAll(consts.PATH_NETWORK_NETWORK)
-		for _, f := range jsonFiles {
-			bs, err := os.ReadFile(f)
+		var networks []*network.Network
+		var err error
+		if apiClient != nil {
+			networks, err = apiClient.NetworkList()
+		} else {
+			networks, err = network.ListNetworks()
+		}
+		if err != nil {
+			return fmt.Errorf("network.List: %w", err)
+		}
+		for _, n := range networks {
+			bs, err := json.Marshal(n)
 			if err != nil {
 				continue
 			}
@@ -80,45 +91,20 @@ var NetworkRemoveCommand = cli.Command{
 	Name:  "remove",
 	Usage: "remove container network",
 
-	Action: func(context *cli.Context) error {
+	Action: audited(networkTarget, func(context *cli.Context) error {
 		errFormat := "network.Remove: %w"
 		if len(context.Args()) < 1 {
 			return fmt.Errorf(errFormat, fmt.Errorf("missing network name"))
 		}
-		networkName := context.Args().Get(0)
-		if networkName == "mydocker0" {
-			return fmt.Errorf(errFormat, fmt.Errorf("couldn't remove default network"))
-		}
-		n := &network.Network{
-			Name: networkName,
-		}
-		jsonFile := findJsonFilePath(n.Name, consts.PATH_NETWORK_NETWORK)
-		var driver network.Driver
-		driver, err := network.NewNetworkDriver(n.Driver)
-		if err != nil {
-			return fmt.Errorf(errFormat, err)
-		}
-		driver.Delete(n.Name)
-		bs, err := os.ReadFile(jsonFile)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return nil
+		if apiClient != nil {
+			if err := apiClient.NetworkRemove(context.Args().Get(0)); err != nil {
+				return fmt.Errorf(errFormat, err)
 			}
-			return fmt.Errorf(errFormat, err)
+			return nil
 		}
-		if err := json.Unmarshal(bs, n); err != nil {
-			return fmt.Errorf(errFormat, err)
-		}
-		ipam := network.NewIPAM()
-		if err := ipam.ReleaseSubnet(n.Subnet); err != nil {
-			return fmt.Errorf(errFormat, err)
-		}
-		if err := os.Remove(jsonFile); err != nil {
-			if os.IsNotExist(err) {
-				return nil
-			}
+		if err := network.RemoveNetwork(context.Args().Get(0)); err != nil {
 			return fmt.Errorf(errFormat, err)
 		}
 		return nil
-	},
+	}),
 }
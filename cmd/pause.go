@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+var PauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "pause all processes within containers, eg: pause ID",
+	Action: audited(containerTarget, func(context *cli.Context) error {
+		errFormat := "pauseCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		for _, id := range context.Args() {
+			var err error
+			if apiClient != nil {
+				err = apiClient.ContainerPause(id)
+			} else {
+				err = rt.Pause(id)
+			}
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+		return nil
+	}),
+}
+
+var UnpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "unpause all processes within containers, eg: unpause ID",
+	Action: audited(containerTarget, func(context *cli.Context) error {
+		errFormat := "unpauseCommand: %w"
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		for _, id := range context.Args() {
+			var err error
+			if apiClient != nil {
+				err = apiClient.ContainerUnpause(id)
+			} else {
+				err = rt.Unpause(id)
+			}
+			if err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/registry"
+)
+
+// mydocker pull [registry/]repo[:tag][@digest]
+var PullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "pull an image from a registry, eg: pull registry.local:5000/app:1.2",
+	Action: audited(imageTarget, func(context *cli.Context) error {
+		errFormat := "pullCommand: %w"
+		if len(context.Args()) != 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: requires exactly one image", errdefs.ErrInvalidArgument))
+		}
+		if apiClient != nil {
+			if err := apiClient.ImagePull(context.Args().First(), os.Stdout); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		if _, err := registry.Pull(context.Args().First(), registry.Options{Output: os.Stdout}); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	}),
+}
+
+// mydocker push [registry/]repo[:tag]
+var PushCommand = cli.Command{
+	Name:  "push",
+	Usage: "push an image to the registry in its name, eg: push registry.local:5000/app:1.2",
+	Action: audited(imageTarget, func(context *cli.Context) error {
+		errFormat := "pushCommand: %w"
+		if len(context.Args()) != 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: requires exactly one image", errdefs.ErrInvalidArgument))
+		}
+		if apiClient != nil {
+			if err := apiClient.ImagePush(context.Args().First(), os.Stdout); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		if err := registry.Push(context.Args().First(), registry.Options{Output: os.Stdout}); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	}),
+}
+
+// mydocker login [-u user] [-p password | --password-stdin] [SERVER]
+// 不记录审计日志，命令行参数中可能有密码
+var LoginCommand = cli.Command{
+	Name:  "login",
+	Usage: "log in to a registry, default is docker.io",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "u, username",
+			Usage: "username",
+		},
+		cli.StringFlag{
+			Name:  "p, password",
+			Usage: "password, prefer --password-stdin",
+		},
+		cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "read the password from stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "loginCommand: %w"
+		if len(context.Args()) > 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too many args", errdefs.ErrInvalidArgument))
+		}
+		username, password := context.String("username"), context.String("password")
+		if context.Bool("password-stdin") {
+			if password != "" {
+				return fmt.Errorf(errFormat, fmt.Errorf("%w: --password and --password-stdin are mutually exclusive", errdefs.ErrInvalidArgument))
+			}
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf(errFormat, err)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		} else if password != "" {
+			logrus.Warnln("using --password on the command line is insecure, use --password-stdin")
+		}
+		if username == "" || password == "" {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: username and password are required", errdefs.ErrInvalidArgument))
+		}
+		login := func(server, username, password string) error {
+			return registry.Login(server, username, password, registry.Options{})
+		}
+		if apiClient != nil {
+			login = apiClient.Login
+		}
+		if err := login(context.Args().First(), username, password); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		fmt.Println("Login Succeeded")
+		return nil
+	},
+}
+
+// mydocker logout [SERVER]
+var LogoutCommand = cli.Command{
+	Name:  "logout",
+	Usage: "remove saved credentials of a registry, default is docker.io",
+	Action: func(context *cli.Context) error {
+		errFormat := "logoutCommand: %w"
+		logout := registry.Logout
+		if apiClient != nil {
+			logout = apiClient.Logout
+		}
+		if err := logout(context.Args().First()); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		return nil
+	},
+}
@@ -1,14 +1,12 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
-	"log"
-	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/consts"
-	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var RemoveCommand = cli.Command{
@@ -20,41 +18,24 @@ var RemoveCommand = cli.Command{
 			Usage: "force remove container, eg: rm -f ID ",
 		},
 	},
-	Action: func(ctx *cli.Context) error {
-		log.Println("[debug] remove container")
+	Action: audited(containerTarget, func(ctx *cli.Context) error {
+		logrus.Debugln("remove container")
 		errFormat := "rmCommand: %w"
 		if len(ctx.Args()) < 1 {
-			return fmt.Errorf(errFormat, errors.New("too few args"))
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
 		}
-		f := ctx.Bool("f")
-		if err := rmContainer(ctx.Args(), f); err != nil {
-			return fmt.Errorf(errFormat, err)
-		}
-		return nil
-	},
-}
-
-func rmContainer(containerIDs []string, force bool) error {
-	errFormat := "rmContainer: %w"
-	for _, id := range containerIDs {
-		c := GetContainerInfo(id)
-		if c == nil {
-			return fmt.Errorf(errFormat, errors.New("conainter is not exist"))
-		}
-		if c.Status == consts.STATUS_RUNNING {
-			if !force {
-				return fmt.Errorf(errFormat, errors.New("container must be stopped"))
+		opts := runtime.RemoveOptions{Force: ctx.Bool("f")}
+		for _, id := range ctx.Args() {
+			if apiClient != nil {
+				if err := apiClient.ContainerRemove(id, opts.Force); err != nil {
+					return fmt.Errorf(errFormat, err)
+				}
+				continue
 			}
-			if err := stopContainer(id); err != nil {
+			if err := rt.Remove(id, opts); err != nil {
 				return fmt.Errorf(errFormat, err)
 			}
 		}
-		container.DelWorkspace(c)
-
-		if err := os.Remove(findJsonFilePath(c.Id, consts.PATH_NETWORK_ENDPOINT)); err != nil {
-			return fmt.Errorf(errFormat, err)
-		}
-	}
-
-	return nil
+		return nil
+	}),
 }
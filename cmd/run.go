@@ -1,26 +1,20 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
 	"os"
-	"strings"
-	"time"
-
-	"log"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/cgroups"
+	"github.com/wlbyte/mydocker/api"
 	"github.com/wlbyte/mydocker/cgroups/subsystems"
-	"github.com/wlbyte/mydocker/consts"
-	"github.com/wlbyte/mydocker/container"
-	"github.com/wlbyte/mydocker/network"
-	"github.com/wlbyte/mydocker/utils"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var RunCommand = cli.Command{
 	Name:  "run",
-	Usage: "Create a container",
+	Usage: "Create a container, eg: run -d IMAGE [COMMAND [ARG...]]",
 
 	Flags: []cli.Flag{
 		cli.BoolFlag{
@@ -64,112 +58,115 @@ var RunCommand = cli.Command{
 			Usage: "port mapping, eg: run -p 8080:80",
 		},
 	},
-	Action: func(context *cli.Context) error {
+	Action: audited(runTarget, func(context *cli.Context) error {
 		errFormat := "runCommand: %w"
-		if len(context.Args()) < 2 {
-			return fmt.Errorf(errFormat, errors.New("too few args"))
+		if len(context.Args()) < 1 {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
+		}
+		tty, detach := context.Bool("it"), context.Bool("d")
+		if tty && detach || (!tty && !detach) {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: choose flag between -it and -d", errdefs.ErrInvalidArgument))
 		}
-		c := &container.Container{
+		opts := runtime.CreateOptions{
 			Name:        context.String("name"),
-			TTY:         context.Bool("it"),
-			Detach:      context.Bool("d"),
+			Image:       context.Args().Get(0),
+			Cmd:         context.Args().Tail(),
+			TTY:         tty,
+			Detach:      detach,
 			Volume:      context.String("v"),
-			Environment: context.StringSlice("e"),
+			Env:         context.StringSlice("e"),
 			Network:     context.String("net"),
 			PortMapping: context.StringSlice("p"),
-			CreateAt:    time.Now().Format("2006-01-02 15:04:05"),
-		}
-		if c.TTY && c.Detach || (!c.TTY && !c.Detach) {
-			return fmt.Errorf(errFormat, errors.New("choose flag between -it and -d"))
-		}
-		id, err := utils.HashStr(c)
-		if err != nil {
-			return fmt.Errorf(errFormat, err)
+			Resources: &subsystems.ResourceConfig{
+				MemoryLimit: context.String("mem"),
+				Cpus:        context.String("cpu"),
+				CpuSet:      context.String("cpuset"),
+			},
 		}
-		c.Id = id
-		if c.Name == "" {
-			if len(c.Id) > 12 {
-				c.Name = c.Id[:12]
-			} else {
-				c.Name = c.Id
+		if apiClient != nil {
+			if err := runRemote(opts); err != nil {
+				return fmt.Errorf(errFormat, err)
 			}
+			return nil
 		}
-		if c.Network == "" {
-			c.Network = "mydocker0"
-		}
-		c.ImageName = context.Args().Get(0)
-		c.Cmds = context.Args().Tail()
-		c.ResourceConfig = &subsystems.ResourceConfig{
-			MemoryLimit: context.String("mem"),
-			Cpus:        context.String("cpu"),
-			CpuSet:      context.String("cpuset"),
+		if err := run(opts); err != nil {
+			return fmt.Errorf(errFormat, err)
 		}
-		run(c)
 		return nil
-	},
+	}),
 }
 
-func run(c *container.Container) {
-	parent, writePipe, err := container.NewParentProcess(c)
+// run 创建并启动容器，启动失败时删除已经创建的容器，tty 模式下等待容器退出
+func run(opts runtime.CreateOptions) error {
+	errFormat := "run: %w"
+	c, err := rt.Create(opts)
 	if err != nil {
-		log.Println("[error] run:", err)
-		return
+		return fmt.Errorf(errFormat, err)
 	}
-	defer writePipe.Close()
-	if err := parent.Start(); err != nil {
-		log.Println("[error] run:", err)
-		return
+	if err := rt.Start(c.Id, runtime.StartOptions{}); err != nil {
+		if rmErr := rt.Remove(c.Id, runtime.RemoveOptions{Force: true}); rmErr != nil {
+			logrus.Errorln("run:", rmErr)
+		}
+		return fmt.Errorf(errFormat, err)
 	}
-	sendInitCommand(c.Cmds, writePipe)
-	log.Println("[debug] send init command to pipe")
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	if err := cgroupManager.Set(c.ResourceConfig); err != nil {
-		log.Println("[error] run:", err)
+	if !c.TTY {
+		logrus.Debugln("run as a daemon")
+		return nil
 	}
-	if err := cgroupManager.Apply(parent.Process.Pid, c.ResourceConfig); err != nil {
-		log.Println("[error] run:", err)
+	exitCode, err := rt.Wait(c.Id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
 	}
-
-	// 持久化容器信息
-	c.Pid = parent.Process.Pid
-	c.Status = consts.STATUS_RUNNING
-	if err := recordContainerInfo(c); err != nil {
-		log.Printf("[error] run: %s", err)
-		return
+	// 前台运行时 mydocker 的退出码就是容器进程的退出码
+	if exitCode != 0 {
+		return &errdefs.ExitError{Code: exitCode}
 	}
+	return nil
+}
 
-	// 配置网络
-	if err := network.Connect(c); err != nil {
-		log.Printf("[error] run: %s", err)
-		return
+// runRemote 通过 daemon 创建并启动容器。-it 时标准输入输出经由 daemon 接管的连接传给容器，
+// 等待容器退出并以容器的退出码退出
+func runRemote(opts runtime.CreateOptions) error {
+	errFormat := "runRemote: %w"
+	id, err := apiClient.ContainerCreate(api.ContainerCreateRequest{
+		Name:        opts.Name,
+		Image:       opts.Image,
+		Cmd:         opts.Cmd,
+		Env:         opts.Env,
+		Volume:      opts.Volume,
+		Network:     opts.Network,
+		PortMapping: opts.PortMapping,
+		Memory:      opts.Resources.MemoryLimit,
+		Cpus:        opts.Resources.Cpus,
+		CpuSet:      opts.Resources.CpuSet,
+		Tty:         opts.TTY,
+	})
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
 	}
-
-	// tty模式
-	if c.TTY {
-		if err := parent.Wait(); err != nil {
-			log.Printf("[error] run parent.Wait: %s", err)
+	if opts.TTY {
+		if err := apiClient.ContainerStartAttach(id, os.Stdin, os.Stdout); err != nil {
+			// -it 的容器只能通过这个连接交互，启动或连接失败时和后台运行一样删除容器
+			if rmErr := apiClient.ContainerRemove(id, true); rmErr != nil {
+				logrus.Errorln("runRemote:", rmErr)
+			}
+			return fmt.Errorf(errFormat, err)
 		}
-		log.Println("[debug] release resource")
-		if err := cgroupManager.Destroy(); err != nil {
-			log.Println("[error] run:", err)
+		c, err := apiClient.ContainerInspect(id)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
 		}
-		log.Println("[debug] clear work dir")
-		container.DelWorkspace(c)
-		c.Pid = 0
-		c.Status = consts.STATUS_EXITED
-		if err := recordContainerInfo(c); err != nil {
-			log.Printf("[error] run: %s", err)
+		if c.ExitCode != 0 {
+			return &errdefs.ExitError{Code: c.ExitCode}
 		}
-		return
+		return nil
 	}
-	log.Println("[debug] run as a daemon")
-}
-
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Printf("[debug] command: %s\n", command)
-	if _, err := writePipe.WriteString(command); err != nil {
-		log.Printf("[error] writePipe.WriteString: %s\n", err)
+	if err := apiClient.ContainerStart(id); err != nil {
+		if rmErr := apiClient.ContainerRemove(id, true); rmErr != nil {
+			logrus.Errorln("runRemote:", rmErr)
+		}
+		return fmt.Errorf(errFormat, err)
 	}
-	writePipe.Close()
+	fmt.Println(id)
+	return nil
 }
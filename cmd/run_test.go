@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/cgroups/subsystems"
+	"github.com/wlbyte/mydocker/client"
+	"github.com/wlbyte/mydocker/runtime"
+)
+
+func TestRunRemoteStartFailure(t *testing.T) {
+	var removed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /containers/create":
+			json.NewEncoder(w).Encode(api.ContainerCreateResponse{Id: "c1"})
+		case "POST /containers/c1/start":
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(api.ErrorResponse{Message: "start failed"})
+		case "DELETE /containers/c1":
+			removed = append(removed, r.URL.Query().Get("force"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c, err := client.New("tcp://"+srv.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetClient(c)
+	defer SetClient(nil)
+
+	// 无论是否 -it，启动失败时都不能留下已经创建的容器
+	for _, tty := range []bool{true, false} {
+		removed = nil
+		err := runRemote(runtime.CreateOptions{Image: "test", Cmd: []string{"sh"}, TTY: tty, Resources: &subsystems.ResourceConfig{}})
+		if err == nil || !strings.Contains(err.Error(), "start failed") {
+			t.Errorf("runRemote(tty=%v) = %v, want the start error", tty, err)
+		}
+		if len(removed) != 1 || removed[0] != "true" {
+			t.Errorf("runRemote(tty=%v) removed the container %d times (force %v), want once with force", tty, len(removed), removed)
+		}
+	}
+}
@@ -1,54 +1,42 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"github.com/wlbyte/mydocker/consts"
-	"github.com/wlbyte/mydocker/network"
-	"golang.org/x/sys/unix"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
 )
 
 var StopCommand = cli.Command{
 	Name:  "stop",
 	Usage: "stop container",
-	Action: func(ctx *cli.Context) error {
-		log.Println("[debug] stop container")
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait before killing the container, eg: stop -t 5 ID",
+			Value: 10,
+		},
+	},
+	Action: audited(containerTarget, func(ctx *cli.Context) error {
+		logrus.Debugln("stop container")
 		errFormat := "stopCommand: %w"
 		if len(ctx.Args()) < 1 {
-			return fmt.Errorf(errFormat, errors.New("too few args"))
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: too few args", errdefs.ErrInvalidArgument))
 		}
 		containerID := ctx.Args().Get(0)
-		if err := stopContainer(containerID); err != nil {
-			return fmt.Errorf(errFormat, err)
+		opts := runtime.StopOptions{Timeout: time.Duration(ctx.Int("t")) * time.Second}
+		if apiClient != nil {
+			if err := apiClient.ContainerStop(containerID, opts.Timeout); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
 		}
-		return nil
-	},
-}
-
-func stopContainer(containerID string) error {
-	errFormat := "stopContainer: %w"
-	c := GetContainerInfo(containerID)
-	if c == nil {
-		return fmt.Errorf(errFormat, errors.New("conainter is not exist"))
-	}
-	e := GetEndpointInfo(c.Id)
-	if err := network.DelConnect(c, e); err != nil {
-		return fmt.Errorf(errFormat, err)
-	}
-	if err := unix.Kill(c.Pid, unix.SIGTERM); err != nil {
-		if !strings.Contains(err.Error(), "no such process") {
+		if err := rt.Stop(containerID, opts); err != nil {
 			return fmt.Errorf(errFormat, err)
 		}
-	}
-	c.Pid = 0
-	c.Status = consts.STATUS_STOPPED
-	if err := recordContainerInfo(c); err != nil {
-		return fmt.Errorf(errFormat, err)
-	}
-
-	return nil
+		return nil
+	}),
 }
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+func init() {
+	SystemCommand.Subcommands = []cli.Command{
+		SystemReconcileCommand,
+	}
+}
+
+var SystemCommand = cli.Command{
+	Name:  "system",
+	Usage: "system management",
+}
+
+var SystemReconcileCommand = cli.Command{
+	Name:  "reconcile",
+	Usage: "repair stale container, endpoint, ip, veth, iptables and mount state",
+	Action: audited(noTarget, func(context *cli.Context) error {
+		if apiClient != nil {
+			if err := apiClient.SystemReconcile(); err != nil {
+				return fmt.Errorf("system.Reconcile: %w", err)
+			}
+			return nil
+		}
+		if err := rt.Reconcile(true); err != nil {
+			return fmt.Errorf("system.Reconcile: %w", err)
+		}
+		return nil
+	}),
+}
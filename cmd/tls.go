@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/api"
+)
+
+func init() {
+	TLSCommand.Subcommands = []cli.Command{
+		TLSGenerateCommand,
+	}
+}
+
+var TLSCommand = cli.Command{
+	Name:  "tls",
+	Usage: "tls certificate management",
+}
+
+// mydocker tls generate --dir ~/.mydocker --host 127.0.0.1 --host localhost
+var TLSGenerateCommand = cli.Command{
+	Name:  "generate",
+	Usage: "generate a local CA with daemon and client certificates for testing",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "dir",
+			Usage: "output directory, default $" + api.ENV_CERT_PATH + " or ~/.mydocker",
+		},
+		cli.StringSliceFlag{
+			Name:  "host",
+			Usage: "IP or DNS name of the daemon, eg: --host 192.168.1.10, default 127.0.0.1 and localhost",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		errFormat := "tls.Generate: %w"
+		dir := context.String("dir")
+		if dir == "" {
+			dir = api.DefaultCertPath()
+		}
+		hosts := context.StringSlice("host")
+		if len(hosts) == 0 {
+			hosts = []string{"127.0.0.1", "localhost"}
+		}
+		if err := api.GenerateCerts(dir, hosts); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		fmt.Println(dir)
+		return nil
+	},
+}
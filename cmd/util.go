@@ -1,115 +1,43 @@
 package cmd
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"os"
-	"path/filepath"
-	"strings"
-
-	"github.com/wlbyte/mydocker/consts"
-	"github.com/wlbyte/mydocker/container"
-	"github.com/wlbyte/mydocker/network"
+	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/client"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
+	"github.com/wlbyte/mydocker/webhook"
 )
 
-func recordContainerInfo(ci *container.Container) error {
-	errFormat := "recordContainerInfo: %w"
-	curPath := consts.PATH_CONTAINER + "/" + ci.Id
-	container.MkDir(curPath)
-	bs, err := json.Marshal(ci)
-	if err != nil {
-		return fmt.Errorf(errFormat, err)
-	}
-	if err := os.WriteFile(curPath+"/config.json", bs, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, err)
-	}
-	return nil
-}
+// rt 命令行使用的 Runtime，在 main 中根据全局参数创建
+var rt *runtime.Runtime
 
-func GetContainerInfoAll(searchDir string) []*container.Container {
-	fs := findJsonFilePathAll(searchDir)
-	return getContainerInfoAll(fs)
-}
-
-func getContainerInfoAll(fs []string) []*container.Container {
-	var cis []*container.Container
+// apiClient 指定了 -H/--host 时通过 daemon 的 API 执行命令，此时 rt 为 nil
+var apiClient *client.Client
 
-	for _, f := range fs {
-		c := getContainerInfo(f)
-		if c == nil {
-			continue
-		}
-		cis = append(cis, c)
-	}
-	return cis
-}
+// webhooks 配置了 webhook 时不为 nil
+var webhooks *webhook.Dispatcher
 
-func findJsonFilePathAll(dir string) []string {
-	var filePaths []string
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Println("[error] filepath.Walk:", path, err)
-			return err
-		}
-		if !info.IsDir() && filepath.Ext(path) == ".json" {
-			filePaths = append(filePaths, path)
-		}
-		return nil
-	})
-	return filePaths
-}
+var errRemoteNotSupported = errdefs.New(errdefs.ErrInvalidArgument, "not supported with -H/--host")
 
-func GetContainerInfo(containerID string) *container.Container {
-	f := findJsonFilePath(containerID, consts.PATH_CONTAINER)
-	return getContainerInfo(f)
+func SetRuntime(r *runtime.Runtime) {
+	rt = r
 }
 
-func getContainerInfo(f string) *container.Container {
-	var c *container.Container
-	bs, err := os.ReadFile(f)
-	if err != nil && err != io.EOF {
-		log.Println("[info] getContainerInfo:", err)
-		return nil
-	}
-	if err := json.Unmarshal(bs, &c); err != nil {
-		log.Println("[info] getContainerInfo:", err)
-		return nil
-	}
-	return c
+func SetClient(c *client.Client) {
+	apiClient = c
 }
 
-func GetEndpointInfo(endpointID string) *network.Endpoint {
-	f := findJsonFilePath(endpointID, consts.PATH_NETWORK_ENDPOINT)
-	return getEndpointInfo(f)
+func SetWebhooks(d *webhook.Dispatcher) {
+	webhooks = d
 }
 
-func getEndpointInfo(f string) *network.Endpoint {
-	var e *network.Endpoint
-	bs, err := os.ReadFile(f)
-	if err != nil && err != io.EOF {
-		log.Println("[info] getContainerInfo:", err)
-		return nil
+// TLSOptions 读取全局的 --tlscacert/--tlscert/--tlskey/--tlsverify，daemon 和客户端共用
+func TLSOptions(context *cli.Context) api.TLSOptions {
+	return api.TLSOptions{
+		CAFile:   context.GlobalString("tlscacert"),
+		CertFile: context.GlobalString("tlscert"),
+		KeyFile:  context.GlobalString("tlskey"),
+		Verify:   context.GlobalBool("tlsverify"),
 	}
-	if err := json.Unmarshal(bs, &e); err != nil {
-		log.Println("[info] getContainerInfo:", err)
-		return nil
-	}
-	return e
-}
-
-func findJsonFilePath(subFilePath, searchDir string) string {
-	var ret string
-	filepath.Walk(searchDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Println("[error] findJsonFilePath:", path, err)
-			return err
-		}
-		if info.Mode().IsRegular() && strings.Contains(path, subFilePath) && filepath.Ext(path) == ".json" {
-			ret = path
-		}
-		return nil
-	})
-	return ret
 }
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// 普通命令退出前顺便投递本次命令产生的事件，最多等待 webhookFlushTimeout，
+// 没投递完的留在 outbox 中由下次命令、daemon 或 webhook deliver 继续投递
+const (
+	webhookFlushTimeout = 3 * time.Second
+	webhookInterval     = time.Second
+)
+
+func init() {
+	WebhookCommand.Subcommands = []cli.Command{
+		WebhookDeliverCommand,
+	}
+}
+
+var WebhookCommand = cli.Command{
+	Name:  "webhook",
+	Usage: "webhook notification management",
+}
+
+var WebhookDeliverCommand = cli.Command{
+	Name:  "deliver",
+	Usage: "deliver pending webhook notifications from the outbox",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow",
+			Usage: "keep delivering new events until interrupted, for hosts without the daemon",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		errFormat := "webhook.Deliver: %w"
+		if apiClient != nil {
+			return fmt.Errorf(errFormat, errRemoteNotSupported)
+		}
+		if webhooks == nil {
+			return fmt.Errorf(errFormat, errors.New("no webhooks configured"))
+		}
+		if !ctx.Bool("follow") {
+			if err := webhooks.RunOnce(context.Background()); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			return nil
+		}
+		done := make(chan struct{})
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, unix.SIGINT, unix.SIGTERM)
+		go func() {
+			<-sigCh
+			close(done)
+		}()
+		webhooks.Run(done, webhookInterval)
+		return nil
+	},
+}
+
+// FlushWebhooks 在命令结束时投递到期的 webhook 通知
+func FlushWebhooks() {
+	if webhooks == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookFlushTimeout)
+	defer cancel()
+	if err := webhooks.RunOnce(ctx); err != nil {
+		logrus.Warnln("webhook:", err)
+	}
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/wlbyte/mydocker/events"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DEFAULT_CONFIG_PATH = "/etc/mydocker/config.json"
+	ENV_CONFIG          = "MYDOCKER_CONFIG"
+)
+
+// 支持的日志驱动和存储驱动
+const (
+	LOG_DRIVER_LOCAL        = "local"
+	LOG_DRIVER_NONE         = "none"
+	STORAGE_DRIVER_OVERLAY2 = "overlay2"
+)
+
+// Config 对应 /etc/mydocker/config.json，未配置的字段使用 Default 中的默认值
+type Config struct {
+	Root                string            `json:"root"`
+	ExecRoot            string            `json:"execRoot"`
+	StorageDriver       string            `json:"storageDriver"`
+	DefaultNetwork      NetworkConfig     `json:"defaultNetwork"`
+	DefaultAddressPools []AddressPool     `json:"defaultAddressPools"`
+	DefaultUlimits      map[string]Ulimit `json:"defaultUlimits"`
+	LogDriver           string            `json:"logDriver"`
+	LogOpts             map[string]string `json:"logOpts"`
+	CgroupParent        string            `json:"cgroupParent"`
+	Webhooks            []Webhook         `json:"webhooks"`
+	// InsecureRegistries 使用 http 访问的镜像仓库地址 host[:port]，本机地址总是使用 http
+	InsecureRegistries []string `json:"insecureRegistries"`
+}
+
+// NetworkConfig 容器未指定 -net 时使用的默认网络
+type NetworkConfig struct {
+	Name   string `json:"name"`
+	Subnet string `json:"subnet"`
+}
+
+// AddressPool network create 未指定 --subnet 时，从 Base 中按 Size 长度的掩码切分子网
+type AddressPool struct {
+	Base string `json:"base"`
+	Size int    `json:"size"`
+}
+
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// Webhook 满足 Filters 的事件以 JSON POST 到 URL，Secret 不为空时用它对请求体做 HMAC-SHA256 签名。
+// Filters 的格式与 mydocker events --filter 相同，投递失败时按指数退避重试，最多 MaxAttempts 次
+type Webhook struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Filters     []string `json:"filters"`
+	MaxAttempts int      `json:"maxAttempts"`
+}
+
+// DEFAULT_WEBHOOK_MAX_ATTEMPTS Webhook.MaxAttempts 为 0 时的重试次数
+const DEFAULT_WEBHOOK_MAX_ATTEMPTS = 10
+
+var ulimitResources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// 各日志驱动支持的选项
+var logDriverOpts = map[string][]string{
+	LOG_DRIVER_LOCAL: {"mode"},
+	LOG_DRIVER_NONE:  {},
+}
+
+var current = Default()
+
+func Default() *Config {
+	return &Config{
+		StorageDriver: STORAGE_DRIVER_OVERLAY2,
+		DefaultNetwork: NetworkConfig{
+			Name:   "mydocker0",
+			Subnet: "172.18.0.0/24",
+		},
+		DefaultAddressPools: []AddressPool{
+			{Base: "172.19.0.0/16", Size: 24},
+		},
+		DefaultUlimits: map[string]Ulimit{},
+		LogDriver:      LOG_DRIVER_LOCAL,
+		LogOpts:        map[string]string{},
+		CgroupParent:   "mydocker-cgroup",
+	}
+}
+
+// Get 返回当前生效的配置
+func Get() *Config {
+	return current
+}
+
+func Set(c *Config) {
+	current = c
+}
+
+// Load 读取配置文件并与默认值合并后校验。path 为空时读取默认路径，默认路径不存在时直接使用默认配置
+func Load(path string) (*Config, error) {
+	errFormat := "config.Load %s: %w"
+	optional := false
+	if path == "" {
+		path = DEFAULT_CONFIG_PATH
+		optional = true
+	}
+	c := Default()
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	return c, nil
+}
+
+// Validate 检查配置是否合法，错误信息中带上出错的字段名
+func (c *Config) Validate() error {
+	if c.Root != "" && !filepath.IsAbs(c.Root) {
+		return fmt.Errorf("root: %q must be an absolute path", c.Root)
+	}
+	if c.ExecRoot != "" && !filepath.IsAbs(c.ExecRoot) {
+		return fmt.Errorf("execRoot: %q must be an absolute path", c.ExecRoot)
+	}
+	if c.StorageDriver != STORAGE_DRIVER_OVERLAY2 {
+		return fmt.Errorf("storageDriver: unsupported driver %q, supported: %s", c.StorageDriver, STORAGE_DRIVER_OVERLAY2)
+	}
+	if c.DefaultNetwork.Name == "" {
+		return errors.New("defaultNetwork.name: must not be empty")
+	}
+	if _, _, err := net.ParseCIDR(c.DefaultNetwork.Subnet); err != nil {
+		return fmt.Errorf("defaultNetwork.subnet: %w", err)
+	}
+	for i, p := range c.DefaultAddressPools {
+		_, base, err := net.ParseCIDR(p.Base)
+		if err != nil {
+			return fmt.Errorf("defaultAddressPools[%d].base: %w", i, err)
+		}
+		if base.IP.To4() == nil {
+			return fmt.Errorf("defaultAddressPools[%d].base: only ipv4 is supported", i)
+		}
+		ones, _ := base.Mask.Size()
+		if p.Size < ones || p.Size > 30 {
+			return fmt.Errorf("defaultAddressPools[%d].size: %d must be between %d and 30", i, p.Size, ones)
+		}
+	}
+	for name, u := range c.DefaultUlimits {
+		if _, ok := ulimitResources[name]; !ok {
+			return fmt.Errorf("defaultUlimits: unknown ulimit %q", name)
+		}
+		if u.Name != "" && u.Name != name {
+			return fmt.Errorf("defaultUlimits.%s: name %q does not match key", name, u.Name)
+		}
+		if u.Soft > u.Hard {
+			return fmt.Errorf("defaultUlimits.%s: soft limit %d is greater than hard limit %d", name, u.Soft, u.Hard)
+		}
+	}
+	opts, ok := logDriverOpts[c.LogDriver]
+	if !ok {
+		return fmt.Errorf("logDriver: unsupported driver %q, supported: %s, %s", c.LogDriver, LOG_DRIVER_LOCAL, LOG_DRIVER_NONE)
+	}
+	for k, v := range c.LogOpts {
+		if !contains(opts, k) {
+			return fmt.Errorf("logOpts: option %q is not supported by log driver %q", k, c.LogDriver)
+		}
+		if k == "mode" {
+			if _, err := strconv.ParseUint(v, 8, 32); err != nil {
+				return fmt.Errorf("logOpts.mode: %q is not an octal file mode", v)
+			}
+		}
+	}
+	if c.CgroupParent == "" || filepath.IsAbs(c.CgroupParent) || strings.Contains(c.CgroupParent, "..") {
+		return fmt.Errorf("cgroupParent: %q must be a non-empty relative path", c.CgroupParent)
+	}
+	seen := map[string]bool{}
+	for i, w := range c.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d].url: %q must be an http or https url", i, w.URL)
+		}
+		// 投递记录按 URL 找到对应的配置
+		if seen[w.URL] {
+			return fmt.Errorf("webhooks[%d].url: duplicate url %q", i, w.URL)
+		}
+		seen[w.URL] = true
+		if _, err := events.ParseFilters(w.Filters); err != nil {
+			return fmt.Errorf("webhooks[%d].filters: %w", i, err)
+		}
+		if w.MaxAttempts < 0 {
+			return fmt.Errorf("webhooks[%d].maxAttempts: %d must not be negative", i, w.MaxAttempts)
+		}
+	}
+	for i, r := range c.InsecureRegistries {
+		if r == "" || strings.Contains(r, "/") {
+			return fmt.Errorf("insecureRegistries[%d]: %q must be host[:port]", i, r)
+		}
+	}
+	return nil
+}
+
+// LogFileMode 返回 local 日志驱动创建日志文件的权限
+func (c *Config) LogFileMode() os.FileMode {
+	if v, ok := c.LogOpts["mode"]; ok {
+		if m, err := strconv.ParseUint(v, 8, 32); err == nil {
+			return os.FileMode(m)
+		}
+	}
+	return 0644
+}
+
+// ApplyUlimits 对进程 pid 设置默认 ulimit
+func (c *Config) ApplyUlimits(pid int) error {
+	errFormat := "applyUlimits %s: %w"
+	for name, u := range c.DefaultUlimits {
+		rlimit := &unix.Rlimit{Cur: u.Soft, Max: u.Hard}
+		if err := unix.Prlimit(pid, ulimitResources[name], rlimit, nil); err != nil {
+			return fmt.Errorf(errFormat, name, err)
+		}
+	}
+	return nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{
+			name:    "empty object uses defaults",
+			content: `{}`,
+		},
+		{
+			name:    "override defaults",
+			content: `{"defaultNetwork":{"name":"br0","subnet":"10.10.0.0/24"},"defaultUlimits":{"nofile":{"soft":1024,"hard":2048}},"logDriver":"none"}`,
+		},
+		{
+			name:    "unknown field",
+			content: `{"logDriverr":"none"}`,
+			errMsg:  "unknown field",
+		},
+		{
+			name:    "invalid subnet",
+			content: `{"defaultNetwork":{"name":"br0","subnet":"10.10.0.0"}}`,
+			errMsg:  "defaultNetwork.subnet",
+		},
+		{
+			name:    "pool size smaller than base",
+			content: `{"defaultAddressPools":[{"base":"10.0.0.0/16","size":8}]}`,
+			errMsg:  "defaultAddressPools[0].size",
+		},
+		{
+			name:    "unknown ulimit",
+			content: `{"defaultUlimits":{"files":{"soft":1,"hard":1}}}`,
+			errMsg:  `unknown ulimit "files"`,
+		},
+		{
+			name:    "soft greater than hard",
+			content: `{"defaultUlimits":{"nofile":{"soft":2,"hard":1}}}`,
+			errMsg:  "defaultUlimits.nofile",
+		},
+		{
+			name:    "unsupported log option",
+			content: `{"logDriver":"none","logOpts":{"mode":"0644"}}`,
+			errMsg:  `option "mode" is not supported`,
+		},
+		{
+			name:    "unsupported storage driver",
+			content: `{"storageDriver":"btrfs"}`,
+			errMsg:  "storageDriver",
+		},
+		{
+			name:    "absolute cgroup parent",
+			content: `{"cgroupParent":"/mydocker"}`,
+			errMsg:  "cgroupParent",
+		},
+		{
+			name:    "insecure registry with scheme",
+			content: `{"insecureRegistries":["http://registry.local:5000"]}`,
+			errMsg:  "insecureRegistries[0]",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, tt.name+".json")
+			if err := os.WriteFile(p, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			_, err := Load(p)
+			if tt.errMsg == "" && err != nil {
+				t.Errorf("case %d: Load() error = %v, want nil", i, err)
+			}
+			if tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)) {
+				t.Errorf("case %d: Load() error = %v, want containing %q", i, err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Load() of explicit missing file should fail")
+	}
+}
@@ -2,60 +2,172 @@ package consts
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 )
 
-const PATH_HOME = "/var/lib/mydocker"
+// 数据目录和运行时目录默认值，可以通过全局参数 --root/--exec-root 或环境变量修改
+const (
+	DEFAULT_ROOT      = "/var/lib/mydocker"
+	DEFAULT_EXEC_ROOT = "/var/run/mydocker"
+	ENV_ROOT          = "MYDOCKER_ROOT"
+	ENV_EXEC_ROOT     = "MYDOCKER_EXEC_ROOT"
+)
 
 const (
 	MODE_0755 = 0755
 )
 
+// 以下路径都由 SetRoot 根据数据目录和运行时目录计算，命令执行前由 runtime.New 设置
+var (
+	PATH_HOME      string
+	PATH_EXEC_ROOT string
+)
+
 // container
 const (
-	STATUS_RUNNING     = "running"
-	STATUS_STOPPED     = "stopped"
-	STATUS_EXITED      = "exited"
-	PATH_CONTAINER     = PATH_HOME + "/containers"
-	PATH_FS_ROOT       = PATH_HOME + "/overlay2"
-	PATH_LOWER_FORMAT  = PATH_FS_ROOT + "/%s/lower"
-	PATH_UPPER_FORMAT  = PATH_FS_ROOT + "/%s/upper"
-	PATH_MERGED_FORMAT = PATH_FS_ROOT + "/%s/merged"
-	PATH_WORK_FORMAT   = PATH_FS_ROOT + "/%s/work"
-	MOUNT_PATH_FORMAT  = "lowerdir=%s,upperdir=%s,workdir=%s"
+	STATUS_CREATED    = "created"
+	STATUS_RUNNING    = "running"
+	STATUS_STOPPED    = "stopped"
+	STATUS_EXITED     = "exited"
+	STATUS_PAUSED     = "paused"
+	MOUNT_PATH_FORMAT = "lowerdir=%s,upperdir=%s,workdir=%s"
+	// overlay 的挂载参数不能超过一页，lowerdir 使用相对 PATH_LAYERS 的路径以容纳更多的层
+	MAX_MOUNT_OPTIONS = 4096
 )
 
-func GetPathLower(containerID string) string {
-	return fmt.Sprintf(PATH_LOWER_FORMAT, containerID)
-}
+var (
+	PATH_CONTAINER      string
+	PATH_CONTAINER_LOCK string
+	PATH_FS_ROOT        string
+)
 
 func GetPathUpper(containerID string) string {
-	return fmt.Sprintf(PATH_UPPER_FORMAT, containerID)
+	return filepath.Join(PATH_FS_ROOT, containerID, "upper")
 }
 
 func GetPathWork(containerID string) string {
-	return fmt.Sprintf(PATH_WORK_FORMAT, containerID)
+	return filepath.Join(PATH_FS_ROOT, containerID, "work")
 }
 
 func GetPathMerged(containerID string) string {
-	return fmt.Sprintf(PATH_MERGED_FORMAT, containerID)
+	return filepath.Join(PATH_FS_ROOT, containerID, "merged")
 }
 
-func GetMountSrcDir(containerID string) string {
-	return fmt.Sprintf(MOUNT_PATH_FORMAT, GetPathLower(containerID), GetPathUpper(containerID), GetPathWork(containerID))
+// GetMountSrcDir 返回 overlay 的挂载参数，layers 为镜像层的摘要，顺序从最底层到最上层。
+// lowerdir 中越靠前的层越在上面，因此需要倒序拼接，挂载时工作目录需要是 PATH_LAYERS
+func GetMountSrcDir(containerID string, layers []string) string {
+	lowers := make([]string, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		rel, _ := filepath.Rel(PATH_LAYERS, GetPathLayer(layers[i]))
+		lowers = append(lowers, rel)
+	}
+	return fmt.Sprintf(MOUNT_PATH_FORMAT, strings.Join(lowers, ":"), GetPathUpper(containerID), GetPathWork(containerID))
 }
 
+// events
+var (
+	PATH_EVENTS       string
+	PATH_WEBHOOK      string
+	PATH_WEBHOOK_LOCK string
+)
+
+// audit
+var (
+	PATH_AUDIT      string
+	PATH_AUDIT_LOCK string
+)
+
+// metrics
+var (
+	PATH_METRICS      string
+	PATH_METRICS_LOCK string
+)
+
 // image
-const (
-	PATH_IMAGE = PATH_HOME + "/image"
+var (
+	PATH_IMAGE       string
+	PATH_BLOBS       string
+	PATH_IMAGE_INDEX string
+	PATH_LAYERS      string
+	PATH_IMAGE_LOCK  string
+	// 持有共享锁期间 rmi 不回收 blob，用于正在写入还没有被镜像引用的 blob
+	PATH_IMAGE_LEASE_LOCK string
+	PATH_BUILD_CACHE      string
+	PATH_BUILD_CACHE_LOCK string
+	// 镜像仓库的登录凭据
+	PATH_REGISTRY_AUTH string
 )
 
+// GetPathBlob 返回摘要为 digest（sha256:<hex>）的 blob 的路径
+func GetPathBlob(digest string) string {
+	return filepath.Join(PATH_BLOBS, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// GetPathLayer 返回摘要为 digest（sha256:<hex>）的层解压后的只读目录
+func GetPathLayer(digest string) string {
+	return filepath.Join(PATH_LAYERS, strings.TrimPrefix(digest, "sha256:"), "diff")
+}
+
 // network
 const (
-	DEFAULT_NETWORK       = "default"
-	DEFAULT_DRIVER        = "bridge"
-	PATH_NETWORK          = PATH_HOME + "/network"
-	PATH_IPAM             = PATH_NETWORK + "/ipam"
-	PATH_NETWORK_NETWORK  = PATH_NETWORK + "/network"
-	PATH_NETWORK_ENDPOINT = PATH_NETWORK + "/endpoint"
-	PATH_IPAM_JSON        = PATH_IPAM + "/subnet.json"
+	DEFAULT_NETWORK = "default"
+	DEFAULT_DRIVER  = "bridge"
 )
+
+var (
+	PATH_NETWORK          string
+	PATH_IPAM             string
+	PATH_NETWORK_NETWORK  string
+	PATH_NETWORK_ENDPOINT string
+	PATH_IPAM_JSON        string
+	PATH_IPAM_LOCK        string
+	PATH_NETWORK_LOCK     string
+)
+
+func init() {
+	SetRoot(DEFAULT_ROOT, DEFAULT_EXEC_ROOT)
+}
+
+// SetRoot 设置数据目录 root（容器、镜像、overlay、网络等持久化状态）和运行时目录 execRoot（锁文件等），
+// 并重新计算所有派生路径。空字符串表示使用默认值。
+// 路径变量的读取不加锁，SetRoot 不能和使用这些路径的代码并发调用
+func SetRoot(root, execRoot string) {
+	if root == "" {
+		root = DEFAULT_ROOT
+	}
+	if execRoot == "" {
+		execRoot = DEFAULT_EXEC_ROOT
+	}
+	PATH_HOME = root
+	PATH_EXEC_ROOT = execRoot
+
+	PATH_CONTAINER = filepath.Join(PATH_HOME, "containers")
+	PATH_FS_ROOT = filepath.Join(PATH_HOME, "overlay2")
+	PATH_IMAGE = filepath.Join(PATH_HOME, "image")
+	PATH_BLOBS = filepath.Join(PATH_IMAGE, "blobs", "sha256")
+	PATH_IMAGE_INDEX = filepath.Join(PATH_IMAGE, "index.json")
+	PATH_LAYERS = filepath.Join(PATH_IMAGE, "layers")
+	PATH_BUILD_CACHE = filepath.Join(PATH_IMAGE, "build-cache.json")
+	PATH_REGISTRY_AUTH = filepath.Join(PATH_HOME, "registry-auth.json")
+	PATH_EVENTS = filepath.Join(PATH_HOME, "events.log")
+	PATH_WEBHOOK = filepath.Join(PATH_HOME, "webhook")
+	PATH_AUDIT = filepath.Join(PATH_HOME, "audit.log")
+
+	PATH_NETWORK = filepath.Join(PATH_HOME, "network")
+	PATH_IPAM = filepath.Join(PATH_NETWORK, "ipam")
+	PATH_NETWORK_NETWORK = filepath.Join(PATH_NETWORK, "network")
+	PATH_NETWORK_ENDPOINT = filepath.Join(PATH_NETWORK, "endpoint")
+	PATH_IPAM_JSON = filepath.Join(PATH_IPAM, "subnet.json")
+
+	PATH_CONTAINER_LOCK = filepath.Join(PATH_EXEC_ROOT, "containers.lock")
+	PATH_NETWORK_LOCK = filepath.Join(PATH_EXEC_ROOT, "network.lock")
+	PATH_IPAM_LOCK = filepath.Join(PATH_EXEC_ROOT, "ipam.lock")
+	PATH_WEBHOOK_LOCK = filepath.Join(PATH_EXEC_ROOT, "webhook.lock")
+	PATH_IMAGE_LOCK = filepath.Join(PATH_EXEC_ROOT, "image.lock")
+	PATH_IMAGE_LEASE_LOCK = filepath.Join(PATH_EXEC_ROOT, "image-lease.lock")
+	PATH_BUILD_CACHE_LOCK = filepath.Join(PATH_EXEC_ROOT, "build-cache.lock")
+	PATH_AUDIT_LOCK = filepath.Join(PATH_EXEC_ROOT, "audit.lock")
+	PATH_METRICS = filepath.Join(PATH_EXEC_ROOT, "metrics.json")
+	PATH_METRICS_LOCK = filepath.Join(PATH_EXEC_ROOT, "metrics.lock")
+}
@@ -3,24 +3,29 @@ package container
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/wlbyte/mydocker/cgroups/subsystems"
+	"github.com/wlbyte/mydocker/config"
 	"github.com/wlbyte/mydocker/consts"
-	"github.com/wlbyte/mydocker/utils"
+	"github.com/wlbyte/mydocker/errdefs"
 	"golang.org/x/sys/unix"
 )
 
-var ErrContainerNotExist = errors.New("container not exist")
+var ErrContainerNotExist = errdefs.New(errdefs.ErrNotFound, "container not exist")
 
 type Container struct {
-	Id             string                     `json:"id"`
-	Name           string                     `json:"name"`
-	ImageName      string                     `json:"imageName"`
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	ImageName string `json:"imageName"`
+	// ImageID 创建容器时镜像配置的摘要，镜像的引用之后可能指向别的镜像
+	ImageID string `json:"imageID,omitempty"`
+	// Layers 镜像的只读层摘要，从最底层到最上层，由多个容器共享
+	Layers         []string                   `json:"layers,omitempty"`
 	Pid            int                        `json:"pid"`
 	Cmds           []string                   `json:"cmds"`
 	Status         string                     `json:"status"`
@@ -28,18 +33,19 @@ type Container struct {
 	Detach         bool                       `json:"detach"`
 	Volume         string                     `json:"volume"`
 	Environment    []string                   `json:"environment"`
+	WorkingDir     string                     `json:"workingDir,omitempty"`
+	User           string                     `json:"user,omitempty"`
 	ResourceConfig *subsystems.ResourceConfig `json:"resourceConfig"`
+	CgroupPath     string                     `json:"cgroupPath"`
 	Network        string                     `json:"network"`
 	PortMapping    []string                   `json:"portMapping"`
 	CreateAt       string                     `json:"createAt"`
+	// ExitCode 由启动容器的进程回收时记录的退出码
+	ExitCode int `json:"exitCode"`
 }
 
 func NewParentProcess(c *Container) (*exec.Cmd, *os.File, error) {
 	errFormat := "newPararentProcess: %w"
-	// 创建目录和镜像环境
-	if err := NewWorkspace(c); err != nil {
-		return nil, nil, fmt.Errorf(errFormat, err)
-	}
 	// 创建匿名管道用于传递参数，将readPipe作为子进程的ExtraFiles，子进程从readPipe中读取参数
 	// 父进程中则通过writePipe将参数写入管道
 	readPipe, writePipe, err := os.Pipe()
@@ -64,19 +70,20 @@ func NewParentProcess(c *Container) (*exec.Cmd, *os.File, error) {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-	} else {
+	} else if cfg := config.Get(); cfg.LogDriver == config.LOG_DRIVER_LOCAL {
 		logPath := fmt.Sprintf("%s/%s", consts.PATH_CONTAINER, c.Id)
 		if err := MkDir(logPath); err != nil {
 			return nil, nil, fmt.Errorf(errFormat, err)
 		}
 		logFile := fmt.Sprintf("%s/%s/%s.log", consts.PATH_CONTAINER, c.Id, c.Id)
-		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_RDWR, consts.MODE_0755)
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_RDWR, cfg.LogFileMode())
 		if err != nil {
 			return nil, nil, fmt.Errorf(errFormat, err)
 		}
 		cmd.Stdout = f
 		cmd.Stderr = f
 	}
+	// 日志驱动为 none 时 Stdout/Stderr 为 nil，输出被丢弃
 
 	cmd.ExtraFiles = []*os.File{readPipe}
 	cmd.Dir = consts.GetPathMerged(c.Id)
@@ -86,13 +93,9 @@ func NewParentProcess(c *Container) (*exec.Cmd, *os.File, error) {
 func NewWorkspace(c *Container) error {
 	errFormat := "initContainerDir %s: %w"
 	// 创建当前容器目录
-	lower := consts.GetPathLower(c.Id)
 	upper := consts.GetPathUpper(c.Id)
 	merged := consts.GetPathMerged(c.Id)
 	work := consts.GetPathWork(c.Id)
-	if err := os.MkdirAll(lower, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, lower, err)
-	}
 	if err := os.MkdirAll(upper, consts.MODE_0755); err != nil {
 		return fmt.Errorf(errFormat, upper, err)
 	}
@@ -102,35 +105,28 @@ func NewWorkspace(c *Container) error {
 	if err := os.MkdirAll(work, consts.MODE_0755); err != nil {
 		return fmt.Errorf(errFormat, work, err)
 	}
-	//初始化rootfs
-	if err := initRootFS(c.Id, c.ImageName); err != nil {
-		return fmt.Errorf(errFormat, "", err)
-	}
-	if err := mountPath(c.Id, c.Volume); err != nil {
+	// 镜像层以只读方式共享，不再为每个容器解压一份 rootfs
+	if err := mountPath(c.Id, c.Layers, c.Volume); err != nil {
 		return fmt.Errorf(errFormat, "", err)
 	}
 
 	return nil
 }
 
-func initRootFS(containerID, imageName string) error {
-	errFormat := "iniRootFS: %w"
-	imagePath := filepath.Join(consts.PATH_IMAGE, imageName+".tar")
-	if utils.PathNotExist(imagePath) {
-		return fmt.Errorf(errFormat, errors.New("image not exist"))
-	}
-	if _, err := exec.Command("tar", "-xvf", imagePath, "-C", consts.GetPathLower(containerID)).CombinedOutput(); err != nil {
-		return fmt.Errorf(errFormat, err)
-	}
-	return nil
-}
-
-func mountPath(containerID, volumePath string) error {
+func mountPath(containerID string, layers []string, volumePath string) error {
 	errFormat := "mountPath %s: %w"
-	// mount -t overlay overlay -o lowerdir=/lower,upperdir=/upper,workdir=/work /merged
+	if len(layers) == 0 {
+		return fmt.Errorf(errFormat, "", fmt.Errorf("%w: image has no layers", errdefs.ErrInvalidArgument))
+	}
+	// cd $PATH_LAYERS && mount -t overlay overlay -o lowerdir=layerN:...:layer1,upperdir=/upper,workdir=/work /merged
 	dstDir := consts.GetPathMerged(containerID)
-	srcDir := consts.GetMountSrcDir(containerID)
-	if output, err := exec.Command("mount", "-t", "overlay", "overlay", "-o", srcDir, dstDir).CombinedOutput(); err != nil {
+	srcDir := consts.GetMountSrcDir(containerID, layers)
+	if len(srcDir) >= consts.MAX_MOUNT_OPTIONS {
+		return fmt.Errorf(errFormat, "", fmt.Errorf("%w: too many image layers (%d)", errdefs.ErrInvalidArgument, len(layers)))
+	}
+	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", srcDir, dstDir)
+	cmd.Dir = consts.PATH_LAYERS
+	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf(errFormat, output, err)
 	}
 	// 挂载-v 指定的 volume
@@ -156,13 +152,13 @@ func mountPath(containerID, volumePath string) error {
 
 func DelWorkspace(c *Container) {
 	if err := umountPath(c.Id, c.Volume); err != nil {
-		log.Println("[error] DelWorkspace:", err)
+		logrus.Errorln("DelWorkspace:", err)
 	}
 	if err := RmDir(filepath.Join(consts.PATH_CONTAINER, c.Id)); err != nil {
-		log.Println("[error] DelWorkspace:", err)
+		logrus.Errorln("DelWorkspace:", err)
 	}
 	if err := RmDir(filepath.Join(consts.PATH_FS_ROOT, c.Id)); err != nil {
-		log.Println("[error] DelWorkspace:", err)
+		logrus.Errorln("DelWorkspace:", err)
 	}
 }
 
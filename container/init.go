@@ -1,20 +1,30 @@
 package container
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
+	"syscall"
 
+	"github.com/wlbyte/mydocker/errdefs"
 	"golang.org/x/sys/unix"
 )
 
 const fdIndex = 3
 
+// InitConfig 父进程通过管道发给容器 init 进程的启动参数，JSON 编码，
+// 参数中可以包含空格
+type InitConfig struct {
+	Args []string `json:"args"`
+	// WorkingDir 容器进程的工作目录，不存在时创建
+	WorkingDir string `json:"workingDir,omitempty"`
+	// User 容器进程的 user[:group]，为空时以 root 运行
+	User string `json:"user,omitempty"`
+}
+
 func RunContainerInitProcess() error {
 	errFormat := "runContainerInitProcess: %w"
 	// 必需先挂载，否者后续在LookPath会提示找不到路径
@@ -22,30 +32,70 @@ func RunContainerInitProcess() error {
 		return fmt.Errorf(errFormat, err)
 	}
 	// 从 pipe 读取命令
-	cmdArray := readUserCommand()
+	cfg, err := readInitConfig()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	cmdArray := cfg.Args
 	if len(cmdArray) == 0 {
 		return errors.New("run container get user command error, cmdArray is nil")
 	}
+	if cfg.WorkingDir != "" {
+		if err := os.MkdirAll(cfg.WorkingDir, 0755); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if err := unix.Chdir(cfg.WorkingDir); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	if cfg.User != "" {
+		if err := setUser(cfg.User); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	// 和 shell 一样，命令找不到时以 127 退出，找到了但无法执行时以 126 退出，
+	// init 的退出码就是容器的退出码，run 在前台运行时会原样返回
 	path, err := exec.LookPath(cmdArray[0])
 	if err != nil {
-		return fmt.Errorf(errFormat, err)
+		code := errdefs.EXIT_COMMAND_NOT_FOUND
+		if errors.Is(err, os.ErrPermission) {
+			code = errdefs.EXIT_CANNOT_INVOKE
+		}
+		return &errdefs.ExitError{Code: code, Err: fmt.Errorf(errFormat, err)}
 	}
 	if err := unix.Exec(path, cmdArray[0:], os.Environ()); err != nil {
-		return fmt.Errorf(errFormat, err)
+		return &errdefs.ExitError{Code: errdefs.EXIT_CANNOT_INVOKE, Err: fmt.Errorf(errFormat, err)}
 	}
 	return nil
 }
 
-func readUserCommand() []string {
+func readInitConfig() (*InitConfig, error) {
 	pipe := os.NewFile(uintptr(fdIndex), "pipe")
 	defer pipe.Close()
-	msg, err := io.ReadAll(pipe)
+	var cfg InitConfig
+	if err := json.NewDecoder(pipe).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("readInitConfig: %w", err)
+	}
+	return &cfg, nil
+}
+
+// setUser 切换到容器内的用户，需要在 pivot_root 之后调用，以便读取容器的 passwd 和 group。
+// syscall 的 Setuid 等函数会作用于进程的所有线程
+func setUser(spec string) error {
+	u, err := lookupUser(spec, PATH_PASSWD, PATH_GROUP)
 	if err != nil {
-		log.Println("[error] init read pipe:", err)
-		return nil
+		return err
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	if err := syscall.Setgroups([]int{u.Gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
 }
 
 func setupMount() error {
@@ -0,0 +1,68 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"golang.org/x/sys/unix"
+)
+
+// IsAlive 判断容器进程是否仍在运行。宿主机重启后 pid 可能被宿主机上的其他进程复用，
+// 容器进程运行在独立的 pid namespace 中，因此 pid namespace 与当前进程相同时认为容器已经退出
+func IsAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	ns, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/ns/pid")
+	if err != nil {
+		return false
+	}
+	selfNs, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return true
+	}
+	return ns != selfNs
+}
+
+// CleanupOrphanMounts 卸载 overlay2 目录下不属于 known 中任何容器的挂载点
+func CleanupOrphanMounts(known map[string]bool) error {
+	errFormat := "cleanupOrphanMounts: %w"
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer f.Close()
+
+	prefix := consts.PATH_FS_ROOT + string(filepath.Separator)
+	var mountpoints []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[4], prefix) {
+			continue
+		}
+		id := strings.SplitN(strings.TrimPrefix(fields[4], prefix), string(filepath.Separator), 2)[0]
+		if !known[id] {
+			mountpoints = append(mountpoints, fields[4])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	// 先卸载 volume 等更深的挂载点
+	sort.Slice(mountpoints, func(i, j int) bool { return len(mountpoints[i]) > len(mountpoints[j]) })
+	for _, mp := range mountpoints {
+		logrus.Infof("reconcile: umount %s", mp)
+		if err := unix.Unmount(mp, unix.MNT_DETACH); err != nil {
+			logrus.Warnln("cleanupOrphanMounts:", mp, err)
+		}
+	}
+	return nil
+}
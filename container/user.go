@@ -0,0 +1,110 @@
+package container
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+const (
+	PATH_PASSWD = "/etc/passwd"
+	PATH_GROUP  = "/etc/group"
+)
+
+// execUser 容器进程的用户，由镜像或 run 指定的 user[:group] 解析而来
+type execUser struct {
+	Uid int
+	Gid int
+}
+
+// lookupUser 按容器内的 passwd 和 group 文件解析 user[:group]，用户和组都可以是名称或数字 ID。
+// 数字 ID 不要求在 passwd 中存在，只指定用户时使用用户的主组
+func lookupUser(spec, passwdPath, groupPath string) (*execUser, error) {
+	errFormat := "lookupUser %s: %w"
+	u := &execUser{}
+	userPart, groupPart, hasGroup := strings.Cut(spec, ":")
+	if userPart == "" {
+		return nil, fmt.Errorf(errFormat, spec, fmt.Errorf("%w: empty user", errdefs.ErrInvalidArgument))
+	}
+	uid, uidErr := strconv.Atoi(userPart)
+	found := false
+	err := scanColonFile(passwdPath, func(fields []string) bool {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 4 {
+			return false
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil || (fields[0] != userPart && (uidErr != nil || id != uid)) {
+			return false
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return false
+		}
+		u.Uid, u.Gid, found = id, gid, true
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, spec, err)
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf(errFormat, spec, fmt.Errorf("%w: no such user %s", errdefs.ErrNotFound, userPart))
+		}
+		u.Uid, u.Gid = uid, uid
+	}
+	if !hasGroup {
+		return u, nil
+	}
+
+	gid, gidErr := strconv.Atoi(groupPart)
+	if gidErr == nil {
+		u.Gid = gid
+		return u, nil
+	}
+	found = false
+	err = scanColonFile(groupPath, func(fields []string) bool {
+		// name:password:gid:members
+		if len(fields) < 3 || fields[0] != groupPart {
+			return false
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return false
+		}
+		u.Gid, found = id, true
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, spec, err)
+	}
+	if !found {
+		return nil, fmt.Errorf(errFormat, spec, fmt.Errorf("%w: no such group %s", errdefs.ErrNotFound, groupPart))
+	}
+	return u, nil
+}
+
+// scanColonFile 逐行读取 passwd 格式的文件，match 返回 true 时停止
+func scanColonFile(path string, match func(fields []string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+	return s.Err()
+}
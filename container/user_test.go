@@ -0,0 +1,41 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	dir := t.TempDir()
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	os.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/sh\n# comment\nwww:x:33:34::/var/www:/bin/false\n"), 0644)
+	os.WriteFile(group, []byte("root:x:0:\nstaff:x:50:www\n"), 0644)
+	tests := []struct {
+		spec    string
+		want    execUser
+		wantErr bool
+	}{
+		{spec: "root", want: execUser{Uid: 0, Gid: 0}},
+		{spec: "www", want: execUser{Uid: 33, Gid: 34}},
+		{spec: "33", want: execUser{Uid: 33, Gid: 34}},
+		{spec: "1000", want: execUser{Uid: 1000, Gid: 1000}},
+		{spec: "www:staff", want: execUser{Uid: 33, Gid: 50}},
+		{spec: "1000:7", want: execUser{Uid: 1000, Gid: 7}},
+		{spec: "nobody", wantErr: true},
+		{spec: "www:nogroup", wantErr: true},
+		{spec: ":staff", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := lookupUser(tt.spec, passwd, group)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("lookupUser() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Package errdefs 定义 mydocker 的错误分类和进程退出码。
+//
+// 各个包返回的错误都包装了下面的分类之一，调用方用 errors.Is 判断分类，
+// 命令行用 ExitCode 把错误转换成退出码，API 服务端用它转换成 HTTP 状态码
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 错误分类
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrAmbiguous       = errors.New("ambiguous")
+	ErrConflict        = errors.New("conflict")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrNotRunning      = errors.New("not running")
+	ErrAlreadyExists   = errors.New("already exists")
+)
+
+var categories = []error{ErrNotFound, ErrAmbiguous, ErrConflict, ErrInvalidArgument, ErrNotRunning, ErrAlreadyExists}
+
+// Category 返回 err 所属的分类，不属于任何分类时返回 nil
+func Category(err error) error {
+	for _, c := range categories {
+		if errors.Is(err, c) {
+			return c
+		}
+	}
+	return nil
+}
+
+// FromKind 根据分类名（分类错误的 Error()）返回分类，用于 API 客户端还原服务端错误的分类
+func FromKind(kind string) error {
+	for _, c := range categories {
+		if c.Error() == kind {
+			return c
+		}
+	}
+	return nil
+}
+
+// 进程退出码，参照 docker：125 表示 mydocker 自身出错，126/127 表示容器命令无法执行/找不到，
+// 其他分类的错误使用 125 以下的独立退出码，run 在前台运行时退出码就是容器进程的退出码
+const (
+	EXIT_INVALID_ARGUMENT  = 119
+	EXIT_NOT_FOUND         = 120
+	EXIT_AMBIGUOUS         = 121
+	EXIT_CONFLICT          = 122
+	EXIT_NOT_RUNNING       = 123
+	EXIT_ALREADY_EXISTS    = 124
+	EXIT_ERROR             = 125
+	EXIT_CANNOT_INVOKE     = 126
+	EXIT_COMMAND_NOT_FOUND = 127
+)
+
+// categorized 属于某个分类但有自己错误信息的错误
+type categorized struct {
+	category error
+	msg      string
+}
+
+func (e *categorized) Error() string { return e.msg }
+
+func (e *categorized) Unwrap() error { return e.category }
+
+// New 返回属于 category 的错误，errors.Is(err, category) 为 true，错误信息只有 msg，
+// 用于定义 runtime.ErrNotFound（"no such container"）这类更具体的哨兵错误
+func New(category error, msg string) error {
+	return &categorized{category: category, msg: msg}
+}
+
+// ExitError 要求 mydocker 以 Code 退出。Err 为 nil 时只传递退出码不输出错误，
+// 比如前台运行的容器以非 0 退出码退出
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// ExitCode 返回 err 对应的进程退出码，err 为 nil 时返回 0
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, ErrInvalidArgument):
+		return EXIT_INVALID_ARGUMENT
+	case errors.Is(err, ErrNotFound):
+		return EXIT_NOT_FOUND
+	case errors.Is(err, ErrAmbiguous):
+		return EXIT_AMBIGUOUS
+	case errors.Is(err, ErrConflict):
+		return EXIT_CONFLICT
+	case errors.Is(err, ErrNotRunning):
+		return EXIT_NOT_RUNNING
+	case errors.Is(err, ErrAlreadyExists):
+		return EXIT_ALREADY_EXISTS
+	default:
+		return EXIT_ERROR
+	}
+}
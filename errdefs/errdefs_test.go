@@ -0,0 +1,51 @@
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	errNoSuchContainer := New(ErrNotFound, "no such container")
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"plain", errors.New("boom"), EXIT_ERROR},
+		{"invalid argument", fmt.Errorf("run: %w: too few args", ErrInvalidArgument), EXIT_INVALID_ARGUMENT},
+		{"wrapped sentinel", fmt.Errorf("stop: %w: abc", errNoSuchContainer), EXIT_NOT_FOUND},
+		{"ambiguous", ErrAmbiguous, EXIT_AMBIGUOUS},
+		{"conflict", ErrConflict, EXIT_CONFLICT},
+		{"not running", ErrNotRunning, EXIT_NOT_RUNNING},
+		{"already exists", ErrAlreadyExists, EXIT_ALREADY_EXISTS},
+		{"container exit code", &ExitError{Code: 3}, 3},
+		{"exit error wins", &ExitError{Code: EXIT_COMMAND_NOT_FOUND, Err: ErrNotFound}, EXIT_COMMAND_NOT_FOUND},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKind(t *testing.T) {
+	err := fmt.Errorf("stop: %w: abc", New(ErrNotRunning, "container is not running"))
+	if err.Error() != "stop: container is not running: abc" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	c := Category(err)
+	if c != ErrNotRunning {
+		t.Fatalf("Category() = %v, want %v", c, ErrNotRunning)
+	}
+	if got := FromKind(c.Error()); got != ErrNotRunning {
+		t.Errorf("FromKind(%q) = %v, want %v", c.Error(), got, ErrNotRunning)
+	}
+	if got := FromKind("bogus"); got != nil {
+		t.Errorf("FromKind(bogus) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,290 @@
+// Package events 把容器和网络的生命周期事件按行追加到 consts.PATH_EVENTS 中的 JSON 日志，
+// 并支持按时间和条件查询以及持续读取新的事件
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+)
+
+const (
+	TYPE_CONTAINER = "container"
+	TYPE_NETWORK   = "network"
+)
+
+const (
+	ACTION_CREATE     = "create"
+	ACTION_START      = "start"
+	ACTION_DIE        = "die"
+	ACTION_STOP       = "stop"
+	ACTION_KILL       = "kill"
+	ACTION_OOM        = "oom"
+	ACTION_PAUSE      = "pause"
+	ACTION_UNPAUSE    = "unpause"
+	ACTION_DESTROY    = "destroy"
+	ACTION_CONNECT    = "connect"
+	ACTION_DISCONNECT = "disconnect"
+)
+
+// followInterval 读到日志末尾后等待新事件的轮询间隔
+const followInterval = 200 * time.Millisecond
+
+type Event struct {
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	// ID 容器事件为容器 ID，网络事件为网络名称
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Time       time.Time         `json:"time"`
+}
+
+func (e *Event) String() string {
+	var attrs []string
+	for _, k := range sortedKeys(e.Attributes) {
+		attrs = append(attrs, k+"="+e.Attributes[k])
+	}
+	s := fmt.Sprintf("%s %s %s %s", e.Time.Format(time.RFC3339Nano), e.Type, e.Action, e.ID)
+	if len(attrs) > 0 {
+		s += " (" + strings.Join(attrs, ", ") + ")"
+	}
+	return s
+}
+
+// Log 记录一个事件，失败时只打印警告，不影响触发事件的操作
+func Log(typ, action, id string, attrs map[string]string) {
+	e := &Event{Type: typ, Action: action, ID: id, Attributes: attrs, Time: time.Now()}
+	if err := Append(e); err != nil {
+		logrus.Warnln("events:", err)
+	}
+}
+
+// Append 把事件追加到日志末尾。每个事件一次 write 写入，O_APPEND 保证多个进程同时写入时不会交错
+func Append(e *Event) error {
+	errFormat := "events.Append: %w"
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	f, err := os.OpenFile(consts.PATH_EVENTS, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// Filter 查询条件，Since/Until 为零值时不限制，Filters 中不同的 key 之间是“与”，同一个 key 的多个值之间是“或”
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Filters map[string][]string
+}
+
+// filter 支持的 key：type、event（即 action）、container（ID、ID 前缀或名称）、network（名称）、
+// exitCode（die 事件的退出码，nonzero 匹配所有非 0 退出码）
+var filterKeys = map[string]bool{"type": true, "event": true, "container": true, "network": true, "exitCode": true}
+
+// ParseFilters 解析 key=value 形式的过滤条件
+func ParseFilters(args []string) (map[string][]string, error) {
+	filters := map[string][]string{}
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("events.ParseFilters: bad filter %q, want key=value", arg)
+		}
+		if !filterKeys[k] {
+			return nil, fmt.Errorf("events.ParseFilters: unsupported filter key %q", k)
+		}
+		filters[k] = append(filters[k], v)
+	}
+	return filters, nil
+}
+
+// ParseTime 解析 --since/--until：RFC3339 时间、Unix 时间戳（秒，可带小数）或相对 now 的时长（如 10m）
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("events.ParseTime: bad time %q, want RFC3339, unix timestamp or duration", s)
+}
+
+func (f *Filter) Match(e *Event) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	for k, vs := range f.Filters {
+		if !matchAny(vs, func(v string) bool { return matchKey(e, k, v) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchKey(e *Event, key, value string) bool {
+	switch key {
+	case "type":
+		return e.Type == value
+	case "event":
+		return e.Action == value
+	case "container":
+		if e.Type == TYPE_CONTAINER {
+			return strings.HasPrefix(e.ID, value) || e.Attributes["name"] == value
+		}
+		// 网络事件的 container 属性记录了连接的容器
+		return e.Attributes["container"] != "" && strings.HasPrefix(e.Attributes["container"], value)
+	case "network":
+		return e.Type == TYPE_NETWORK && e.ID == value
+	case "exitCode":
+		code, ok := e.Attributes["exitCode"]
+		if !ok {
+			return false
+		}
+		if value == "nonzero" {
+			return code != "0"
+		}
+		return code == value
+	}
+	return false
+}
+
+func matchAny(vs []string, fn func(string) bool) bool {
+	for _, v := range vs {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read 返回日志中满足条件的事件
+func Read(f Filter) ([]*Event, error) {
+	var es []*Event
+	err := Follow(f, nil, func(e *Event) error {
+		es = append(es, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("events.Read: %w", err)
+	}
+	return es, nil
+}
+
+// Follow 依次对满足条件的事件调用 fn。done 为 nil 时读到日志末尾就返回，
+// 否则继续等待新的事件，直到 done 被关闭、超过 f.Until 或 fn 返回错误。
+// 持续读取且没有指定 Since 时只返回新的事件
+func Follow(f Filter, done <-chan struct{}, fn func(*Event) error) error {
+	errFormat := "events.Follow: %w"
+	if done != nil && f.Since.IsZero() {
+		f.Since = time.Now()
+	}
+	file, err := os.Open(consts.PATH_EVENTS)
+	if errors.Is(err, os.ErrNotExist) && done != nil {
+		// 还没有任何事件，等待第一个事件写入时创建日志
+		file, err = waitFile(consts.PATH_EVENTS, done)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if file == nil {
+		return nil
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var partial []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf(errFormat, err)
+		}
+		if err == io.EOF {
+			// 写入方还没写完的半行留到下次读取
+			partial = append(partial, line...)
+			if done == nil || (!f.Until.IsZero() && time.Now().After(f.Until)) {
+				return nil
+			}
+			select {
+			case <-done:
+				return nil
+			case <-time.After(followInterval):
+			}
+			continue
+		}
+		if len(partial) > 0 {
+			line = append(partial, line...)
+			partial = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			logrus.Warnln("events: skip bad line:", err)
+			continue
+		}
+		if !f.Until.IsZero() && e.Time.After(f.Until) {
+			return nil
+		}
+		if !f.Match(&e) {
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+}
+
+func waitFile(path string, done <-chan struct{}) (*os.File, error) {
+	for {
+		select {
+		case <-done:
+			return nil, nil
+		case <-time.After(followInterval):
+		}
+		f, err := os.Open(path)
+		if !errors.Is(err, os.ErrNotExist) {
+			return f, err
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wlbyte/mydocker/consts"
+)
+
+func TestRead(t *testing.T) {
+	consts.PATH_EVENTS = filepath.Join(t.TempDir(), "events.log")
+	base := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for i, e := range []*Event{
+		{Type: TYPE_CONTAINER, Action: ACTION_CREATE, ID: "abc123", Attributes: map[string]string{"name": "web"}},
+		{Type: TYPE_NETWORK, Action: ACTION_CONNECT, ID: "mydocker0", Attributes: map[string]string{"container": "abc123"}},
+		{Type: TYPE_CONTAINER, Action: ACTION_START, ID: "abc123", Attributes: map[string]string{"name": "web"}},
+		{Type: TYPE_CONTAINER, Action: ACTION_DIE, ID: "def456", Attributes: map[string]string{"name": "db"}},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Second)
+		if err := Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		filters []string
+		want    []string
+	}{
+		{name: "all", want: []string{"create", "connect", "start", "die"}},
+		{name: "type", filters: []string{"type=container"}, want: []string{"create", "start", "die"}},
+		{name: "container name", filters: []string{"container=web"}, want: []string{"create", "start"}},
+		{name: "container id prefix", filters: []string{"container=abc"}, want: []string{"create", "connect", "start"}},
+		{name: "events or", filters: []string{"event=create", "event=die"}, want: []string{"create", "die"}},
+		{name: "keys and", filters: []string{"type=container", "container=abc"}, want: []string{"create", "start"}},
+		{name: "since until", filter: Filter{Since: base.Add(time.Second), Until: base.Add(2 * time.Second)}, want: []string{"connect", "start"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter
+			var err error
+			if f.Filters, err = ParseFilters(tt.filters); err != nil {
+				t.Fatal(err)
+			}
+			es, err := Read(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range es {
+				got = append(got, e.Action)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Read() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Read() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "2024-01-02T15:00:00Z", want: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)},
+		{in: "10m", want: now.Add(-10 * time.Minute)},
+		{in: "1704207845", want: time.Unix(1704207845, 0)},
+		{in: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
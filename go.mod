@@ -3,14 +3,13 @@ module github.com/wlbyte/mydocker
 go 1.23.3
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/urfave/cli v1.22.16
+	github.com/vishvananda/netns v0.0.4
 )
 
-require (
-	github.com/mattn/go-runewidth v0.0.9 // indirect
-	github.com/vishvananda/netns v0.0.4 // indirect
-)
+require github.com/mattn/go-runewidth v0.0.9 // indirect
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
@@ -0,0 +1,146 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+// ErrCorrupt blob 的内容和摘要不一致
+var ErrCorrupt = errors.New("blob content does not match its digest")
+
+// ValidateDigest 检查 digest 是否为 sha256:<64 位小写十六进制>
+func ValidateDigest(digest string) error {
+	hexPart, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 || strings.ToLower(hexPart) != hexPart {
+		return fmt.Errorf("%w: bad digest %q", errdefs.ErrInvalidArgument, digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return fmt.Errorf("%w: bad digest %q", errdefs.ErrInvalidArgument, digest)
+	}
+	return nil
+}
+
+// WriteBlob 把 r 的内容写入 blob 存储，返回内容的摘要和大小。
+// 先写临时文件并计算摘要，再按摘要重命名，相同内容的 blob 只保存一份
+func WriteBlob(r io.Reader) (string, int64, error) {
+	errFormat := "image.WriteBlob: %w"
+	dir := consts.PATH_BLOBS
+	if err := os.MkdirAll(dir, consts.MODE_0755); err != nil {
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	f, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		f.Close()
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	path := consts.GetPathBlob(digest)
+	if _, err := os.Stat(path); err == nil {
+		return digest, size, nil
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", 0, fmt.Errorf(errFormat, err)
+	}
+	return digest, size, nil
+}
+
+// OpenBlob 打开摘要为 digest 的 blob
+func OpenBlob(digest string) (*os.File, error) {
+	errFormat := "image.OpenBlob: %w"
+	if err := ValidateDigest(digest); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	f, err := os.Open(consts.GetPathBlob(digest))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: no such blob %s", errdefs.ErrNotFound, digest))
+		}
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return f, nil
+}
+
+// ReadBlob 读取 blob 的全部内容并校验摘要，用于清单和配置这类较小的 blob
+func ReadBlob(digest string) ([]byte, error) {
+	errFormat := "image.ReadBlob: %w"
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer f.Close()
+	bs, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	sum := sha256.Sum256(bs)
+	if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrCorrupt, digest))
+	}
+	return bs, nil
+}
+
+// BlobExists 判断 blob 是否已经在存储中
+func BlobExists(digest string) bool {
+	if ValidateDigest(digest) != nil {
+		return false
+	}
+	_, err := os.Stat(consts.GetPathBlob(digest))
+	return err == nil
+}
+
+// listBlobs 返回存储中所有 blob 的摘要
+func listBlobs() ([]string, error) {
+	entries, err := os.ReadDir(consts.PATH_BLOBS)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var digests []string
+	for _, e := range entries {
+		d := "sha256:" + e.Name()
+		if e.Type().IsRegular() && ValidateDigest(d) == nil {
+			digests = append(digests, d)
+		}
+	}
+	return digests, nil
+}
+
+// hashFile 计算文件内容的摘要
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
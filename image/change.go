@@ -0,0 +1,164 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+// ApplyChange 把一条 Dockerfile 风格的指令应用到镜像配置，用于 commit --change。
+// 支持 CMD、ENTRYPOINT、ENV、EXPOSE、LABEL、USER 和 WORKDIR
+func ApplyChange(cfg *ContainerConfig, change string) error {
+	errFormat := "image.ApplyChange %q: %w"
+	instruction, args, _ := strings.Cut(strings.TrimSpace(change), " ")
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return fmt.Errorf(errFormat, change, fmt.Errorf("%w: %s requires arguments", errdefs.ErrInvalidArgument, instruction))
+	}
+	switch strings.ToUpper(instruction) {
+	case "CMD":
+		cfg.Cmd = ParseCommand(args)
+	case "ENTRYPOINT":
+		cfg.Entrypoint = ParseCommand(args)
+	case "ENV":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return fmt.Errorf(errFormat, change, err)
+		}
+		for _, kv := range pairs {
+			cfg.Env = setEnv(cfg.Env, kv[0], kv[1])
+		}
+	case "LABEL":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return fmt.Errorf(errFormat, change, err)
+		}
+		if cfg.Labels == nil {
+			cfg.Labels = map[string]string{}
+		}
+		for _, kv := range pairs {
+			cfg.Labels[kv[0]] = kv[1]
+		}
+	case "EXPOSE":
+		for _, p := range strings.Fields(args) {
+			port, proto, _ := strings.Cut(p, "/")
+			if proto == "" {
+				proto = "tcp"
+			}
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || (proto != "tcp" && proto != "udp" && proto != "sctp") {
+				return fmt.Errorf(errFormat, change, fmt.Errorf("%w: bad port %s", errdefs.ErrInvalidArgument, p))
+			}
+			if cfg.ExposedPorts == nil {
+				cfg.ExposedPorts = map[string]struct{}{}
+			}
+			cfg.ExposedPorts[port+"/"+proto] = struct{}{}
+		}
+	case "USER":
+		cfg.User = args
+	case "WORKDIR":
+		// 相对路径相对于之前的工作目录
+		cfg.WorkingDir = path.Join("/", cfg.WorkingDir, args)
+		if path.IsAbs(args) {
+			cfg.WorkingDir = path.Clean(args)
+		}
+	default:
+		return fmt.Errorf(errFormat, change, fmt.Errorf("%w: unsupported instruction %s", errdefs.ErrInvalidArgument, instruction))
+	}
+	return nil
+}
+
+// ParseCommand 解析 CMD 和 ENTRYPOINT 的参数：JSON 数组是 exec 形式，否则是交给 /bin/sh -c 的 shell 形式
+func ParseCommand(args string) []string {
+	if strings.HasPrefix(args, "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(args), &cmd); err == nil {
+			return cmd
+		}
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// parsePairs 解析 ENV 和 LABEL 的参数，格式为 key=value key2="value 2"，
+// 只有一个键值对时也可以写成旧的 key value 形式
+func parsePairs(args string) ([][2]string, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+		key, value, _ := strings.Cut(args, " ")
+		return [][2]string{{key, strings.TrimSpace(value)}}, nil
+	}
+	var pairs [][2]string
+	for _, w := range words {
+		key, value, ok := strings.Cut(w, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: %q is not key=value", errdefs.ErrInvalidArgument, w)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// splitWords 按空白分词，支持单引号、双引号和反斜杠转义
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("%w: unterminated quote or escape in %q", errdefs.ErrInvalidArgument, s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%w: empty arguments", errdefs.ErrInvalidArgument)
+	}
+	return words, nil
+}
+
+// setEnv 设置 KEY=VALUE 形式的环境变量列表中的 key，已经存在时原地替换
+func setEnv(env []string, key, value string) []string {
+	kv := key + "=" + value
+	for i, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k == key {
+			env = slices.Clone(env)
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
@@ -0,0 +1,48 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChange(t *testing.T) {
+	tests := []struct {
+		change  string
+		want    ContainerConfig
+		wantErr bool
+	}{
+		{change: `CMD ["nginx", "-g", "daemon off;"]`, want: ContainerConfig{Cmd: []string{"nginx", "-g", "daemon off;"}}},
+		{change: `cmd echo hi`, want: ContainerConfig{Cmd: []string{"/bin/sh", "-c", "echo hi"}}},
+		{change: `ENTRYPOINT ["/app"]`, want: ContainerConfig{Entrypoint: []string{"/app"}}},
+		{change: `ENV A=2 B="x y"`, want: ContainerConfig{Env: []string{"A=2", "PATH=/bin", "B=x y"}}},
+		{change: `ENV B x y`, want: ContainerConfig{Env: []string{"A=1", "PATH=/bin", "B=x y"}}},
+		{change: `LABEL version=1.0 "team"=web`, want: ContainerConfig{Labels: map[string]string{"version": "1.0", "team": "web"}}},
+		{change: `USER www:staff`, want: ContainerConfig{User: "www:staff"}},
+		{change: `WORKDIR app`, want: ContainerConfig{WorkingDir: "/srv/app"}},
+		{change: `WORKDIR /data/`, want: ContainerConfig{WorkingDir: "/data"}},
+		{change: `EXPOSE 80 53/udp`, want: ContainerConfig{ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}}}},
+		{change: `EXPOSE http`, wantErr: true},
+		{change: `HEALTHCHECK NONE`, wantErr: true},
+		{change: `ENV`, wantErr: true},
+		{change: `ENV A="x`, wantErr: true},
+		{change: `LABEL =x`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.change, func(t *testing.T) {
+			cfg := ContainerConfig{}
+			switch {
+			case tt.want.Env != nil:
+				cfg.Env = []string{"A=1", "PATH=/bin"}
+			case tt.want.WorkingDir != "":
+				cfg.WorkingDir = "/srv"
+			}
+			err := ApplyChange(&cfg, tt.change)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(cfg, tt.want) {
+				t.Errorf("ApplyChange() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
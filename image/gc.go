@@ -0,0 +1,126 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// RemoveResult 删除镜像的结果
+type RemoveResult struct {
+	Untagged []string `json:"untagged"`
+	// Deleted 因为不再被任何镜像引用而删除的 blob
+	Deleted []string `json:"deleted"`
+}
+
+// Remove 删除镜像的引用，并回收不再被任何引用指向的 blob 和解压后的层。
+// name 是引用时只删除这一个引用，是 ID 或摘要时删除指向该镜像的所有引用。
+// keepLayers 是仍被容器使用的层的 diff ID，即使镜像已经删除也保留这些层的目录
+func Remove(name string, keepLayers []string) (*RemoveResult, error) {
+	errFormat := "image.Remove %s: %w"
+	res := &RemoveResult{}
+	err := withIndex(func(ix *index) error {
+		ref, digest, err := resolve(ix, name)
+		if err != nil {
+			return err
+		}
+		res.Untagged = []string{ref}
+		if ref == "" {
+			res.Untagged = refsOf(ix, digest)
+		}
+		for _, ref := range res.Untagged {
+			delete(ix.Images, ref)
+		}
+		if err := ix.save(); err != nil {
+			return err
+		}
+		deleted, err := gc(ix, keepLayers)
+		res.Deleted = deleted
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	return res, nil
+}
+
+// Lease 阻止 rmi 回收 blob 和层，直到调用返回的函数。写入 blob 之后不能在同一次加锁中
+// 登记镜像的操作（比如 build 的中间层）需要在写入前持有 Lease
+func Lease() (func(), error) {
+	release, err := utils.RLockFile(consts.PATH_IMAGE_LEASE_LOCK)
+	if err != nil {
+		return nil, fmt.Errorf("image.Lease: %w", err)
+	}
+	return release, nil
+}
+
+// gc 删除没有被 ix 中任何镜像引用的 blob 和层目录，调用方负责持有镜像库的锁。
+// 有其他进程持有 Lease 时不回收，留给之后的 rmi
+func gc(ix *index, keepLayers []string) ([]string, error) {
+	release, err := utils.TryLockFile(consts.PATH_IMAGE_LEASE_LOCK)
+	if errors.Is(err, utils.ErrLocked) {
+		logrus.Infoln("image: blobs are leased by a running build, skip garbage collection")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	blobs := map[string]bool{}
+	layers := map[string]bool{}
+	for _, id := range keepLayers {
+		layers[id] = true
+	}
+	for _, name := range sortedNames(ix) {
+		_, m, cfg, err := lookup(ix, name)
+		if err != nil {
+			// 引用关系不完整时不能确定哪些 blob 可以删除
+			return nil, fmt.Errorf("gc: image %s: %w", name, err)
+		}
+		blobs[ix.Images[name]] = true
+		blobs[m.Config.Digest] = true
+		for _, l := range m.Layers {
+			blobs[l.Digest] = true
+		}
+		for _, id := range cfg.RootFS.DiffIDs {
+			layers[id] = true
+		}
+	}
+
+	digests, err := listBlobs()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, d := range digests {
+		if blobs[d] {
+			continue
+		}
+		if err := os.Remove(consts.GetPathBlob(d)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+		deleted = append(deleted, d)
+	}
+
+	entries, err := os.ReadDir(consts.PATH_LAYERS)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return deleted, nil
+		}
+		return deleted, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || layers["sha256:"+e.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(consts.PATH_LAYERS, e.Name())); err != nil {
+			logrus.Warnln("image.gc:", err)
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,63 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func TestRemove(t *testing.T) {
+	testutil.SetTestRoot(t)
+	files := map[string]string{"etc/hostname": "box\n"}
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "a.tar"), false, files)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "b.tar"), false, files)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "c.tar"), false, map[string]string{"c": "c\n"})
+	a, err := Layers("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Layers("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Inspect("a")
+	if err != nil || len(d.Layers) != 1 || d.Layers[0].DiffID != a[0] || len(d.History) != 1 {
+		t.Fatalf("Inspect(a) = %+v, %v", d, err)
+	}
+
+	// a 和 b 的层相同，删除 a 只回收它的配置和清单，层仍被 b 引用
+	res, err := Remove("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Untagged) != 1 || res.Untagged[0] != "a:latest" || len(res.Deleted) != 2 {
+		t.Errorf("Remove(a) = %+v, want config and manifest deleted", res)
+	}
+	// c 的层仍被容器使用，只删除 blob
+	res, err = Remove("c", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 3 {
+		t.Errorf("Remove(c) deleted %v, want layer, config and manifest", res.Deleted)
+	}
+	for _, id := range append(a, c...) {
+		if _, err := os.Stat(consts.GetPathLayer(id)); err != nil {
+			t.Errorf("layer %s: %v", id, err)
+		}
+	}
+	if _, err := Remove("b", nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(consts.PATH_LAYERS)
+	blobs, _ := listBlobs()
+	if len(entries) != 0 || len(blobs) != 0 {
+		t.Errorf("after removing all images: %d layers, %d blobs", len(entries), len(blobs))
+	}
+	if _, err := Remove("b", nil); err == nil {
+		t.Error("Remove(b) twice succeeded")
+	}
+}
@@ -1,39 +1,128 @@
 package image
 
 import (
+	"compress/gzip"
 	"fmt"
-	"os/exec"
-	"path/filepath"
+	"os"
+	"time"
 
+	"github.com/wlbyte/mydocker/archive"
 	"github.com/wlbyte/mydocker/consts"
 )
 
-type Image struct{}
+// CommitOptions 把容器的修改提交为新镜像的参数
+type CommitOptions struct {
+	// ContainerID 只用于记录镜像历史
+	ContainerID string
+	// Parent 容器所用镜像的 ID，新镜像在它的层之上增加一层
+	Parent string
+	// UpperDir 容器 overlay 的 upper 目录，只包含容器修改过的文件
+	UpperDir string
+	// Reference 新镜像的引用
+	Reference string
+	Author    string
+	Message   string
+	// Changes 应用到新镜像配置上的 Dockerfile 指令，见 ApplyChange
+	Changes []string
+}
+
+// Commit 把容器 upper 目录中的修改打包成一层，加到父镜像的层之上，生成新的镜像。
+// 新镜像的配置继承父镜像，再依次应用 Changes
+func Commit(opts CommitOptions) (*Image, error) {
+	errFormat := "image.Commit: %w"
+	if _, err := ParseTag(opts.Reference); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	// 打包前先检查指令，避免打包之后才发现指令写错
+	for _, change := range opts.Changes {
+		if err := ApplyChange(&ContainerConfig{}, change); err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+	}
+	tmp, err := diffFile(opts.UpperDir)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer os.Remove(tmp)
 
-func BuildImage(containerID, imageName string) error {
-	srcDir := consts.GetPathMerged(containerID)
-	// ctx := context.TODO()
+	var img *Image
+	// 写入层和登记镜像在同一次加锁中完成，避免层在登记前被 rmi 回收
+	err = withIndex(func(ix *index) error {
+		parent, m, parentCfg, err := lookup(ix, opts.Parent)
+		if err != nil {
+			return fmt.Errorf("parent image: %w", err)
+		}
+		layer, diffID, err := WriteLayer(tmp)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		cfg := &Config{
+			Created:      now,
+			Author:       opts.Author,
+			Architecture: parentCfg.Architecture,
+			OS:           parentCfg.OS,
+			Config:       parentCfg.Config,
+			RootFS: RootFS{
+				Type:    parentCfg.RootFS.Type,
+				DiffIDs: append(append([]string{}, parentCfg.RootFS.DiffIDs...), diffID),
+			},
+			History: append(append([]History{}, parentCfg.History...), History{
+				Created:   now,
+				CreatedBy: "mydocker commit " + opts.ContainerID,
+				Author:    opts.Author,
+				Comment:   opts.Message,
+			}),
+			Parent:  parent.ID,
+			Comment: opts.Message,
+		}
+		for _, change := range opts.Changes {
+			if err := ApplyChange(&cfg.Config, change); err != nil {
+				return err
+			}
+		}
+		layers := append(append([]Descriptor{}, m.Layers...), layer)
+		img, err = create(ix, opts.Reference, cfg, layers)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return img, nil
+}
 
-	// files, err := archives.FilesFromDisk(ctx, nil, map[string]string{srcDir: ""})
-	// if err != nil {
-	// 	return fmt.Errorf("BuildImage: %w", err)
-	// }
-	imageTar := filepath.Join(consts.PATH_IMAGE, imageName+".tar")
-	// outFile, err := os.Create(imageTar)
-	// if err != nil {
-	// 	return fmt.Errorf("BuildImage: %w", err)
-	// }
-	// defer outFile.Close()
-	// outTarGz := archives.CompressedArchive{
-	// 	Compression: archives.Gz{},
-	// 	Archival:    archives.Tar{},
-	// }
-	// err = outTarGz.Archive(ctx, outFile, files)
-	// if err != nil {
-	// 	return fmt.Errorf("BuildImage: %w", err)
-	// }
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", srcDir, ".").CombinedOutput(); err != nil {
-		return fmt.Errorf("buildImage: %w", err)
-	}
-	return nil
+// DiffLayer 把容器 overlay 的 upper 目录作为一层写入 blob 存储，调用方需要持有 Lease
+func DiffLayer(upperDir string) (Descriptor, string, error) {
+	errFormat := "image.DiffLayer: %w"
+	tmp, err := diffFile(upperDir)
+	if err != nil {
+		return Descriptor{}, "", fmt.Errorf(errFormat, err)
+	}
+	defer os.Remove(tmp)
+	layer, diffID, err := WriteLayer(tmp)
+	if err != nil {
+		return Descriptor{}, "", fmt.Errorf(errFormat, err)
+	}
+	return layer, diffID, nil
+}
+
+// diffFile 把 upper 目录打包成 gzip 压缩的层，写入镜像目录下的临时文件，调用方负责删除
+func diffFile(upperDir string) (string, error) {
+	tmp, err := os.CreateTemp(consts.PATH_IMAGE, ".diff-")
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(tmp)
+	err = archive.WriteDiff(zw, upperDir)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
 }
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestCommit(t *testing.T) {
+	testutil.SetTestRoot(t)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "base.tar"), false, map[string]string{
+		"etc/hostname": "box\n",
+		"etc/motd":     "hi\n",
+		"var/cache/a":  "a\n",
+	})
+	base, err := Get("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟容器的 upper 目录：新增文件、硬链接、删除 etc/motd、清空 var/cache
+	upper := t.TempDir()
+	os.MkdirAll(filepath.Join(upper, "etc"), 0755)
+	os.MkdirAll(filepath.Join(upper, "var/cache"), 0755)
+	os.WriteFile(filepath.Join(upper, "etc/new"), []byte("new\n"), 0644)
+	os.Link(filepath.Join(upper, "etc/new"), filepath.Join(upper, "etc/new2"))
+	if err := unix.Mknod(filepath.Join(upper, "etc/motd"), unix.S_IFCHR, 0); err != nil {
+		t.Skip("mknod:", err)
+	}
+	if err := unix.Setxattr(filepath.Join(upper, "var/cache"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skip("setxattr:", err)
+	}
+
+	img, err := Commit(CommitOptions{
+		ContainerID: "c1",
+		Parent:      "base",
+		UpperDir:    upper,
+		Reference:   "app:v1",
+		Author:      "dev",
+		Message:     "add new",
+		Changes:     []string{"ENV A=1", `CMD ["cat", "/etc/new"]`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Commit(CommitOptions{Parent: "base", UpperDir: upper, Reference: "app:v2", Changes: []string{"HEALTHCHECK NONE"}}); err == nil {
+		t.Error("Commit() with a bad change succeeded")
+	}
+
+	d, err := Inspect("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Parent != base.ID || d.Author != "dev" || d.Comment != "add new" || len(d.Layers) != 2 || len(d.History) != 2 {
+		t.Errorf("Inspect() = %+v", d)
+	}
+	if d.Config.Env[0] != "A=1" || d.Config.Cmd[0] != "cat" {
+		t.Errorf("config = %+v", d.Config)
+	}
+	if img.ID != d.ID {
+		t.Errorf("Commit().ID = %s, Inspect().ID = %s", img.ID, d.ID)
+	}
+
+	layers, err := Layers("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := consts.GetPathLayer(layers[1])
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(dir, "etc/motd"), &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != 0 {
+		t.Errorf("etc/motd is not a whiteout: %+v, %v", st, err)
+	}
+	buf := make([]byte, 1)
+	if n, err := unix.Lgetxattr(filepath.Join(dir, "var/cache"), "trusted.overlay.opaque", buf); err != nil || n != 1 || buf[0] != 'y' {
+		t.Errorf("var/cache is not opaque: %v", err)
+	}
+	var st1, st2 unix.Stat_t
+	unix.Stat(filepath.Join(dir, "etc/new"), &st1)
+	unix.Stat(filepath.Join(dir, "etc/new2"), &st2)
+	if st1.Ino == 0 || st1.Ino != st2.Ino {
+		t.Errorf("etc/new2 is not a hard link of etc/new")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etc/hostname")); !os.IsNotExist(err) {
+		t.Errorf("diff layer contains unchanged file etc/hostname: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/wlbyte/mydocker/archive"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// Layers 返回镜像各层的 diff ID，顺序从最底层到最上层，用于拼接 overlay 的 lowerdir。
+// 层第一次被使用时解压到以 diff ID 命名的目录中，之后所有容器共享这一份只读的层，
+// 不同镜像中内容相同的层也只解压一次
+func Layers(name string) ([]string, error) {
+	errFormat := "image.Layers %s: %w"
+	var diffIDs []string
+	err := withIndex(func(ix *index) error {
+		_, m, cfg, err := lookup(ix, name)
+		if err != nil {
+			return err
+		}
+		if err := CheckRootFS(cfg, len(m.Layers)); err != nil {
+			return err
+		}
+		for i, l := range m.Layers {
+			if err := extractLayer(cfg.RootFS.DiffIDs[i], l.Digest); err != nil {
+				return err
+			}
+		}
+		diffIDs = cfg.RootFS.DiffIDs
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	return diffIDs, nil
+}
+
+// CheckRootFS 检查配置中的 diff ID 和 manifest 的层一一对应，并且都是合法的摘要。
+// diff ID 会被用作层目录的名字，来自镜像仓库或归档的配置不可信，使用前必须检查
+func CheckRootFS(cfg *Config, layers int) error {
+	if len(cfg.RootFS.DiffIDs) != layers {
+		return fmt.Errorf("%w: %d diff ids for %d layers", ErrCorrupt, len(cfg.RootFS.DiffIDs), layers)
+	}
+	for _, id := range cfg.RootFS.DiffIDs {
+		if err := ValidateDigest(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobDiffID 计算层的 diff ID：未压缩 tar 流的 sha256，同一个层压缩与否 diff ID 都相同
+func blobDiffID(digest string) (string, error) {
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractLayer 把层的 blob 解压到 diffID 对应的目录，目录已经存在时什么都不做。
+// 先解压到临时目录，校验 diff ID 后再重命名，解压失败或内容不符时不会留下不完整的层
+func extractLayer(diffID, digest string) error {
+	errFormat := "extractLayer %s: %w"
+	dir := consts.GetPathLayer(diffID)
+	if !utils.PathNotExist(dir) {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, consts.MODE_0755); err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	tmp, err := os.MkdirTemp(parent, ".extract-")
+	if err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	defer f.Close()
+	r, err := archive.DecompressStream(f)
+	if err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if err := archive.Extract(io.TeeReader(r, h), tmp); err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	// 解压读到结束标记就会返回，剩下的填充也要计入摘要
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != diffID {
+		return fmt.Errorf(errFormat, diffID, fmt.Errorf("%w: layer %s has diff id %s", ErrCorrupt, digest, got))
+	}
+	if err := os.Chmod(tmp, consts.MODE_0755); err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf(errFormat, diffID, err)
+	}
+	return nil
+}
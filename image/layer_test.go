@@ -0,0 +1,113 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func writeTar(t *testing.T, path string, compress bool, files map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	data := buf.Bytes()
+	if compress {
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write(data)
+		zw.Close()
+		data = gz.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	testutil.SetTestRoot(t)
+	files := map[string]string{"etc/hostname": "box\n"}
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "plain.tar"), false, files)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "gz.tar"), true, files)
+
+	plain, err := Layers("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != 1 {
+		t.Fatalf("Layers() = %v, want one layer", plain)
+	}
+	if _, err := os.Stat(filepath.Join(consts.PATH_IMAGE, "plain.tar")); !os.IsNotExist(err) {
+		t.Errorf("plain.tar still exists after import: %v", err)
+	}
+	bs, err := os.ReadFile(filepath.Join(consts.GetPathLayer(plain[0]), "etc/hostname"))
+	if err != nil || string(bs) != "box\n" {
+		t.Fatalf("extracted file = %q, %v", bs, err)
+	}
+	// 压缩与否不影响层的摘要，相同内容的镜像共享同一个层
+	gz, err := Layers("gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gz[0] != plain[0] {
+		t.Errorf("gzip layer = %s, want %s", gz[0], plain[0])
+	}
+	entries, err := os.ReadDir(consts.PATH_LAYERS)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("layers dir has %d entries, want 1 (%v)", len(entries), err)
+	}
+
+	if _, err := Layers("missing"); err == nil {
+		t.Error("Layers(missing) succeeded")
+	}
+}
+
+func TestLayersBadDiffID(t *testing.T) {
+	testutil.SetTestRoot(t)
+	tarPath := filepath.Join(t.TempDir(), "layer.tar")
+	writeTar(t, tarPath, false, map[string]string{"etc/hostname": "box\n"})
+	layer, _, err := WriteLayer(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 绕过 Create 直接写入存储，模拟检查之前就已经保存的镜像
+	cfg, _ := json.Marshal(&Config{RootFS: RootFS{Type: "layers", DiffIDs: []string{"sha256:../../.."}}})
+	cfgDigest, cfgSize, err := WriteBlob(bytes.NewReader(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _, err := putManifest(Descriptor{MediaType: MEDIA_TYPE_CONFIG, Digest: cfgDigest, Size: cfgSize}, []Descriptor{layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag(digest, "evil"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Layers("evil"); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Layers() with an escaping diff id = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestGetMountSrcDir(t *testing.T) {
+	testutil.SetTestRoot(t)
+	got := consts.GetMountSrcDir("c1", []string{"sha256:aaa", "sha256:bbb"})
+	want := "lowerdir=bbb/diff:aaa/diff,upperdir=" + consts.GetPathUpper("c1") + ",workdir=" + consts.GetPathWork("c1")
+	if got != want {
+		t.Errorf("GetMountSrcDir() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,426 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"time"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+const (
+	LAYOUT_FILE    = "oci-layout"
+	LAYOUT_VERSION = "1.0.0"
+	// DOCKER_MANIFEST docker save 生成的归档中描述镜像的文件
+	DOCKER_MANIFEST = "manifest.json"
+)
+
+// Save 把镜像以 OCI 镜像布局（oci-layout、index.json、blobs/sha256）写成 tar。
+// 按引用指定的镜像在 index.json 中记录名称，按 ID 指定的镜像没有名称
+func Save(w io.Writer, names []string) error {
+	errFormat := "image.Save: %w"
+	// 写出归档期间 blob 不能被 rmi 回收
+	release, err := Lease()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer release()
+
+	ix := Index{SchemaVersion: 2, MediaType: MEDIA_TYPE_INDEX}
+	var blobs []string
+	seen := map[string]bool{}
+	addBlob := func(digest string) {
+		if !seen[digest] {
+			seen[digest] = true
+			blobs = append(blobs, digest)
+		}
+	}
+	for _, name := range names {
+		img, m, _, err := Load(name)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		fi, err := os.Stat(consts.GetPathBlob(img.Digest))
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		desc := Descriptor{MediaType: MEDIA_TYPE_MANIFEST, Digest: img.Digest, Size: fi.Size()}
+		if m.MediaType != "" {
+			desc.MediaType = m.MediaType
+		}
+		if img.Name != "" {
+			r, _ := ParseReference(img.Name)
+			desc.Annotations = map[string]string{ANNOTATION_IMAGE_NAME: img.Name, ANNOTATION_REF_NAME: r.Tag}
+		}
+		ix.Manifests = append(ix.Manifests, desc)
+		addBlob(img.Digest)
+		addBlob(m.Config.Digest)
+		for _, l := range m.Layers {
+			addBlob(l.Digest)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	layout, _ := json.Marshal(map[string]string{"imageLayoutVersion": LAYOUT_VERSION})
+	index, err := json.Marshal(ix)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(layoutHeader(dir, tar.TypeDir, 0)); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	for _, blob := range blobs {
+		if err := writeBlobEntry(tw, blob); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{LAYOUT_FILE, layout}, {"index.json", index}} {
+		if err := tw.WriteHeader(layoutHeader(f.name, tar.TypeReg, int64(len(f.data)))); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func layoutHeader(name string, typ byte, size int64) *tar.Header {
+	mode := int64(0644)
+	if typ == tar.TypeDir {
+		mode = 0755
+	}
+	return &tar.Header{Name: name, Typeflag: typ, Mode: mode, Size: size, ModTime: time.Unix(0, 0)}
+}
+
+func writeBlobEntry(tw *tar.Writer, digest string) error {
+	f, err := OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name := "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+	if err := tw.WriteHeader(layoutHeader(name, tar.TypeReg, fi.Size())); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
+
+// Import 把 OCI 镜像布局或 docker save 生成的 tar 导入镜像库，返回导入的镜像，
+// 每个名称对应一个。归档中没有名称的镜像无法登记，导入失败
+func Import(r io.Reader) ([]*Image, error) {
+	errFormat := "image.Import: %w"
+	if err := os.MkdirAll(consts.PATH_IMAGE, consts.MODE_0755); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	dir, err := os.MkdirTemp(consts.PATH_IMAGE, ".load-")
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer os.RemoveAll(dir)
+	u, err := unpack(r, dir)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var images []*Image
+	err = withIndex(func(ix *index) error {
+		var err error
+		switch {
+		case u.exists(LAYOUT_FILE):
+			images, err = importLayout(ix, u)
+		case u.exists(DOCKER_MANIFEST):
+			images, err = importDockerSave(ix, u)
+		default:
+			err = fmt.Errorf("%w: neither an OCI image layout nor a docker save archive", errdefs.ErrInvalidArgument)
+		}
+		if err != nil {
+			return err
+		}
+		return ix.save()
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return images, nil
+}
+
+// unpacked 解开到临时目录的归档，按归档中的路径访问其中的文件
+type unpacked struct {
+	dir string
+	// links 归档中的符号链接和硬链接指向的路径，docker save 用符号链接复用相同的层
+	links map[string]string
+}
+
+// unpack 只解出普通文件，路径限制在 dir 之内
+func unpack(r io.Reader, dir string) (*unpacked, error) {
+	u := &unpacked{dir: dir, links: map[string]string{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return u, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean("/" + hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			p := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(p), consts.MODE_0755); err != nil {
+				return nil, err
+			}
+			f, err := os.Create(p)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			u.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		case tar.TypeLink:
+			u.links[name] = hdr.Linkname
+		}
+	}
+}
+
+// path 返回归档中的文件 name 解开后的路径
+func (u *unpacked) path(name string) string {
+	name = path.Clean("/" + name)
+	// 限制跳转次数，避免链接成环
+	for i := 0; i < 16; i++ {
+		target, ok := u.links[name]
+		if !ok {
+			break
+		}
+		name = path.Clean("/" + target)
+	}
+	return filepath.Join(u.dir, name)
+}
+
+func (u *unpacked) exists(name string) bool {
+	fi, err := os.Stat(u.path(name))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (u *unpacked) readJSON(name string, v any) error {
+	bs, err := os.ReadFile(u.path(name))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bs, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// importBlob 把镜像布局中的 blob 写入存储并校验摘要
+func (u *unpacked) importBlob(d Descriptor) error {
+	if err := ValidateDigest(d.Digest); err != nil {
+		return err
+	}
+	if BlobExists(d.Digest) {
+		return nil
+	}
+	f, err := os.Open(u.path("blobs/sha256/" + strings.TrimPrefix(d.Digest, "sha256:")))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	digest, _, err := WriteBlob(f)
+	if err != nil {
+		return err
+	}
+	if digest != d.Digest {
+		return fmt.Errorf("%w: %s", ErrCorrupt, d.Digest)
+	}
+	return nil
+}
+
+func importLayout(ix *index, u *unpacked) ([]*Image, error) {
+	var layout struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err := u.readJSON(LAYOUT_FILE, &layout); err != nil {
+		return nil, err
+	}
+	if layout.Version != LAYOUT_VERSION {
+		return nil, fmt.Errorf("%w: unsupported image layout version %q", errdefs.ErrInvalidArgument, layout.Version)
+	}
+	var idx Index
+	if err := u.readJSON("index.json", &idx); err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, d := range idx.Manifests {
+		name := layoutName(d.Annotations)
+		if name == "" {
+			return nil, fmt.Errorf("%w: image %s has no name in index.json", errdefs.ErrInvalidArgument, d.Digest)
+		}
+		r, err := ParseTag(name)
+		if err != nil {
+			return nil, err
+		}
+		digest, m, cfg, err := u.importManifest(d)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ix.Images[r.String()] = digest
+		images = append(images, newImage(ix, r.String(), digest, m, cfg))
+	}
+	// 同一个镜像可能在后面的条目中登记了其他名称
+	for _, img := range images {
+		img.RepoTags = refsOf(ix, img.Digest)
+	}
+	return images, nil
+}
+
+// layoutName index.json 中记录的镜像名称。按规范 ref.name 通常只是标签，
+// 只有包含仓库名时才能作为引用
+func layoutName(annotations map[string]string) string {
+	if name := annotations[ANNOTATION_IMAGE_NAME]; name != "" {
+		return name
+	}
+	if name := annotations[ANNOTATION_REF_NAME]; strings.ContainsAny(name, ":/") {
+		return name
+	}
+	return ""
+}
+
+// importManifest 导入清单及其引用的配置和层，多平台镜像的索引选择当前平台的清单
+func (u *unpacked) importManifest(d Descriptor) (string, *Manifest, *Config, error) {
+	if err := u.importBlob(d); err != nil {
+		return "", nil, nil, err
+	}
+	switch d.MediaType {
+	case MEDIA_TYPE_INDEX, MEDIA_TYPE_DOCKER_MANIFEST_LIST:
+		bs, err := ReadBlob(d.Digest)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		var idx Index
+		if err := json.Unmarshal(bs, &idx); err != nil {
+			return "", nil, nil, err
+		}
+		for _, md := range idx.Manifests {
+			if md.Platform == nil || md.Platform.OS == "linux" && md.Platform.Architecture == goruntime.GOARCH {
+				return u.importManifest(md)
+			}
+		}
+		return "", nil, nil, fmt.Errorf("%w: no manifest for linux/%s in %s", errdefs.ErrNotFound, goruntime.GOARCH, d.Digest)
+	}
+	m, err := LoadManifest(d.Digest)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := u.importBlob(m.Config); err != nil {
+		return "", nil, nil, err
+	}
+	for _, l := range m.Layers {
+		if err := u.importBlob(l); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	cfg, err := LoadConfig(m.Config.Digest)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := CheckRootFS(cfg, len(m.Layers)); err != nil {
+		return "", nil, nil, err
+	}
+	return d.Digest, m, cfg, nil
+}
+
+// dockerSaveEntry docker save 的 manifest.json 中的一项，路径相对于归档的根目录
+type dockerSaveEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// importDockerSave 导入 docker save 的归档：层是未压缩的 tar，按配置中的 diff ID 校验，
+// 配置原样写入，镜像 ID 和 Docker 中的相同
+func importDockerSave(ix *index, u *unpacked) ([]*Image, error) {
+	var entries []dockerSaveEntry
+	if err := u.readJSON(DOCKER_MANIFEST, &entries); err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, e := range entries {
+		if len(e.RepoTags) == 0 {
+			return nil, fmt.Errorf("%w: image %s has no name in %s", errdefs.ErrInvalidArgument, e.Config, DOCKER_MANIFEST)
+		}
+		refs := make([]string, 0, len(e.RepoTags))
+		for _, tag := range e.RepoTags {
+			r, err := ParseTag(tag)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, r.String())
+		}
+		f, err := os.Open(u.path(e.Config))
+		if err != nil {
+			return nil, err
+		}
+		cfgDigest, cfgSize, err := WriteBlob(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := LoadConfig(cfgDigest)
+		if err != nil {
+			return nil, err
+		}
+		if err := CheckRootFS(cfg, len(e.Layers)); err != nil {
+			return nil, err
+		}
+		layers := make([]Descriptor, 0, len(e.Layers))
+		for i, l := range e.Layers {
+			desc, diffID, err := WriteLayer(u.path(l))
+			if err != nil {
+				return nil, err
+			}
+			if diffID != cfg.RootFS.DiffIDs[i] {
+				return nil, fmt.Errorf("%w: layer %s has diff id %s, want %s", ErrCorrupt, l, diffID, cfg.RootFS.DiffIDs[i])
+			}
+			layers = append(layers, desc)
+		}
+		digest, m, err := putManifest(Descriptor{MediaType: MEDIA_TYPE_CONFIG, Digest: cfgDigest, Size: cfgSize}, layers)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			ix.Images[ref] = digest
+		}
+		for _, ref := range refs {
+			images = append(images, newImage(ix, ref, digest, m, cfg))
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,131 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func TestSaveImport(t *testing.T) {
+	testutil.SetTestRoot(t)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "base.tar"), true, map[string]string{"etc/hostname": "box\n"})
+	base, err := Get("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag("base", "registry.local/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Save(&buf, []string{"base", "registry.local/app:v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(&bytes.Buffer{}, []string{"none"}); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Save() of a missing image = %v, want ErrNotFound", err)
+	}
+
+	// 导入到新的镜像库
+	testutil.SetTestRoot(t)
+	images, err := Import(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].ID != base.ID || images[1].Name != "registry.local/app:v1" || len(images[0].RepoTags) != 2 {
+		t.Fatalf("Import() = %+v", images)
+	}
+	layers, err := Layers("registry.local/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(consts.GetPathLayer(layers[0]), "etc/hostname")); err != nil {
+		t.Error(err)
+	}
+	if _, problems, err := Verify(); err != nil || len(problems) != 0 {
+		t.Errorf("Verify() = %v, %v", problems, err)
+	}
+
+	if _, err := Import(bytes.NewReader(nil)); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Import() of an empty archive = %v, want ErrInvalidArgument", err)
+	}
+}
+
+// dockerSave 生成 docker save 格式的归档，第二个镜像的层是指向第一个的符号链接
+func dockerSave(t *testing.T, repoTags []string, diffID string) []byte {
+	t.Helper()
+	var layer bytes.Buffer
+	lw := tar.NewWriter(&layer)
+	lw.WriteHeader(&tar.Header{Name: "etc/hostname", Mode: 0644, Size: 4})
+	lw.Write([]byte("box\n"))
+	lw.Close()
+	if diffID == "" {
+		sum := sha256.Sum256(layer.Bytes())
+		diffID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	cfg, _ := json.Marshal(map[string]any{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]any{"Cmd": []string{"sh"}},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{diffID, diffID}},
+	})
+	sum := sha256.Sum256(cfg)
+	cfgName := hex.EncodeToString(sum[:]) + ".json"
+	manifest, _ := json.Marshal([]dockerSaveEntry{{Config: cfgName, RepoTags: repoTags, Layers: []string{"l1/layer.tar", "l2/layer.tar"}}})
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{cfgName, cfg}, {"l1/layer.tar", layer.Bytes()}, {DOCKER_MANIFEST, manifest}} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))})
+		tw.Write(f.data)
+	}
+	tw.WriteHeader(&tar.Header{Name: "l2/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../l1/layer.tar"})
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestImportDockerSave(t *testing.T) {
+	testutil.SetTestRoot(t)
+	data := dockerSave(t, []string{"app:1", "app:latest"}, "")
+	images, err := Import(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[1].Name != "app:latest" || len(images[0].RepoTags) != 2 {
+		t.Fatalf("Import() = %+v", images)
+	}
+	// 配置原样保存，镜像 ID 和 Docker 中的相同
+	cfgDigest := images[0].ID
+	cfg, err := LoadConfig(cfgDigest)
+	if err != nil || cfg.Config.Cmd[0] != "sh" {
+		t.Fatalf("LoadConfig() = %+v, %v", cfg, err)
+	}
+	if _, err := Layers("app"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := Import(bytes.NewReader(dockerSave(t, nil, ""))); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Import() of an untagged image = %v, want ErrInvalidArgument", err)
+	}
+	bad := "sha256:" + hex.EncodeToString(make([]byte, 32))
+	if _, err := Import(bytes.NewReader(dockerSave(t, []string{"bad"}, bad))); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Import() with a wrong diff id = %v, want ErrCorrupt", err)
+	}
+	if _, err := Import(bytes.NewReader(dockerSave(t, []string{"bad"}, "sha256:../../.."))); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Import() with an escaping diff id = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := Get("bad"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Get() after a failed import = %v, want ErrNotFound", err)
+	}
+}
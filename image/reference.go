@@ -0,0 +1,106 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+// DEFAULT_TAG 引用中没有标签也没有摘要时使用的标签
+const DEFAULT_TAG = "latest"
+
+var (
+	// 仓库路径中的一段，和 Docker 的规则相同：小写字母和数字，中间可以有 . _ __ 或连续的 -
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	domainRegexp        = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// Reference 镜像引用 [registry/]repo[:tag][@digest]
+type Reference struct {
+	// Domain 镜像仓库地址，为空时表示默认仓库
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// ParseReference 解析 Docker 风格的镜像引用，既没有标签也没有摘要时使用 latest 标签
+func ParseReference(s string) (Reference, error) {
+	var r Reference
+	bad := func(reason string) (Reference, error) {
+		return Reference{}, fmt.Errorf("%w: invalid reference %q: %s", errdefs.ErrInvalidArgument, s, reason)
+	}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+		if ValidateDigest(r.Digest) != nil {
+			return bad("bad digest")
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(r.Tag) {
+			return bad("bad tag")
+		}
+	}
+	if name == "" {
+		return bad("empty repository")
+	}
+	if len(name) > 255 {
+		return bad("repository name longer than 255 characters")
+	}
+	// 第一段包含 . 或 : 或者是 localhost 时是仓库地址
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			if !domainRegexp.MatchString(first) {
+				return bad("bad registry")
+			}
+			r.Domain, name = first, name[i+1:]
+		}
+	}
+	for _, c := range strings.Split(name, "/") {
+		if !pathComponentRegexp.MatchString(c) {
+			return bad("repository must be lowercase letters, digits and separators")
+		}
+	}
+	r.Path = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = DEFAULT_TAG
+	}
+	return r, nil
+}
+
+// Name 仓库名称 [registry/]repo
+func (r Reference) Name() string {
+	if r.Domain == "" {
+		return r.Path
+	}
+	return r.Domain + "/" + r.Path
+}
+
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ParseTag 解析用作镜像名称的引用，镜像名称只能带标签，不能带摘要
+func ParseTag(s string) (Reference, error) {
+	r, err := ParseReference(s)
+	if err != nil {
+		return Reference{}, err
+	}
+	if r.Digest != "" {
+		return Reference{}, fmt.Errorf("%w: cannot name an image with a digest: %s", errdefs.ErrInvalidArgument, s)
+	}
+	return r, nil
+}
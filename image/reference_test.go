@@ -0,0 +1,38 @@
+package image
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		in      string
+		want    Reference
+		wantErr bool
+	}{
+		{in: "busybox", want: Reference{Path: "busybox", Tag: "latest"}},
+		{in: "app:1.2", want: Reference{Path: "app", Tag: "1.2"}},
+		{in: "library/app", want: Reference{Path: "library/app", Tag: "latest"}},
+		{in: "localhost/app", want: Reference{Domain: "localhost", Path: "app", Tag: "latest"}},
+		{in: "registry.local:5000/team/app:v1", want: Reference{Domain: "registry.local:5000", Path: "team/app", Tag: "v1"}},
+		{in: "app@" + digest, want: Reference{Path: "app", Digest: digest}},
+		{in: "app:1@" + digest, want: Reference{Path: "app", Tag: "1", Digest: digest}},
+		{in: "", wantErr: true},
+		{in: "App", wantErr: true},
+		{in: "app:", wantErr: true},
+		{in: "app:-1", wantErr: true},
+		{in: "app@sha256:abc", wantErr: true},
+		{in: "a//b", wantErr: true},
+		{in: "bad_.name", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseReference(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
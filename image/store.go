@@ -0,0 +1,573 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/archive"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// Image 镜像库中的一个镜像。ID 是镜像配置的摘要，Digest 是清单的摘要
+type Image struct {
+	// Name 查找镜像时匹配到的引用，按 ID 或摘要查找时为空
+	Name     string    `json:"name,omitempty"`
+	ID       string    `json:"id"`
+	Digest   string    `json:"digest"`
+	RepoTags []string  `json:"repoTags,omitempty"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// index 镜像引用（规范化的 [registry/]repo:tag）到清单摘要的映射，清单确定了镜像 ID，
+// 多个引用可以指向同一个镜像。blob 本身只能通过摘要访问
+type index struct {
+	Images map[string]string `json:"images"`
+}
+
+func loadIndex() (*index, error) {
+	ix := &index{Images: map[string]string{}}
+	bs, err := os.ReadFile(consts.PATH_IMAGE_INDEX)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ix, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, ix); err != nil {
+		return nil, err
+	}
+	if ix.Images == nil {
+		ix.Images = map[string]string{}
+	}
+	// 以前的版本用不带标签的名称作为键
+	for name, digest := range ix.Images {
+		r, err := ParseTag(name)
+		if err != nil {
+			logrus.Warnln("image: skip bad reference in index:", err)
+			continue
+		}
+		if ref := r.String(); ref != name {
+			delete(ix.Images, name)
+			ix.Images[ref] = digest
+		}
+	}
+	return ix, nil
+}
+
+func (ix *index) save() error {
+	bs, err := json.MarshalIndent(ix, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(consts.PATH_IMAGE_INDEX, bs, 0644)
+}
+
+// withIndex 在镜像库的文件锁中执行 fn，执行前先导入镜像目录下旧格式的 <name>.tar
+func withIndex(fn func(ix *index) error) error {
+	unlock, err := utils.LockFile(consts.PATH_IMAGE_LOCK)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ix, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	if err := importLegacy(ix); err != nil {
+		return err
+	}
+	return fn(ix)
+}
+
+// importLegacy 把镜像目录下的 <name>.tar 导入为只有一层的镜像，导入成功后删除 tar。
+// 以前的版本直接把镜像保存为 tar，也可以把 rootfs 的 tar 放到镜像目录下作为导入镜像的方式
+func importLegacy(ix *index) error {
+	entries, err := os.ReadDir(consts.PATH_IMAGE)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || filepath.Ext(e.Name()) != ".tar" || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ".tar")
+		tarPath := filepath.Join(consts.PATH_IMAGE, e.Name())
+		r, err := ParseTag(name)
+		if err != nil {
+			logrus.Warnf("image: skip importing %s: %v", tarPath, err)
+			continue
+		}
+		ref := r.String()
+		if _, ok := ix.Images[ref]; ok {
+			logrus.Warnf("image: %s already exists, skip importing %s", ref, tarPath)
+			continue
+		}
+		if err := importTar(ix, ref, tarPath); err != nil {
+			return fmt.Errorf("import %s: %w", tarPath, err)
+		}
+		logrus.Infof("image: imported %s as %s", tarPath, ref)
+		if err := os.Remove(tarPath); err != nil {
+			return err
+		}
+		// 上个版本解压层时记录的信息
+		os.Remove(filepath.Join(consts.PATH_IMAGE, name+".json"))
+	}
+	return nil
+}
+
+func importTar(ix *index, name, tarPath string) error {
+	fi, err := os.Stat(tarPath)
+	if err != nil {
+		return err
+	}
+	layer, diffID, err := WriteLayer(tarPath)
+	if err != nil {
+		return err
+	}
+	cfg := &Config{
+		Created: fi.ModTime().UTC(),
+		RootFS:  RootFS{Type: "layers", DiffIDs: []string{diffID}},
+		History: []History{{Created: fi.ModTime().UTC(), CreatedBy: "import " + filepath.Base(tarPath)}},
+	}
+	_, err = create(ix, name, cfg, []Descriptor{layer})
+	return err
+}
+
+// WriteLayer 把 tar 文件（可以是 gzip 或 zstd 压缩的）作为层写入 blob 存储，返回层的描述和 diff ID。
+// 写入的 blob 在被镜像引用之前可能被 rmi 回收，调用方需要持有 Lease
+func WriteLayer(tarPath string) (Descriptor, string, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	mediaType := MEDIA_TYPE_LAYER
+	if c := archive.DetectCompression(br); c != archive.COMPRESSION_NONE {
+		mediaType += "+" + c
+	}
+	digest, size, err := WriteBlob(br)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	diffID, err := blobDiffID(digest)
+	if err != nil {
+		return Descriptor{}, "", err
+	}
+	return Descriptor{MediaType: mediaType, Digest: digest, Size: size}, diffID, nil
+}
+
+// Create 写入镜像配置和清单，并以引用 name 登记到镜像库，已有同名镜像时指向新的镜像。
+// layers 中的 blob 必须已经写入存储，cfg 的 RootFS 需要和 layers 一一对应。
+// 同名镜像被覆盖后，原来的 blob 留给 rmi 回收
+func Create(name string, cfg *Config, layers []Descriptor) (*Image, error) {
+	var img *Image
+	err := withIndex(func(ix *index) error {
+		var err error
+		img, err = create(ix, name, cfg, layers)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("image.Create %s: %w", name, err)
+	}
+	return img, nil
+}
+
+func create(ix *index, name string, cfg *Config, layers []Descriptor) (*Image, error) {
+	r, err := ParseTag(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.RootFS.DiffIDs) != len(layers) {
+		return nil, fmt.Errorf("%w: %d diff ids for %d layers", errdefs.ErrInvalidArgument, len(cfg.RootFS.DiffIDs), len(layers))
+	}
+	for _, id := range cfg.RootFS.DiffIDs {
+		if err := ValidateDigest(id); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range layers {
+		if !BlobExists(l.Digest) {
+			return nil, fmt.Errorf("%w: no such blob %s", errdefs.ErrNotFound, l.Digest)
+		}
+	}
+	if cfg.Architecture == "" {
+		cfg.Architecture = goruntime.GOARCH
+	}
+	if cfg.OS == "" {
+		cfg.OS = "linux"
+	}
+	mDigest, m, err := writeManifest(cfg, layers)
+	if err != nil {
+		return nil, err
+	}
+	ref := r.String()
+	ix.Images[ref] = mDigest
+	if err := ix.save(); err != nil {
+		return nil, err
+	}
+	return newImage(ix, ref, mDigest, m, cfg), nil
+}
+
+// writeManifest 写入镜像配置和清单的 blob，返回清单的摘要
+func writeManifest(cfg *Config, layers []Descriptor) (string, *Manifest, error) {
+	if cfg.RootFS.Type == "" {
+		cfg.RootFS.Type = "layers"
+	}
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	cfgDigest, cfgSize, err := WriteBlob(bytes.NewReader(cfgBytes))
+	if err != nil {
+		return "", nil, err
+	}
+	return putManifest(Descriptor{MediaType: MEDIA_TYPE_CONFIG, Digest: cfgDigest, Size: cfgSize}, layers)
+}
+
+// putManifest 写入引用已有配置 blob 的清单，导入的镜像保留原来的配置，镜像 ID 不变
+func putManifest(config Descriptor, layers []Descriptor) (string, *Manifest, error) {
+	m := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MEDIA_TYPE_MANIFEST,
+		Config:        config,
+		Layers:        layers,
+	}
+	mBytes, err := json.Marshal(m)
+	if err != nil {
+		return "", nil, err
+	}
+	mDigest, _, err := WriteBlob(bytes.NewReader(mBytes))
+	if err != nil {
+		return "", nil, err
+	}
+	return mDigest, m, nil
+}
+
+// WriteUntagged 写入镜像配置和清单但不登记引用，返回清单的摘要，可以用摘要运行容器。
+// 用于构建过程中的中间镜像，调用方需要持有 Lease，之后的 rmi 会回收这些 blob
+func WriteUntagged(cfg *Config, layers []Descriptor) (string, error) {
+	for _, l := range layers {
+		if !BlobExists(l.Digest) {
+			return "", fmt.Errorf("image.WriteUntagged: %w: no such blob %s", errdefs.ErrNotFound, l.Digest)
+		}
+	}
+	digest, _, err := writeManifest(cfg, layers)
+	if err != nil {
+		return "", fmt.Errorf("image.WriteUntagged: %w", err)
+	}
+	return digest, nil
+}
+
+func newImage(ix *index, ref, digest string, m *Manifest, cfg *Config) *Image {
+	size := m.Config.Size
+	for _, l := range m.Layers {
+		size += l.Size
+	}
+	return &Image{
+		Name:     ref,
+		ID:       m.Config.Digest,
+		Digest:   digest,
+		RepoTags: refsOf(ix, digest),
+		Size:     size,
+		Created:  cfg.Created,
+	}
+}
+
+// refsOf 返回指向清单 digest 的所有引用
+func refsOf(ix *index, digest string) []string {
+	var refs []string
+	for _, ref := range sortedNames(ix) {
+		if ix.Images[ref] == digest {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// LoadManifest 读取并解析摘要为 digest 的清单
+func LoadManifest(digest string) (*Manifest, error) {
+	bs, err := ReadBlob(digest)
+	if err != nil {
+		return nil, fmt.Errorf("image.LoadManifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("image.LoadManifest %s: %w", digest, err)
+	}
+	return &m, nil
+}
+
+// LoadConfig 读取并解析摘要为 digest 的镜像配置
+func LoadConfig(digest string) (*Config, error) {
+	bs, err := ReadBlob(digest)
+	if err != nil {
+		return nil, fmt.Errorf("image.LoadConfig: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("image.LoadConfig %s: %w", digest, err)
+	}
+	return &cfg, nil
+}
+
+// resolve 查找 name 指向的清单。name 可以是引用（app、app:1.2、app@sha256:...）、
+// 清单摘要、镜像 ID 或它们唯一的前缀。按引用的标签找到时 ref 为匹配的引用，否则为空
+func resolve(ix *index, name string) (ref, digest string, err error) {
+	if r, err := ParseReference(name); err == nil {
+		if r.Digest != "" {
+			for _, d := range ix.Images {
+				if d == r.Digest {
+					return "", d, nil
+				}
+			}
+			return "", "", fmt.Errorf("%w: no such image %s", errdefs.ErrNotFound, name)
+		}
+		if d, ok := ix.Images[r.String()]; ok {
+			return r.String(), d, nil
+		}
+	}
+	prefix := strings.TrimPrefix(name, "sha256:")
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil || prefix == "" {
+		return "", "", fmt.Errorf("%w: no such image %s", errdefs.ErrNotFound, name)
+	}
+	matches := map[string]bool{}
+	for _, d := range ix.Images {
+		if strings.HasPrefix(strings.TrimPrefix(d, "sha256:"), prefix) {
+			matches[d] = true
+			continue
+		}
+		m, err := LoadManifest(d)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimPrefix(m.Config.Digest, "sha256:"), prefix) {
+			matches[d] = true
+		}
+	}
+	switch len(matches) {
+	case 0:
+		// 没有登记引用的清单只能用完整的摘要访问
+		if ValidateDigest(name) == nil && BlobExists(name) {
+			if _, err := LoadManifest(name); err == nil {
+				return "", name, nil
+			}
+		}
+		return "", "", fmt.Errorf("%w: no such image %s", errdefs.ErrNotFound, name)
+	case 1:
+		for d := range matches {
+			digest = d
+		}
+		return "", digest, nil
+	default:
+		return "", "", fmt.Errorf("%w: multiple images match %s", errdefs.ErrAmbiguous, name)
+	}
+}
+
+// lookup 返回 name 对应的镜像、清单和配置
+func lookup(ix *index, name string) (*Image, *Manifest, *Config, error) {
+	ref, digest, err := resolve(ix, name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	m, err := LoadManifest(digest)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg, err := LoadConfig(m.Config.Digest)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return newImage(ix, ref, digest, m, cfg), m, cfg, nil
+}
+
+// Tag 让引用 target 指向 source 对应的镜像，target 已经存在时改为指向新的镜像
+func Tag(source, target string) error {
+	errFormat := "image.Tag: %w"
+	r, err := ParseTag(target)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	err = withIndex(func(ix *index) error {
+		_, digest, err := resolve(ix, source)
+		if err != nil {
+			return err
+		}
+		ix.Images[r.String()] = digest
+		return ix.save()
+	})
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// Load 返回 name 对应的镜像以及它的清单和配置
+func Load(name string) (*Image, *Manifest, *Config, error) {
+	var img *Image
+	var m *Manifest
+	var cfg *Config
+	err := withIndex(func(ix *index) error {
+		var err error
+		img, m, cfg, err = lookup(ix, name)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("image.Load: %w", err)
+	}
+	return img, m, cfg, nil
+}
+
+// Get 返回 name 对应的镜像，name 的格式见 resolve
+func Get(name string) (*Image, error) {
+	var img *Image
+	err := withIndex(func(ix *index) error {
+		var err error
+		img, _, _, err = lookup(ix, name)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("image.Get: %w", err)
+	}
+	return img, nil
+}
+
+// List 返回镜像库中的所有引用对应的镜像，按引用排序，同一个镜像的每个引用各占一项
+func List() ([]*Image, error) {
+	errFormat := "image.List: %w"
+	var images []*Image
+	err := withIndex(func(ix *index) error {
+		for _, ref := range sortedNames(ix) {
+			img, _, _, err := lookup(ix, ref)
+			if err != nil {
+				logrus.Warnln("image.List:", err)
+				continue
+			}
+			images = append(images, img)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return images, nil
+}
+
+func sortedNames(ix *index) []string {
+	names := make([]string, 0, len(ix.Images))
+	for name := range ix.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify 重新计算存储中每个 blob 的摘要，并检查每个镜像引用的 blob 是否齐全，
+// 返回检查的 blob 数量和发现的问题，没有问题时 problems 为空
+func Verify() (checked int, problems []string, err error) {
+	errFormat := "image.Verify: %w"
+	err = withIndex(func(ix *index) error {
+		digests, err := listBlobs()
+		if err != nil {
+			return err
+		}
+		for _, d := range digests {
+			got, err := hashFile(consts.GetPathBlob(d))
+			if err != nil {
+				return err
+			}
+			checked++
+			if got != d {
+				problems = append(problems, fmt.Sprintf("blob %s is corrupt, content hashes to %s", d, got))
+			}
+		}
+		for _, name := range sortedNames(ix) {
+			_, m, _, err := lookup(ix, name)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("image %s: %v", name, err))
+				continue
+			}
+			for _, l := range m.Layers {
+				if !BlobExists(l.Digest) {
+					problems = append(problems, fmt.Sprintf("image %s: missing layer %s", name, l.Digest))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return checked, problems, fmt.Errorf(errFormat, err)
+	}
+	return checked, problems, nil
+}
+
+// Layer 镜像中的一层
+type Layer struct {
+	Digest    string `json:"digest"`
+	DiffID    string `json:"diffID"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+// Detail 镜像的完整信息，用于 image inspect
+type Detail struct {
+	Image
+	Parent       string          `json:"parent,omitempty"`
+	Comment      string          `json:"comment,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+	Layers       []Layer         `json:"layers"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// Inspect 返回镜像的配置、各层和构建历史
+func Inspect(name string) (*Detail, error) {
+	var d *Detail
+	err := withIndex(func(ix *index) error {
+		img, m, cfg, err := lookup(ix, name)
+		if err != nil {
+			return err
+		}
+		d = &Detail{
+			Image:        *img,
+			Parent:       cfg.Parent,
+			Comment:      cfg.Comment,
+			Author:       cfg.Author,
+			Architecture: cfg.Architecture,
+			OS:           cfg.OS,
+			Config:       cfg.Config,
+			History:      cfg.History,
+		}
+		for i, l := range m.Layers {
+			layer := Layer{Digest: l.Digest, MediaType: l.MediaType, Size: l.Size}
+			if i < len(cfg.RootFS.DiffIDs) {
+				layer.DiffID = cfg.RootFS.DiffIDs[i]
+			}
+			d.Layers = append(d.Layers, layer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("image.Inspect: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func TestVerify(t *testing.T) {
+	testutil.SetTestRoot(t)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "a.tar"), false, map[string]string{"a": "a\n"})
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "b.tar"), true, map[string]string{"a": "a\n"})
+	images, err := List()
+	if err != nil || len(images) != 2 {
+		t.Fatalf("List() = %v, %v, want 2 images", images, err)
+	}
+	// 两个层 blob、两个配置和两个清单
+	checked, problems, err := Verify()
+	if err != nil || checked != 6 || len(problems) != 0 {
+		t.Fatalf("Verify() = %d, %v, %v", checked, problems, err)
+	}
+
+	if err := os.WriteFile(consts.GetPathBlob(images[0].Digest), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(consts.GetPathBlob(images[1].ID)); err != nil {
+		t.Fatal(err)
+	}
+	_, problems, err = Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"blob " + images[0].Digest + " is corrupt", "image a:", "image b:"}
+	if len(problems) != len(want) {
+		t.Fatalf("Verify() problems = %q, want %d", problems, len(want))
+	}
+	for i, p := range problems {
+		if !strings.HasPrefix(p, want[i]) {
+			t.Errorf("problem %d = %q, want prefix %q", i, p, want[i])
+		}
+	}
+}
+
+func TestWriteBlob(t *testing.T) {
+	testutil.SetTestRoot(t)
+	d1, size, err := WriteBlob(strings.NewReader("hello"))
+	if err != nil || size != 5 {
+		t.Fatalf("WriteBlob() = %s, %d, %v", d1, size, err)
+	}
+	d2, _, err := WriteBlob(strings.NewReader("hello"))
+	if err != nil || d2 != d1 {
+		t.Fatalf("WriteBlob() again = %s, %v, want %s", d2, err, d1)
+	}
+	if digests, _ := listBlobs(); len(digests) != 1 {
+		t.Errorf("store has %d blobs, want 1", len(digests))
+	}
+	if bs, err := ReadBlob(d1); err != nil || string(bs) != "hello" {
+		t.Errorf("ReadBlob() = %q, %v", bs, err)
+	}
+	if _, err := OpenBlob("sha256:../x"); err == nil {
+		t.Error("OpenBlob accepted a bad digest")
+	}
+}
+
+func TestTag(t *testing.T) {
+	testutil.SetTestRoot(t)
+	writeTar(t, filepath.Join(consts.PATH_IMAGE, "app.tar"), false, map[string]string{"a": "a\n"})
+	img, err := Get("app")
+	if err != nil || img.Name != "app:latest" {
+		t.Fatalf("Get(app) = %+v, %v", img, err)
+	}
+	if err := Tag("app", "registry.local/team/app:1.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Tag("app", "app@"+img.Digest); err == nil {
+		t.Error("Tag() with a digest target succeeded")
+	}
+	if err := Tag("missing", "x"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Tag(missing) error = %v, want not found", err)
+	}
+
+	for _, name := range []string{
+		"registry.local/team/app:1.2",
+		img.ID,
+		img.ID[len("sha256:"):][:12],
+		img.Digest,
+		"app@" + img.Digest,
+	} {
+		got, err := Get(name)
+		if err != nil || got.ID != img.ID {
+			t.Errorf("Get(%s) = %+v, %v", name, got, err)
+			continue
+		}
+		if len(got.RepoTags) != 2 {
+			t.Errorf("Get(%s).RepoTags = %v, want 2 tags", name, got.RepoTags)
+		}
+	}
+
+	res, err := Remove(img.ID[len("sha256:"):][:12], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Untagged) != 2 {
+		t.Errorf("Remove(id).Untagged = %v, want both tags", res.Untagged)
+	}
+	if images, _ := List(); len(images) != 0 {
+		t.Errorf("List() = %v after removing by id", images)
+	}
+}
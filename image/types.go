@@ -0,0 +1,95 @@
+package image
+
+import "time"
+
+// 镜像的清单和配置使用 OCI image spec 的格式，可以和 OCI 镜像布局、镜像仓库直接交换
+const (
+	MEDIA_TYPE_MANIFEST   = "application/vnd.oci.image.manifest.v1+json"
+	MEDIA_TYPE_CONFIG     = "application/vnd.oci.image.config.v1+json"
+	MEDIA_TYPE_LAYER      = "application/vnd.oci.image.layer.v1.tar"
+	MEDIA_TYPE_LAYER_GZIP = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MEDIA_TYPE_LAYER_ZSTD = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MEDIA_TYPE_INDEX      = "application/vnd.oci.image.index.v1+json"
+	// Docker 镜像格式的清单和清单列表，结构和 OCI 的相同
+	MEDIA_TYPE_DOCKER_MANIFEST      = "application/vnd.docker.distribution.manifest.v2+json"
+	MEDIA_TYPE_DOCKER_MANIFEST_LIST = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// 镜像布局 index.json 中记录镜像名称的注解
+const (
+	ANNOTATION_REF_NAME   = "org.opencontainers.image.ref.name"
+	ANNOTATION_IMAGE_NAME = "io.containerd.image.name"
+)
+
+// Descriptor 指向一个 blob
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	// Platform 只用于索引中的清单
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform 清单适用的平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Index 指向多个清单的索引，用于镜像布局的 index.json 和多平台镜像
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest 镜像清单，引用镜像配置和从最底层到最上层的各个层
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Config 镜像配置，它的摘要就是镜像 ID
+type Config struct {
+	Created      time.Time       `json:"created"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+	// Parent 由 commit 生成的镜像记录父镜像的 ID，和 Docker 的本地镜像相同，不属于 OCI 规范
+	Parent  string `json:"parent,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// ContainerConfig 使用镜像创建容器时的默认参数
+type ContainerConfig struct {
+	Cmd        []string          `json:"Cmd,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	User       string            `json:"User,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	// ExposedPorts 只是说明镜像监听的端口，格式为 80/tcp，值总是空对象
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+}
+
+// RootFS 各个层未压缩内容的摘要（diff ID），顺序和 Manifest.Layers 相同
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History 镜像每一层（或不产生层的配置修改）的构建记录
+type History struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
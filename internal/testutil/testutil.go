@@ -0,0 +1,25 @@
+// Package testutil 提供各个包的测试共用的辅助函数，只应该被 _test.go 文件导入。
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/consts"
+)
+
+// SetTestRoot 把数据目录和运行时目录切换到 tb 的临时目录下并创建常用的子目录，测试结束后恢复默认值。
+// 返回临时目录，测试可以在其中放置构建上下文等其他文件
+func SetTestRoot(tb testing.TB) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	consts.SetRoot(filepath.Join(dir, "root"), filepath.Join(dir, "run"))
+	tb.Cleanup(func() { consts.SetRoot("", "") })
+	for _, d := range []string{consts.PATH_IMAGE, consts.PATH_IPAM, consts.PATH_EXEC_ROOT} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
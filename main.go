@@ -1,67 +1,200 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"crypto/tls"
+	"errors"
 	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"github.com/wlbyte/mydocker/api"
+	"github.com/wlbyte/mydocker/client"
 	"github.com/wlbyte/mydocker/cmd"
+	"github.com/wlbyte/mydocker/config"
 	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/runtime"
+	"github.com/wlbyte/mydocker/utils"
+	"github.com/wlbyte/mydocker/webhook"
 )
 
 const usage = `mydocker is a simple container runtime implementation.
 			   The purpose of this project is to learn how docker works
 			   and how to write a docker by ourselves Enjoy it, just for fun.`
 
+const exitCodes = `Exit codes:
+   0     success
+   119   invalid argument
+   120   container, image or network not found
+   121   ambiguous container id prefix
+   122   conflict with the current state, eg: removing a running container
+   123   container is not running
+   124   name already in use
+   125   other mydocker errors
+   126   contained command cannot be invoked
+   127   contained command not found
+   run in foreground exits with the exit code of the container`
+
 func main() {
-	if err := initDir(); err != nil {
-		log.Fatal("[error] mydocker: ", err)
-	}
 	app := cli.NewApp()
 	app.Name = "mydocker"
 	app.Usage = usage
+	app.Description = exitCodes
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "config file path, default " + config.DEFAULT_CONFIG_PATH + ", eg: --config /etc/mydocker/config.json",
+			EnvVar: config.ENV_CONFIG,
+		},
+		cli.StringFlag{
+			Name:   "root",
+			Usage:  "root directory of persistent state, default " + consts.DEFAULT_ROOT + ", one root per process, run separate processes for isolated instances, eg: --root /var/lib/mydocker",
+			EnvVar: consts.ENV_ROOT,
+		},
+		cli.StringFlag{
+			Name:   "exec-root",
+			Usage:  "root directory of runtime state such as lock files, default " + consts.DEFAULT_EXEC_ROOT + ", eg: --exec-root /var/run/mydocker",
+			EnvVar: consts.ENV_EXEC_ROOT,
+		},
+		cli.StringFlag{
+			Name:   "host, H",
+			Usage:  "daemon address to connect to, eg: -H unix:///run/mydocker.sock or -H tcp://127.0.0.1:2375",
+			EnvVar: api.ENV_HOST,
+		},
+		cli.StringFlag{
+			Name:  "tlscacert",
+			Usage: "trust certs signed only by this CA, default ~/.mydocker/" + api.CA_CERT_FILE,
+		},
+		cli.StringFlag{
+			Name:  "tlscert",
+			Usage: "path to TLS certificate file, default ~/.mydocker/" + api.CLIENT_CERT_FILE + " (daemon: " + api.SERVER_CERT_FILE + ")",
+		},
+		cli.StringFlag{
+			Name:  "tlskey",
+			Usage: "path to TLS key file, default ~/.mydocker/" + api.CLIENT_KEY_FILE + " (daemon: " + api.SERVER_KEY_FILE + ")",
+		},
+		cli.BoolFlag{
+			Name:   "tlsverify",
+			Usage:  "use TLS and verify the remote, the daemon rejects clients without a certificate signed by the CA",
+			EnvVar: api.ENV_TLS_VERIFY,
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Usage:  "log level: debug, info, warn or error, default " + utils.DEFAULT_LOG_LEVEL,
+			EnvVar: utils.ENV_LOG_LEVEL,
+		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "log format: text or json, default " + utils.DEFAULT_LOG_FORMAT,
+			EnvVar: utils.ENV_LOG_FORMAT,
+		},
+		cli.BoolFlag{
+			Name:  "no-reconcile",
+			Usage: "skip repairing stale container and network state before running the command",
+		},
+	}
 
 	app.Commands = []cli.Command{
 		cmd.InitCommand,
 		cmd.RunCommand,
 		cmd.CommitCommand,
+		cmd.BuildCommand,
 		cmd.ListCommand,
 		cmd.LogsCommand,
 		cmd.ExecCommand,
 		cmd.StopCommand,
 		cmd.RemoveCommand,
 		cmd.NetworkCommand,
+		cmd.SystemCommand,
+		cmd.ImageCommand,
+		cmd.ImagesCommand,
+		cmd.RemoveImageCommand,
+		cmd.TagCommand,
+		cmd.SaveCommand,
+		cmd.LoadCommand,
+		cmd.PullCommand,
+		cmd.PushCommand,
+		cmd.LoginCommand,
+		cmd.LogoutCommand,
+		cmd.AttachCommand,
+		cmd.KillCommand,
+		cmd.PauseCommand,
+		cmd.UnpauseCommand,
+		cmd.EventsCommand,
+		cmd.AuditCommand,
+		cmd.WebhookCommand,
+		cmd.MetricsCommand,
+		cmd.TLSCommand,
+		cmd.DaemonCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
-		logrus.SetOutput(os.Stdout)
+		// 日志写到 stderr，命令输出（容器 id、列表等）写到 stdout
+		if err := utils.SetupLogging(context.GlobalString("log-level"), context.GlobalString("log-format")); err != nil {
+			return err
+		}
+		// 容器 init 进程运行在新的 mount namespace 中，不需要也不应该创建宿主机上的数据目录
+		if context.Args().First() == cmd.InitCommand.Name {
+			return nil
+		}
+		// 指定了 daemon 地址时所有命令都通过 API 执行，不在本地创建 Runtime
+		if host := context.GlobalString("host"); host != "" && context.Args().First() != cmd.DaemonCommand.Name {
+			var tlsConfig *tls.Config
+			if opts := cmd.TLSOptions(context); opts.Enabled() {
+				var err error
+				if tlsConfig, err = api.ClientTLSConfig(opts); err != nil {
+					return err
+				}
+			}
+			c, err := client.New(host, tlsConfig)
+			if err != nil {
+				return err
+			}
+			cmd.SetClient(c)
+			return nil
+		}
+		cfg, err := config.Load(context.GlobalString("config"))
+		if err != nil {
+			return err
+		}
+		// 优先级：命令行参数/环境变量 > 配置文件 > 默认值
+		r, err := runtime.New(runtime.Options{
+			Root:     context.GlobalString("root"),
+			ExecRoot: context.GlobalString("exec-root"),
+			Config:   cfg,
+		})
+		if err != nil {
+			return err
+		}
+		cmd.SetRuntime(r)
+		if len(cfg.Webhooks) > 0 {
+			d, err := webhook.New(cfg.Webhooks)
+			if err != nil {
+				return err
+			}
+			// 在命令产生事件之前确定 webhook 从日志的哪个位置开始投递
+			if err := d.Init(); err != nil {
+				logrus.Warnln("mydocker:", err)
+			}
+			cmd.SetWebhooks(d)
+		}
+		// system reconcile 会执行完整的修复，这里只做开销较小的检查
+		if !context.GlobalBool("no-reconcile") && context.Args().First() != cmd.SystemCommand.Name {
+			if err := r.Reconcile(false); err != nil {
+				logrus.Warnln("mydocker:", err)
+			}
+		}
 		return nil
 	}
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal("[error] mydocker: ", err)
-	}
-}
-
-func initDir() error {
-	errFormat := "initDir %s: %w"
-	// 创建数据目录
-	if err := os.MkdirAll(consts.PATH_CONTAINER, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, consts.PATH_CONTAINER, err)
-	}
-	if err := os.MkdirAll(consts.PATH_FS_ROOT, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, consts.PATH_FS_ROOT, err)
-	}
-	if err := os.MkdirAll(consts.PATH_IMAGE, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, consts.PATH_IMAGE, err)
-	}
-	if err := os.MkdirAll(consts.PATH_IPAM, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, consts.PATH_IPAM, err)
-	}
-	if err := os.MkdirAll(consts.PATH_NETWORK_NETWORK, consts.MODE_0755); err != nil {
-		return fmt.Errorf(errFormat, consts.PATH_NETWORK_NETWORK, err)
+	err := app.Run(os.Args)
+	cmd.FlushWebhooks()
+	if err != nil {
+		// 只传递容器退出码的错误不需要输出
+		var exitErr *errdefs.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Err != nil {
+			logrus.Error("mydocker: ", err)
+		}
+		os.Exit(errdefs.ExitCode(err))
 	}
-	return nil
 }
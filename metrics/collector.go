@@ -0,0 +1,225 @@
+// Package metrics 以 Prometheus 文本格式导出容器资源使用、IPAM 地址池和 runtime 操作耗时
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/cgroups"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/network"
+)
+
+// Collector 实现 /metrics，每次抓取时重新读取容器、cgroup 和 IPAM 的状态
+type Collector struct {
+	list func() ([]*container.Container, error)
+}
+
+// NewCollector list 返回所有容器，包括已经退出的
+func NewCollector(list func() ([]*container.Container, error)) *Collector {
+	return &Collector{list: list}
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := write(&buf, c.collect()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	w.Write(buf.Bytes())
+}
+
+// collect 某一项采集失败时跳过该项并记录 mydocker_scrape_errors，不影响其他指标
+func (c *Collector) collect() []*family {
+	var (
+		info = &family{name: "mydocker_container_info", typ: typeGauge,
+			help: "Container metadata, the value is always 1."}
+		containers = &family{name: "mydocker_containers", typ: typeGauge,
+			help: "Number of containers by status."}
+		cpu = &family{name: "mydocker_container_cpu_usage_seconds_total", typ: typeCounter,
+			help: "Total CPU time consumed by the container."}
+		memory = &family{name: "mydocker_container_memory_usage_bytes", typ: typeGauge,
+			help: "Current memory usage of the container including page cache."}
+		memoryLimit = &family{name: "mydocker_container_memory_limit_bytes", typ: typeGauge,
+			help: "Memory limit of the container, absent when unlimited."}
+		pids = &family{name: "mydocker_container_pids", typ: typeGauge,
+			help: "Number of processes in the container."}
+		rxBytes = &family{name: "mydocker_container_network_receive_bytes_total", typ: typeCounter,
+			help: "Bytes received by the container network interface."}
+		txBytes = &family{name: "mydocker_container_network_transmit_bytes_total", typ: typeCounter,
+			help: "Bytes transmitted by the container network interface."}
+		rxPackets = &family{name: "mydocker_container_network_receive_packets_total", typ: typeCounter,
+			help: "Packets received by the container network interface."}
+		txPackets = &family{name: "mydocker_container_network_transmit_packets_total", typ: typeCounter,
+			help: "Packets transmitted by the container network interface."}
+		restarts = &family{name: "mydocker_container_restarts_total", typ: typeCounter,
+			help: "Number of times the container was started after its first start."}
+		ipamAllocated = &family{name: "mydocker_ipam_allocated_ips", typ: typeGauge,
+			help: "Allocated addresses in the IPAM subnet, including the gateway."}
+		ipamCapacity = &family{name: "mydocker_ipam_capacity_ips", typ: typeGauge,
+			help: "Usable addresses in the IPAM subnet."}
+		ipamUtilisation = &family{name: "mydocker_ipam_utilisation_ratio", typ: typeGauge,
+			help: "Allocated divided by usable addresses in the IPAM subnet."}
+		opDuration = &family{name: "mydocker_operation_duration_seconds", typ: typeHistogram,
+			help: "Duration of runtime operations such as create, start, stop and remove."}
+		opErrors = &family{name: "mydocker_operation_errors_total", typ: typeCounter,
+			help: "Number of runtime operations that returned an error."}
+		scrapeErrors = &family{name: "mydocker_scrape_errors", typ: typeGauge,
+			help: "Number of collectors that failed during this scrape."}
+	)
+	failed := 0
+	fail := func(err error) {
+		logrus.Warnln("metrics:", err)
+		failed++
+	}
+
+	cs, err := c.list()
+	if err != nil {
+		fail(err)
+	}
+	starts, err := countStarts()
+	if err != nil {
+		fail(err)
+	}
+	byStatus := map[string]int{}
+	for _, ct := range cs {
+		byStatus[ct.Status]++
+		labels := []label{{"id", ct.Id}, {"name", ct.Name}, {"image", ct.ImageName}, {"network", ct.Network}}
+		info.add(1, withLabel(labels, "status", ct.Status)...)
+		if n := starts[ct.Id]; n > 1 {
+			restarts.add(float64(n-1), labels...)
+		} else {
+			restarts.add(0, labels...)
+		}
+		if (ct.Status != consts.STATUS_RUNNING && ct.Status != consts.STATUS_PAUSED) || ct.CgroupPath == "" {
+			continue
+		}
+		stats := cgroups.NewCgroupManager(ct.CgroupPath).Stats()
+		if stats.CPUUsageNanos != nil {
+			cpu.add(float64(*stats.CPUUsageNanos)/1e9, labels...)
+		}
+		if stats.MemoryUsage != nil {
+			memory.add(float64(*stats.MemoryUsage), labels...)
+		}
+		if stats.MemoryLimit != nil {
+			memoryLimit.add(float64(*stats.MemoryLimit), labels...)
+		}
+		if stats.Pids != nil {
+			pids.add(float64(*stats.Pids), labels...)
+		}
+		ifaces, err := readNetDev(ct.Pid)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		for _, iface := range ifaces {
+			l := withLabel(labels, "interface", iface.name)
+			rxBytes.add(float64(iface.rxBytes), l...)
+			txBytes.add(float64(iface.txBytes), l...)
+			rxPackets.add(float64(iface.rxPackets), l...)
+			txPackets.add(float64(iface.txPackets), l...)
+		}
+	}
+	for _, status := range sortedKeys(byStatus) {
+		containers.add(float64(byStatus[status]), label{"status", status})
+	}
+
+	usage, err := network.IPAMUsage()
+	if err != nil {
+		fail(err)
+	}
+	for _, u := range usage {
+		l := label{"subnet", u.Subnet}
+		ipamAllocated.add(float64(u.Allocated), l)
+		ipamCapacity.add(float64(u.Capacity), l)
+		if u.Capacity > 0 {
+			ipamUtilisation.add(float64(u.Allocated)/float64(u.Capacity), l)
+		}
+	}
+
+	ops, err := loadOperations()
+	if err != nil {
+		fail(err)
+	}
+	for _, op := range sortedKeys(ops) {
+		h := ops[op]
+		if len(h.Buckets) != len(operationBuckets) {
+			continue
+		}
+		l := label{"operation", op}
+		opDuration.addHistogram(operationBuckets, h.Buckets, h.Sum, h.Count, l)
+		opErrors.add(float64(h.Errors), l)
+	}
+	scrapeErrors.add(float64(failed))
+
+	return []*family{
+		info, containers, cpu, memory, memoryLimit, pids,
+		rxBytes, txBytes, rxPackets, txPackets, restarts,
+		ipamAllocated, ipamCapacity, ipamUtilisation,
+		opDuration, opErrors, scrapeErrors,
+	}
+}
+
+// countStarts 从事件日志统计每个容器的启动次数
+func countStarts() (map[string]int, error) {
+	es, err := events.Read(events.Filter{Filters: map[string][]string{
+		"type":  {events.TYPE_CONTAINER},
+		"event": {events.ACTION_START},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	starts := map[string]int{}
+	for _, e := range es {
+		starts[e.ID]++
+	}
+	return starts, nil
+}
+
+type netDev struct {
+	name               string
+	rxBytes, rxPackets uint64
+	txBytes, txPackets uint64
+}
+
+// readNetDev 读取容器进程所在 network namespace 的 /proc/<pid>/net/dev，忽略 lo
+func readNetDev(pid int) ([]netDev, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/net/dev")
+	if err != nil {
+		return nil, fmt.Errorf("readNetDev: %w", err)
+	}
+	defer f.Close()
+	var devs []netDev
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 前两行是表头：eth0: 1234 10 0 0 0 0 0 0 5678 20 0 0 0 0 0 0
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(rest)
+		if name == "lo" || len(fields) < 10 {
+			continue
+		}
+		d := netDev{name: name}
+		d.rxBytes, _ = strconv.ParseUint(fields[0], 10, 64)
+		d.rxPackets, _ = strconv.ParseUint(fields[1], 10, 64)
+		d.txBytes, _ = strconv.ParseUint(fields[8], 10, 64)
+		d.txPackets, _ = strconv.ParseUint(fields[9], 10, 64)
+		devs = append(devs, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("readNetDev: %w", err)
+	}
+	return devs, nil
+}
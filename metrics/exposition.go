@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prometheus 文本格式 0.0.4，参见 https://prometheus.io/docs/instrumenting/exposition_formats/
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	typeGauge     = "gauge"
+	typeCounter   = "counter"
+	typeHistogram = "histogram"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	suffix string
+	labels []label
+	value  float64
+}
+
+// family 同名指标的 HELP、TYPE 和所有样本
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+func (f *family) add(value float64, labels ...label) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// addHistogram 写入累计的 _bucket、_sum 和 _count，bounds 与 counts 一一对应，不包含 +Inf
+func (f *family) addHistogram(bounds []float64, counts []uint64, sum float64, count uint64, labels ...label) {
+	var cumulative uint64
+	for i, b := range bounds {
+		cumulative += counts[i]
+		f.samples = append(f.samples, sample{suffix: "_bucket", labels: withLabel(labels, "le", formatFloat(b)), value: float64(cumulative)})
+	}
+	f.samples = append(f.samples, sample{suffix: "_bucket", labels: withLabel(labels, "le", "+Inf"), value: float64(count)})
+	f.samples = append(f.samples, sample{suffix: "_sum", labels: labels, value: sum})
+	f.samples = append(f.samples, sample{suffix: "_count", labels: labels, value: float64(count)})
+}
+
+func withLabel(labels []label, name, value string) []label {
+	ls := make([]label, 0, len(labels)+1)
+	ls = append(ls, labels...)
+	return append(ls, label{name, value})
+}
+
+// write 没有样本的指标不输出
+func write(w io.Writer, families []*family) error {
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ); err != nil {
+			return err
+		}
+		for _, s := range f.samples {
+			if _, err := fmt.Fprintf(w, "%s%s%s %s\n", f.name, s.suffix, formatLabels(s.labels), formatFloat(s.value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.name + `="` + escapeLabel(l.value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	g := &family{name: "test_gauge", typ: typeGauge, help: "A gauge.\nSecond line."}
+	g.add(1.5, label{"name", `a"b\c` + "\n"})
+	h := &family{name: "test_seconds", typ: typeHistogram, help: "A histogram."}
+	h.addHistogram([]float64{0.1, 1}, []uint64{2, 1}, 1.7, 4, label{"op", "start"})
+	empty := &family{name: "test_empty", typ: typeCounter, help: "No samples."}
+
+	var sb strings.Builder
+	if err := write(&sb, []*family{g, h, empty}); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_gauge A gauge.\nSecond line.
+# TYPE test_gauge gauge
+test_gauge{name="a\"b\\c\n"} 1.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="start",le="0.1"} 2
+test_seconds_bucket{op="start",le="1"} 3
+test_seconds_bucket{op="start",le="+Inf"} 4
+test_seconds_sum{op="start"} 1.7
+test_seconds_count{op="start"} 4
+`
+	if sb.String() != want {
+		t.Errorf("write() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// operationBuckets 操作耗时直方图的上界，单位秒
+var operationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram 一个操作的耗时分布，Buckets 是每个区间（不累计）的次数
+type histogram struct {
+	Buckets []uint64 `json:"buckets"`
+	Sum     float64  `json:"sum"`
+	Count   uint64   `json:"count"`
+	Errors  uint64   `json:"errors"`
+}
+
+// ObserveOperation 记录一次 runtime 操作的耗时和结果。
+// 每个 CLI 命令都是独立进程，统计保存在运行时目录的 metrics.json 中，由各个进程在文件锁内累加，
+// 重启后清零，与 Prometheus 计数器的语义一致。记录失败只打印警告
+func ObserveOperation(op string, start time.Time, err error) {
+	if e := observe(op, time.Since(start), err != nil); e != nil {
+		logrus.Warnln("metrics:", e)
+	}
+}
+
+func observe(op string, d time.Duration, failed bool) error {
+	errFormat := "observe: %w"
+	unlock, err := utils.LockFile(consts.PATH_METRICS_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	ops, err := loadOperations()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	h, ok := ops[op]
+	if !ok || len(h.Buckets) != len(operationBuckets) {
+		h = &histogram{Buckets: make([]uint64, len(operationBuckets))}
+		ops[op] = h
+	}
+	seconds := d.Seconds()
+	for i, b := range operationBuckets {
+		if seconds <= b {
+			h.Buckets[i]++
+			break
+		}
+	}
+	h.Sum += seconds
+	h.Count++
+	if failed {
+		h.Errors++
+	}
+	bs, err := json.Marshal(ops)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := utils.WriteFileAtomic(consts.PATH_METRICS, bs, 0644); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func loadOperations() (map[string]*histogram, error) {
+	ops := map[string]*histogram{}
+	bs, err := os.ReadFile(consts.PATH_METRICS)
+	if errors.Is(err, os.ErrNotExist) {
+		return ops, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
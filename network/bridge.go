@@ -4,11 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"github.com/wlbyte/mydocker/config"
 	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/utils"
 )
 
 type Driver interface {
@@ -33,7 +39,7 @@ func NewNetworkDriver(driver string) (Driver, error) {
 	if driver == "" || driver == consts.DEFAULT_DRIVER {
 		d = newDefaultNetworkDriver()
 	} else {
-		return nil, fmt.Errorf(errFormat, errors.New("unsupported driver"))
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: unsupported driver %q", errdefs.ErrInvalidArgument, driver))
 	}
 	return d, nil
 }
@@ -129,6 +135,16 @@ func setupIPTables(bridgeName string, subnet *net.IPNet, action string) error {
 	}
 	return nil
 }
+
+// ensureIPTables MASQUERADE 规则不存在时重新添加
+func ensureIPTables(bridgeName string, subnet *net.IPNet) error {
+	cmdStr := fmt.Sprintf("-t nat -C POSTROUTING -s %s ! -o %s -j MASQUERADE", subnet.String(), bridgeName)
+	if err := exec.Command("iptables", strings.Split(cmdStr, " ")...).Run(); err == nil {
+		return nil
+	}
+	return setupIPTables(bridgeName, subnet, "add")
+}
+
 func (b *BridgeNetworkDriver) Delete(name string) error {
 	link, err := netlink.LinkByName(name)
 	if err != nil {
@@ -189,14 +205,20 @@ func (b *BridgeNetworkDriver) DelConnect(network *Network, endpoint *Endpoint) e
 		PeerName:  "cif-" + endpoint.ID[:5],
 	}
 	if err := netlink.LinkDel(&endpoint.Device); err != nil {
-		fmt.Println("[warn] bridge.DelConnect: ", err)
+		logrus.Warnln("bridge.DelConnect:", err)
 	}
 	return nil
 }
 
 func ConfigBridge(driverStr, bridgeName, subnetStr string) error {
 	errFormat := "configNetwork: %w"
-	_, err := net.InterfaceByName(bridgeName)
+	// 检查网桥是否存在到写入网络配置之间需要跨进程互斥，避免并发创建同一个网络
+	unlock, err := utils.LockFile(consts.PATH_NETWORK_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	_, err = net.InterfaceByName(bridgeName)
 	if err == nil {
 		return nil
 	}
@@ -208,7 +230,7 @@ func ConfigBridge(driverStr, bridgeName, subnetStr string) error {
 		return fmt.Errorf(errFormat, err)
 	}
 	if subnetStr == "" {
-		return fmt.Errorf(errFormat, errors.New("subnet is required"))
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: subnet is required", errdefs.ErrInvalidArgument))
 	}
 	ip, ipRange, _ := net.ParseCIDR(subnetStr)
 	ipRange.IP = ip
@@ -238,3 +260,59 @@ func ConfigBridge(driverStr, bridgeName, subnetStr string) error {
 
 	return nil
 }
+
+// CreateNetwork 创建网络，subnet 为空时从默认地址池中分配
+func CreateNetwork(driverStr, name, subnet string) error {
+	errFormat := "network.CreateNetwork: %w"
+	if name == "" {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: missing network name", errdefs.ErrInvalidArgument))
+	}
+	if subnet == "" {
+		sub, err := AllocateSubnet(config.Get().DefaultAddressPools)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		subnet = sub
+	}
+	if err := ConfigBridge(driverStr, name, subnet); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// RemoveNetwork 删除网桥、释放子网并删除网络配置，默认网络不能删除
+func RemoveNetwork(name string) error {
+	errFormat := "network.RemoveNetwork: %w"
+	if name == "" {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: missing network name", errdefs.ErrInvalidArgument))
+	}
+	if name == config.Get().DefaultNetwork.Name {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: couldn't remove default network", errdefs.ErrConflict))
+	}
+	unlock, err := utils.LockFile(consts.PATH_NETWORK_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	n := &Network{
+		Name: name,
+	}
+	if err := n.Load(); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf(errFormat, err)
+	}
+	driver, err := NewNetworkDriver(n.Driver)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	driver.Delete(n.Name)
+	if err := NewIPAM().ReleaseSubnet(n.Subnet); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.Remove(filepath.Join(consts.PATH_NETWORK_NETWORK, n.Name+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
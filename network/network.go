@@ -15,8 +15,10 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
+	"github.com/wlbyte/mydocker/config"
 	"github.com/wlbyte/mydocker/consts"
 	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/utils"
 )
 
 /*
@@ -47,7 +49,7 @@ func (n *Network) Dump() error {
 		return fmt.Errorf(errFormat, err)
 	}
 	filePath := filepath.Join(consts.PATH_NETWORK_NETWORK, n.Name+".json")
-	if err := os.WriteFile(filePath, bs, consts.MODE_0755); err != nil {
+	if err := utils.WriteFileAtomic(filePath, bs, consts.MODE_0755); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
 	return nil
@@ -82,7 +84,7 @@ func recordEndpointInfo(e *Endpoint) error {
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
-	if err := os.WriteFile(filepath.Join(curPath, e.ID+".json"), bs, consts.MODE_0755); err != nil {
+	if err := utils.WriteFileAtomic(filepath.Join(curPath, e.ID+".json"), bs, consts.MODE_0755); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
 	return nil
@@ -92,27 +94,52 @@ type IPAMer interface {
 	Allocate(subnet *net.IPNet) (ip net.IP, err error)
 	Release(subnet *net.IPNet, ipaddr net.IP) error
 	ReleaseSubnet(subnet string) error
+	Retain(subnet *net.IPNet, ips []net.IP) (released []net.IP, err error)
 }
 
 // IPAM 实现了 IPAMer 接口
+// wg 只能保证进程内互斥，跨进程（并发的 mydocker run）依赖运行时目录下的 flock 文件锁，
+// 每次修改前都在锁内重新加载 subnet.json，避免基于过期数据分配出相同的 IP
 type IPAM struct {
-	wg                  sync.Mutex
+	wg sync.Mutex
+	// SubnetAllocatorPath 为空时使用当前数据目录下的 subnet.json
 	SubnetAllocatorPath string
 	Subnets             map[string]*string
 }
 
 var ipAllocator = &IPAM{
-	wg:                  sync.Mutex{},
-	SubnetAllocatorPath: consts.PATH_IPAM_JSON,
+	wg: sync.Mutex{},
+}
+
+// lock 获取进程内锁和跨进程文件锁，返回的函数按相反顺序释放
+func (i *IPAM) lock() (func(), error) {
+	i.wg.Lock()
+	unlock, err := utils.LockFile(consts.PATH_IPAM_LOCK)
+	if err != nil {
+		i.wg.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		i.wg.Unlock()
+	}, nil
 }
 
 func NewIPAM() IPAMer {
 	return ipAllocator
 }
 
+// path 每次读写时重新取路径，数据目录在启动时才确定，测试中也会切换
+func (i *IPAM) path() string {
+	if i.SubnetAllocatorPath != "" {
+		return i.SubnetAllocatorPath
+	}
+	return consts.PATH_IPAM_JSON
+}
+
 func (i *IPAM) load() error {
 	errFormat := "ipam.Load: %w"
-	bs, err := os.ReadFile(i.SubnetAllocatorPath)
+	bs, err := os.ReadFile(i.path())
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
@@ -130,15 +157,20 @@ func (i *IPAM) dump() error {
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
-	return os.WriteFile(consts.PATH_IPAM_JSON, bs, consts.MODE_0755)
+	return utils.WriteFileAtomic(i.path(), bs, consts.MODE_0755)
 }
 
 func (i *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 	errFormat := "ipam.Allocate: %w"
-	i.wg.Lock()
-	defer i.wg.Unlock()
+	unlock, err := i.lock()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
 	i.Subnets = map[string]*string{}
-	i.load()
+	if err := i.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, err)
+	}
 	ones, total := subnet.Mask.Size()
 	if _, exist := i.Subnets[subnet.String()]; !exist {
 		newS := strings.Repeat("0", 1<<(total-ones))
@@ -165,14 +197,20 @@ func (i *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 
 func (i *IPAM) Release(subnet *net.IPNet, ip net.IP) error {
 	errFormat := "ipam.Release: %w"
-	i.wg.Lock()
-	defer i.wg.Unlock()
+	unlock, err := i.lock()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
 	subN := IPv42Uint(subnet.IP)
 	ipN := IPv42Uint(ip.To4())
 	n := ipN - subN
-	if i.Subnets == nil {
-		i.Subnets = map[string]*string{}
-		i.load()
+	i.Subnets = map[string]*string{}
+	if err := i.load(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if _, exist := i.Subnets[subnet.String()]; !exist {
+		return fmt.Errorf(errFormat, fmt.Errorf("subnet %s not allocated", subnet))
 	}
 	if err := SetChar(n, i.Subnets[subnet.String()], '0'); err != nil {
 		return fmt.Errorf(errFormat, err)
@@ -185,8 +223,12 @@ func (i *IPAM) Release(subnet *net.IPNet, ip net.IP) error {
 
 func (i *IPAM) ReleaseSubnet(subnet string) error {
 	errFormat := "ipam.ReleaseSubnet: %w"
-	i.wg.Lock()
-	defer i.wg.Unlock()
+	unlock, err := i.lock()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	i.Subnets = map[string]*string{}
 	if err := i.load(); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
@@ -197,13 +239,56 @@ func (i *IPAM) ReleaseSubnet(subnet string) error {
 	return nil
 }
 
+// Retain 只保留 ips 中的地址为已分配，其余已分配的地址全部释放，返回被释放的地址
+func (i *IPAM) Retain(subnet *net.IPNet, ips []net.IP) ([]net.IP, error) {
+	errFormat := "ipam.Retain: %w"
+	unlock, err := i.lock()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	i.Subnets = map[string]*string{}
+	if err := i.load(); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	bits, exist := i.Subnets[subnet.String()]
+	if !exist {
+		return nil, nil
+	}
+	keep := map[uint]bool{}
+	subN := IPv42Uint(subnet.IP.To4())
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil && subnet.Contains(ip4) {
+			keep[IPv42Uint(ip4)-subN] = true
+		}
+	}
+	var released []net.IP
+	for n := range *bits {
+		if (*bits)[n] != '1' || keep[uint(n)] {
+			continue
+		}
+		if err := SetChar(uint(n), bits, '0'); err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		released = append(released, Uint2IPv4(subN+uint(n)))
+	}
+	if len(released) == 0 {
+		return nil, nil
+	}
+	if err := i.dump(); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return released, nil
+}
+
 func Connect(c *container.Container) error {
 	errFormat := "network.Connect: %w"
 	// 初始化默认bridge网络
 	if c.Network == "host" {
 		return fmt.Errorf(errFormat, errors.New("unsupport host"))
-	} else if c.Network == "mydocker0" || c.Network == "" {
-		if err := ConfigBridge("", c.Network, "172.18.0.0/24"); err != nil {
+	} else if defaultNetwork := config.Get().DefaultNetwork; c.Network == defaultNetwork.Name || c.Network == "" {
+		c.Network = defaultNetwork.Name
+		if err := ConfigBridge("", c.Network, defaultNetwork.Subnet); err != nil {
 			return fmt.Errorf(errFormat, err)
 		}
 	} else {
@@ -211,6 +296,13 @@ func Connect(c *container.Container) error {
 			return fmt.Errorf(errFormat, err)
 		}
 	}
+	// 分配 IP 到写入 endpoint 之间持有网络锁，避免 reconcile 把刚分配、还没有 endpoint 的 IP 当成泄漏回收
+	unlock, err := utils.LockFile(consts.PATH_NETWORK_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+
 	// create veth
 	bridge := &BridgeNetworkDriver{}
 
@@ -226,10 +318,15 @@ func Connect(c *container.Container) error {
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
+	rb := &utils.Rollback{}
+	defer rb.Run()
 	ip, err := NewIPAM().Allocate(sub)
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
+	rb.Add("ip", func() error {
+		return NewIPAM().Release(sub, ip)
+	})
 
 	endpoint := &Endpoint{
 		ID:          c.Id + "-" + n.Name,
@@ -241,6 +338,9 @@ func Connect(c *container.Container) error {
 	if err := bridge.Connect(&n, endpoint); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
+	rb.Add("veth", func() error {
+		return bridge.DelConnect(&n, endpoint)
+	})
 
 	if err := configEndpointIpAddressAndRoute(endpoint, c); err != nil {
 		return fmt.Errorf(errFormat, err)
@@ -249,10 +349,14 @@ func Connect(c *container.Container) error {
 	if err := configPortMapping(endpoint, c, "add"); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
+	rb.Add("port mapping", func() error {
+		return configPortMapping(endpoint, c, "del")
+	})
 
 	if err := recordEndpointInfo(endpoint); err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
+	rb.Commit()
 
 	return nil
 }
@@ -313,7 +417,11 @@ func configEndpointIpAddressAndRoute(e *Endpoint, c *container.Container) error
 	if err != nil {
 		return fmt.Errorf(errFormat, err)
 	}
-	defer enterContainerNetns(&l, c)()
+	exitNetns, err := enterContainerNetns(&l, c)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer exitNetns()
 
 	_, ipNet, err := net.ParseCIDR(e.Network.Subnet)
 	if err != nil {
@@ -358,7 +466,7 @@ func configPortMapping(ep *Endpoint, cinfo *container.Container, action string)
 		if action == "del" {
 			operation = "-D"
 		}
-		iptablesCmd := fmt.Sprintf("-t nat % PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
 			operation, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		//err := cmd.Run()
@@ -371,36 +479,33 @@ func configPortMapping(ep *Endpoint, cinfo *container.Container, action string)
 	return nil
 }
 
-func enterContainerNetns(enLink *netlink.Link, cinfo *container.Container) func() {
-	errFormat := "enterContainer: %s"
+func enterContainerNetns(enLink *netlink.Link, cinfo *container.Container) (func(), error) {
+	errFormat := "enterContainerNetns: %w"
 	f, err := os.OpenFile(fmt.Sprintf("/proc/%d/ns/net", cinfo.Pid), os.O_RDONLY, 0)
 	if err != nil {
-		fmt.Printf(errFormat, err)
-		f.Close()
-		return nil
+		return nil, fmt.Errorf(errFormat, err)
 	}
 
 	nsFD := f.Fd()
 	runtime.LockOSThread()
 	origns, err := netns.Get()
 	if err != nil {
-		fmt.Printf(errFormat, err)
 		runtime.UnlockOSThread()
-		origns.Close()
-		return nil
+		f.Close()
+		return nil, fmt.Errorf(errFormat, err)
 	}
 
 	if err = netlink.LinkSetNsFd(*enLink, int(nsFD)); err != nil {
-		fmt.Printf(errFormat, err)
 		runtime.UnlockOSThread()
 		origns.Close()
-		return nil
+		f.Close()
+		return nil, fmt.Errorf(errFormat, err)
 	}
 	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
-		fmt.Printf(errFormat, err)
 		runtime.UnlockOSThread()
 		origns.Close()
-		return nil
+		f.Close()
+		return nil, fmt.Errorf(errFormat, err)
 	}
 
 	return func() {
@@ -408,5 +513,58 @@ func enterContainerNetns(enLink *netlink.Link, cinfo *container.Container) func(
 		origns.Close()
 		runtime.UnlockOSThread()
 		f.Close()
+	}, nil
+}
+
+// AllocateSubnet 从地址池中按顺序找出第一个与已有网络（包括默认网络）都不重叠的子网
+func AllocateSubnet(pools []config.AddressPool) (string, error) {
+	errFormat := "network.AllocateSubnet: %w"
+	var used []*net.IPNet
+	if _, sub, err := net.ParseCIDR(config.Get().DefaultNetwork.Subnet); err == nil {
+		used = append(used, sub)
+	}
+	entries, err := os.ReadDir(consts.PATH_NETWORK_NETWORK)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf(errFormat, err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		n := &Network{Name: strings.TrimSuffix(entry.Name(), ".json")}
+		if err := n.Load(); err != nil {
+			continue
+		}
+		if _, sub, err := net.ParseCIDR(n.Subnet); err == nil {
+			used = append(used, sub)
+		}
+	}
+	for _, pool := range pools {
+		_, base, err := net.ParseCIDR(pool.Base)
+		if err != nil {
+			return "", fmt.Errorf(errFormat, err)
+		}
+		ones, _ := base.Mask.Size()
+		start := IPv42Uint(base.IP.To4())
+		step := uint(1) << (32 - pool.Size)
+		for i := uint(0); i < uint(1)<<(pool.Size-ones); i++ {
+			candidate := &net.IPNet{
+				IP:   Uint2IPv4(start + i*step),
+				Mask: net.CIDRMask(pool.Size, 32),
+			}
+			if !overlaps(candidate, used) {
+				return candidate.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf(errFormat, errors.New("no available subnet in default address pools"))
+}
+
+func overlaps(sub *net.IPNet, used []*net.IPNet) bool {
+	for _, u := range used {
+		if u.Contains(sub.IP) || sub.Contains(u.IP) {
+			return true
+		}
 	}
+	return false
 }
@@ -2,9 +2,11 @@ package network
 
 import (
 	"net"
+	"os"
 	"testing"
 
 	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/internal/testutil"
 )
 
 func TestAllocate(t *testing.T) {
@@ -85,3 +87,23 @@ func TestBridgeDisConnect(t *testing.T) {
 	}
 	t.Logf("bridge.Connect %s", ep.ID)
 }
+
+// 切换数据目录后 IPAM 读写新目录下的 subnet.json
+func TestIPAMFollowsRoot(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.9.0.0/24")
+	var ips []string
+	for i := 0; i < 2; i++ {
+		testutil.SetTestRoot(t)
+		ip, err := NewIPAM().Allocate(subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(consts.PATH_IPAM_JSON); err != nil {
+			t.Errorf("subnet.json was not written under the current root: %v", err)
+		}
+		ips = append(ips, ip.String())
+	}
+	if ips[0] != ips[1] {
+		t.Errorf("Allocate() in a fresh root = %s, want %s", ips[1], ips[0])
+	}
+}
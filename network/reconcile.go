@@ -0,0 +1,317 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+/*
+宿主机重启或容器进程异常退出后，持久化的网络状态会和实际状态不一致：
+1. 网桥和 MASQUERADE 规则随重启消失，但网络配置还在
+2. 已经退出的容器的 endpoint 文件、IP 分配和 DNAT 规则仍然存在
+3. 写入 endpoint 之前崩溃，IP 只在 subnet.json 中被标记为已分配
+4. 宿主机侧的 veth 和 DNAT 规则失去了对应的 endpoint
+Reconcile 以 running 中的容器为准修复这些状态，full 为 false 时只做开销较小的 1、2 两步
+*/
+
+// Reconcile 修复网络状态，running 为仍在运行的容器 ID 集合
+func Reconcile(running map[string]bool, full bool) error {
+	errFormat := "network.Reconcile: %w"
+	unlock, err := utils.LockFile(consts.PATH_NETWORK_LOCK)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+
+	var errs []error
+	networks, err := ListNetworks()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	for _, n := range networks {
+		if err := restoreNetwork(n, full); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	endpoints, err := ListEndpoints()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	var active []*Endpoint
+	for _, e := range endpoints {
+		if e.Network != nil && running[strings.TrimSuffix(e.ID, "-"+e.Network.Name)] {
+			active = append(active, e)
+			continue
+		}
+		logrus.Infof("reconcile: release endpoint %s", e.ID)
+		if err := releaseEndpoint(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !full {
+		return errors.Join(errs...)
+	}
+
+	for _, n := range networks {
+		if err := sweepIPs(n, active); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := sweepVeths(networks, active); err != nil {
+		errs = append(errs, err)
+	}
+	if err := sweepPortMappings(networks, active); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func ListNetworks() ([]*Network, error) {
+	errFormat := "network.ListNetworks: %w"
+	entries, err := os.ReadDir(consts.PATH_NETWORK_NETWORK)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var networks []*Network
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		n := &Network{Name: strings.TrimSuffix(entry.Name(), ".json")}
+		if err := n.Load(); err != nil {
+			logrus.Warnln("network.ListNetworks:", err)
+			continue
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+func ListEndpoints() ([]*Endpoint, error) {
+	errFormat := "network.ListEndpoints: %w"
+	entries, err := os.ReadDir(consts.PATH_NETWORK_ENDPOINT)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var endpoints []*Endpoint
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		bs, err := os.ReadFile(filepath.Join(consts.PATH_NETWORK_ENDPOINT, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		e := &Endpoint{}
+		if err := json.Unmarshal(bs, e); err != nil {
+			logrus.Warnln("network.ListEndpoints:", entry.Name(), err)
+			continue
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// RemoveEndpoint 删除 endpoint 的持久化文件，文件不存在时不报错
+func RemoveEndpoint(e *Endpoint) error {
+	if err := os.Remove(filepath.Join(consts.PATH_NETWORK_ENDPOINT, e.ID+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("network.RemoveEndpoint: %w", err)
+	}
+	return nil
+}
+
+// restoreNetwork 网桥不存在时重新创建网桥和 NAT 规则，full 模式下还会补齐缺失的 MASQUERADE 规则
+func restoreNetwork(n *Network, full bool) error {
+	errFormat := "restoreNetwork %s: %w"
+	_, sub, err := net.ParseCIDR(n.Subnet)
+	if err != nil {
+		return fmt.Errorf(errFormat, n.Name, err)
+	}
+	if _, err := netlink.LinkByName(n.Name); err == nil {
+		if full {
+			if err := ensureIPTables(n.Name, sub); err != nil {
+				return fmt.Errorf(errFormat, n.Name, err)
+			}
+		}
+		return nil
+	}
+	logrus.Infof("reconcile: recreate bridge %s", n.Name)
+	driver, err := NewNetworkDriver(n.Driver)
+	if err != nil {
+		return fmt.Errorf(errFormat, n.Name, err)
+	}
+	if err := driver.Create(n.Subnet, n.Name); err != nil {
+		return fmt.Errorf(errFormat, n.Name, err)
+	}
+	return nil
+}
+
+// releaseEndpoint 删除 veth、释放 IP、删除 DNAT 规则和 endpoint 文件
+func releaseEndpoint(e *Endpoint) error {
+	errFormat := "releaseEndpoint %s: %w"
+	if e.Network == nil {
+		return RemoveEndpoint(e)
+	}
+	c := &container.Container{
+		Id:      strings.TrimSuffix(e.ID, "-"+e.Network.Name),
+		Network: e.Network.Name,
+	}
+	if err := DelConnect(c, e); err != nil {
+		return fmt.Errorf(errFormat, e.ID, err)
+	}
+	return RemoveEndpoint(e)
+}
+
+// sweepIPs 释放网络中既不是网关、也不属于任何 endpoint 的已分配 IP
+func sweepIPs(n *Network, active []*Endpoint) error {
+	errFormat := "sweepIPs %s: %w"
+	_, sub, err := net.ParseCIDR(n.Subnet)
+	if err != nil {
+		return fmt.Errorf(errFormat, n.Name, err)
+	}
+	keep := []net.IP{net.ParseIP(n.Gateway)}
+	for _, e := range active {
+		if e.Network != nil && e.Network.Name == n.Name {
+			keep = append(keep, e.IPAddress)
+		}
+	}
+	released, err := NewIPAM().Retain(sub, keep)
+	if err != nil {
+		return fmt.Errorf(errFormat, n.Name, err)
+	}
+	for _, ip := range released {
+		logrus.Infof("reconcile: release ip %s of network %s", ip, n.Name)
+	}
+	return nil
+}
+
+// sweepVeths 删除挂在 mydocker 网桥上但没有对应 endpoint 的 veth
+func sweepVeths(networks []*Network, active []*Endpoint) error {
+	errFormat := "sweepVeths: %w"
+	bridges := map[int]bool{}
+	for _, n := range networks {
+		if l, err := netlink.LinkByName(n.Name); err == nil {
+			bridges[l.Attrs().Index] = true
+		}
+	}
+	names := map[string]bool{}
+	for _, e := range active {
+		if len(e.ID) >= 5 {
+			names[e.ID[:5]] = true
+		}
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	var errs []error
+	for _, l := range links {
+		if l.Type() != "veth" || !bridges[l.Attrs().MasterIndex] || names[l.Attrs().Name] {
+			continue
+		}
+		logrus.Infof("reconcile: delete veth %s", l.Attrs().Name)
+		if err := netlink.LinkDel(l); err != nil {
+			errs = append(errs, fmt.Errorf(errFormat, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweepPortMappings 删除目标地址属于 mydocker 网络、但没有对应 endpoint 的 DNAT 规则
+func sweepPortMappings(networks []*Network, active []*Endpoint) error {
+	errFormat := "sweepPortMappings: %w"
+	var subnets []*net.IPNet
+	for _, n := range networks {
+		if _, sub, err := net.ParseCIDR(n.Subnet); err == nil {
+			subnets = append(subnets, sub)
+		}
+	}
+	if len(subnets) == 0 {
+		return nil
+	}
+	destinations := map[string]bool{}
+	for _, e := range active {
+		for _, pm := range e.PortMapping {
+			ports := strings.Split(pm, ":")
+			if len(ports) == 2 {
+				destinations[e.IPAddress.String()+":"+ports[1]] = true
+			}
+		}
+	}
+	output, err := exec.Command("iptables", "-t", "nat", "-S", "PREROUTING").Output()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	var errs []error
+	for _, rule := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		dst := ""
+		for i, f := range fields {
+			if f == "--to-destination" && i+1 < len(fields) {
+				dst = fields[i+1]
+			}
+		}
+		host, _, err := net.SplitHostPort(dst)
+		if err != nil || destinations[dst] || !overlaps(&net.IPNet{IP: net.ParseIP(host), Mask: net.CIDRMask(32, 32)}, subnets) {
+			continue
+		}
+		logrus.Infof("reconcile: delete port mapping %s", rule)
+		fields[0] = "-D"
+		args := append([]string{"-t", "nat"}, fields...)
+		if output, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf(errFormat, errors.New(string(output))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SubnetUsage IPAM 中一个子网的地址使用情况，Capacity 不包括网络地址和广播地址
+type SubnetUsage struct {
+	Subnet    string
+	Allocated int
+	Capacity  int
+}
+
+// IPAMUsage 返回所有子网的地址使用情况
+func IPAMUsage() ([]SubnetUsage, error) {
+	errFormat := "network.IPAMUsage: %w"
+	ipam := NewIPAM().(*IPAM)
+	unlock, err := ipam.lock()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	ipam.Subnets = map[string]*string{}
+	if err := ipam.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var usage []SubnetUsage
+	for subnet, bits := range ipam.Subnets {
+		u := SubnetUsage{Subnet: subnet, Capacity: max(len(*bits)-2, 0)}
+		for i := 1; i < len(*bits)-1; i++ {
+			if (*bits)[i] == '1' {
+				u.Allocated++
+			}
+		}
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Subnet < usage[j].Subnet })
+	return usage, nil
+}
@@ -12,24 +12,30 @@ package nsenter
 
 __attribute__((constructor)) void enter_namespace(void) {
    // 这里的代码会在Go运行时启动前执行，它会在单线程的C上下文中运行
+	// 调试信息只在 --log-level debug 时输出，并且写到 stderr，不能混进命令的标准输出
+	char *log_level = getenv("MYDOCKER_LOG_LEVEL");
+	int debug = log_level && strcmp(log_level, "debug") == 0;
 	char *mydocker_pid;
 	mydocker_pid = getenv("mydocker_pid");
-	if (mydocker_pid) {
-		fprintf(stdout, "[debug] got mydocker_pid=%s\n", mydocker_pid);
-	} else {
-		fprintf(stdout, "[debug] missing mydocker_pid env skip nsenter");
+	if (!mydocker_pid) {
 		// 如果没有指定PID就不需要继续执行，直接退出
 		return;
 	}
+	if (debug) {
+		fprintf(stderr, "[debug] got mydocker_pid=%s\n", mydocker_pid);
+	}
 	char *mydocker_cmd;
 	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		fprintf(stdout, "[debug] got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		fprintf(stdout, "[debug] missing mydocker_cmd env skip nsenter");
+	if (!mydocker_cmd) {
 		// 如果没有指定命令也是直接退出
+		if (debug) {
+			fprintf(stderr, "[debug] missing mydocker_cmd env skip nsenter\n");
+		}
 		return;
 	}
+	if (debug) {
+		fprintf(stderr, "[debug] got mydocker_cmd=%s\n", mydocker_cmd);
+	}
 	int i;
 	char nspath[1024];
 	// 需要进入的5种namespace
@@ -41,9 +47,9 @@ __attribute__((constructor)) void enter_namespace(void) {
 		int fd = open(nspath, O_RDONLY);
 		// 执行setns系统调用，进入对应namespace
 		if (setns(fd, 0) == -1) {
-			fprintf(stderr, "[debug] setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			fprintf(stdout, "[debug]setns on %s namespace succeeded\n", namespaces[i]);
+			fprintf(stderr, "[error] setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
+		} else if (debug) {
+			fprintf(stderr, "[debug] setns on %s namespace succeeded\n", namespaces[i]);
 		}
 		close(fd);
 	}
@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// authFile 登录凭据，格式和 Docker config.json 中的 auths 相同，auth 为 base64(username:password)
+type authFile struct {
+	Auths map[string]authEntry `json:"auths"`
+}
+
+type authEntry struct {
+	Auth string `json:"auth"`
+}
+
+func loadAuthFile() (*authFile, error) {
+	f := &authFile{Auths: map[string]authEntry{}}
+	bs, err := os.ReadFile(consts.PATH_REGISTRY_AUTH)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, f); err != nil {
+		return nil, err
+	}
+	if f.Auths == nil {
+		f.Auths = map[string]authEntry{}
+	}
+	return f, nil
+}
+
+func (f *authFile) save() error {
+	bs, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(consts.PATH_HOME, consts.MODE_0755); err != nil {
+		return err
+	}
+	// 文件中是明文密码，只有 root 可以读取
+	return utils.WriteFileAtomic(consts.PATH_REGISTRY_AUTH, bs, 0600)
+}
+
+// credentials 返回 domain 保存的凭据
+func credentials(domain string) (username, password string, ok bool) {
+	f, err := loadAuthFile()
+	if err != nil {
+		return "", "", false
+	}
+	e, ok := f.Auths[domain]
+	if !ok {
+		return "", "", false
+	}
+	bs, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(bs), ":")
+}
+
+// Login 用凭据访问仓库的 /v2/ 检查凭据是否有效，有效时保存，之后访问 domain 时使用。
+// domain 为空表示 Docker Hub。仓库允许匿名访问时无法确认凭据是否有效，同样保存
+func Login(domain, username, password string, opts Options) error {
+	errFormat := "registry.Login: %w"
+	c := newClient(domain, opts)
+	c.username, c.password = username, password
+	resp, err := c.do(http.MethodGet, "/v2/", nil, nil, "")
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(errFormat, responseError(resp, c.domain))
+	}
+	resp.Body.Close()
+	f, err := loadAuthFile()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	f.Auths[c.domain] = authEntry{Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password))}
+	if err := f.save(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// Logout 删除 domain 保存的凭据
+func Logout(domain string) error {
+	errFormat := "registry.Logout: %w"
+	if domain == "" {
+		domain = DEFAULT_DOMAIN
+	}
+	f, err := loadAuthFile()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if _, ok := f.Auths[domain]; !ok {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: not logged in to %s", errdefs.ErrNotFound, domain))
+	}
+	delete(f.Auths, domain)
+	if err := f.save(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+// Package registry 按 OCI distribution 规范（Docker Registry HTTP API V2）从镜像仓库拉取和推送镜像。
+//
+// 支持匿名访问、basic 认证和 bearer token 认证，登录凭据由 Login 保存。
+// pull 并行下载层，中断的下载保留在镜像目录中，下次 pull 时从中断的位置继续
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/wlbyte/mydocker/config"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+)
+
+const (
+	// DEFAULT_DOMAIN 引用中没有仓库地址时使用 Docker Hub
+	DEFAULT_DOMAIN = "docker.io"
+	DEFAULT_HOST   = "registry-1.docker.io"
+)
+
+// ErrUnauthorized 仓库拒绝了请求，需要登录或者凭据没有权限
+var ErrUnauthorized = errors.New("unauthorized")
+
+// 拉取清单时接受的格式
+var manifestTypes = []string{
+	image.MEDIA_TYPE_MANIFEST,
+	image.MEDIA_TYPE_INDEX,
+	image.MEDIA_TYPE_DOCKER_MANIFEST,
+	image.MEDIA_TYPE_DOCKER_MANIFEST_LIST,
+}
+
+// Options pull、push 和 login 的参数
+type Options struct {
+	// HTTPClient 为空时使用 http.DefaultClient
+	HTTPClient *http.Client
+	// Output 接收进度信息
+	Output io.Writer
+}
+
+type client struct {
+	domain string
+	// base 仓库 API 的地址 scheme://host
+	base     string
+	http     *http.Client
+	username string
+	password string
+
+	mu sync.Mutex
+	// basic 仓库要求 basic 认证
+	basic bool
+	// tokens bearer token，按 scope 缓存
+	tokens map[string]string
+}
+
+func newClient(domain string, opts Options) *client {
+	if domain == "" {
+		domain = DEFAULT_DOMAIN
+	}
+	host := domain
+	if domain == DEFAULT_DOMAIN {
+		host = DEFAULT_HOST
+	}
+	scheme := "https"
+	if isInsecure(domain) {
+		scheme = "http"
+	}
+	c := &client{
+		domain: domain,
+		base:   scheme + "://" + host,
+		http:   opts.HTTPClient,
+		tokens: map[string]string{},
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	c.username, c.password, _ = credentials(domain)
+	return c
+}
+
+// isInsecure 本机地址和配置在 insecureRegistries 中的仓库使用 http
+func isInsecure(domain string) bool {
+	for _, r := range config.Get().InsecureRegistries {
+		if r == domain {
+			return true
+		}
+	}
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// repository 仓库中的镜像名称，Docker Hub 的官方镜像在 library 下
+func repository(r image.Reference) string {
+	if (r.Domain == "" || r.Domain == DEFAULT_DOMAIN) && !strings.Contains(r.Path, "/") {
+		return "library/" + r.Path
+	}
+	return r.Path
+}
+
+func pullScope(repo string) string {
+	return "repository:" + repo + ":pull"
+}
+
+func pushScope(repo string) string {
+	return "repository:" + repo + ":pull,push"
+}
+
+// do 发送请求，收到 401 时按 WWW-Authenticate 完成认证后重试一次。
+// rawURL 可以是相对于 base 的路径，也可以是仓库返回的完整地址
+func (c *client) do(method, rawURL string, header http.Header, body []byte, scope string) (*http.Response, error) {
+	u, err := url.Parse(c.base)
+	if err != nil {
+		return nil, err
+	}
+	if u, err = u.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, u.String(), r)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		c.authorize(req, scope)
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// 其他主机的 401 不处理，否则凭据会发给它指定的 realm
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || !c.ownURL(req.URL) {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge, scope); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authorize 给发往仓库自身的请求加上凭据。上传时仓库返回的 Location 可以是其他主机的完整地址，
+// 如对象存储，这些请求不能带上 token 或密码
+func (c *client) authorize(req *http.Request, scope string) {
+	if !c.ownURL(req.URL) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token, ok := c.tokens[scope]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basic {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// ownURL 判断 u 是否指向仓库本身，scheme 也要相同，避免凭据通过 http 明文发送
+func (c *client) ownURL(u *url.URL) bool {
+	return u.Scheme+"://"+u.Host == c.base
+}
+
+// authenticate 处理仓库的认证要求：basic 认证直接使用凭据，
+// bearer 认证向 realm 申请 scope 的 token，有凭据时用 basic 认证申请
+func (c *client) authenticate(challenge, scope string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("%w: %s requires login", ErrUnauthorized, c.domain)
+		}
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || realm.Host == "" {
+			return fmt.Errorf("%w: bad bearer realm %q", ErrUnauthorized, params["realm"])
+		}
+		q := realm.Query()
+		if params["service"] != "" {
+			q.Set("service", params["service"])
+		}
+		if scope != "" {
+			q.Set("scope", scope)
+		}
+		realm.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: token request to %s: %s", ErrUnauthorized, realm.Host, resp.Status)
+		}
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("token response from %s: %w", realm.Host, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		if token.Token == "" {
+			return fmt.Errorf("%w: empty token from %s", ErrUnauthorized, realm.Host)
+		}
+		c.mu.Lock()
+		c.tokens[scope] = token.Token
+		c.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported authentication %q from %s", ErrUnauthorized, scheme, c.domain)
+	}
+}
+
+// parseChallenge 解析 WWW-Authenticate：scheme key="value",key="value"
+func parseChallenge(s string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// responseError 把仓库的错误响应转换成错误，读取并关闭响应体
+func responseError(resp *http.Response, what string) error {
+	defer resp.Body.Close()
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	msg := resp.Status
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(bs, &body) == nil && len(body.Errors) > 0 {
+		msg = body.Errors[0].Code + ": " + body.Errors[0].Message
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s: %s", errdefs.ErrNotFound, what, msg)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s: %s", ErrUnauthorized, what, msg)
+	default:
+		return fmt.Errorf("%s: %s", what, msg)
+	}
+}
@@ -0,0 +1,297 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"sync"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+	"golang.org/x/sys/unix"
+)
+
+// MAX_CONCURRENT_DOWNLOADS pull 同时下载的 blob 数
+const MAX_CONCURRENT_DOWNLOADS = 3
+
+// MAX_MANIFEST_SIZE 清单和索引的大小上限
+const MAX_MANIFEST_SIZE = 4 << 20
+
+// Pull 从仓库拉取镜像并以引用 name 登记。name 带摘要时按摘要拉取并校验清单，
+// 此时还需要带标签作为本地的名称，比如 app:1@sha256:...
+func Pull(name string, opts Options) (*image.Image, error) {
+	errFormat := "registry.Pull %s: %w"
+	r, err := image.ParseReference(name)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if r.Tag == "" {
+		return nil, fmt.Errorf(errFormat, name, fmt.Errorf("%w: pulling by digest requires a tag to name the image, eg: app:1@%s", errdefs.ErrInvalidArgument, r.Digest))
+	}
+	local := image.Reference{Domain: r.Domain, Path: r.Path, Tag: r.Tag}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	c := newClient(r.Domain, opts)
+	repo := repository(r)
+	ref := r.Tag
+	if r.Digest != "" {
+		ref = r.Digest
+	}
+
+	if err := os.MkdirAll(consts.PATH_IMAGE, consts.MODE_0755); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	// 下载的 blob 在登记镜像之前不能被 rmi 回收
+	release, err := image.Lease()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	defer release()
+
+	fmt.Fprintf(opts.Output, "%s: Pulling from %s\n", ref, repo)
+	bs, mediaType, digest, err := c.getManifest(repo, ref)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if r.Digest != "" && digest != r.Digest {
+		return nil, fmt.Errorf(errFormat, name, fmt.Errorf("%w: manifest has digest %s, want %s", image.ErrCorrupt, digest, r.Digest))
+	}
+	if mediaType == image.MEDIA_TYPE_INDEX || mediaType == image.MEDIA_TYPE_DOCKER_MANIFEST_LIST {
+		d, err := selectPlatform(bs)
+		if err != nil {
+			return nil, fmt.Errorf(errFormat, name, err)
+		}
+		if bs, _, digest, err = c.getManifest(repo, d.Digest); err != nil {
+			return nil, fmt.Errorf(errFormat, name, err)
+		}
+		if digest != d.Digest {
+			return nil, fmt.Errorf(errFormat, name, fmt.Errorf("%w: manifest has digest %s, want %s", image.ErrCorrupt, digest, d.Digest))
+		}
+	}
+	var m image.Manifest
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if m.Config.Digest == "" {
+		return nil, fmt.Errorf(errFormat, name, fmt.Errorf("%w: unsupported manifest %s", errdefs.ErrInvalidArgument, mediaType))
+	}
+
+	if err := c.fetchBlobs(repo, append([]image.Descriptor{m.Config}, m.Layers...), opts.Output); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	cfg, err := image.LoadConfig(m.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if err := image.CheckRootFS(cfg, len(m.Layers)); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if _, _, err := image.WriteBlob(bytes.NewReader(bs)); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	if err := image.Tag(digest, local.String()); err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	img, err := image.Get(local.String())
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, name, err)
+	}
+	fmt.Fprintf(opts.Output, "Digest: %s\n", digest)
+	fmt.Fprintf(opts.Output, "Status: Downloaded image for %s\n", local.String())
+	return img, nil
+}
+
+// getManifest 下载清单，返回内容、媒体类型和内容的摘要
+func (c *client) getManifest(repo, ref string) ([]byte, string, string, error) {
+	header := http.Header{"Accept": {strings.Join(manifestTypes, ", ")}}
+	resp, err := c.do(http.MethodGet, "/v2/"+repo+"/manifests/"+ref, header, nil, pullScope(repo))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", responseError(resp, "manifest "+repo+":"+ref)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, MAX_MANIFEST_SIZE+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(bs) > MAX_MANIFEST_SIZE {
+		return nil, "", "", fmt.Errorf("%w: manifest %s:%s is too large", errdefs.ErrInvalidArgument, repo, ref)
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	// 清单中记录的类型比响应头可靠
+	if json.Unmarshal(bs, &probe) == nil && probe.MediaType != "" {
+		mediaType = probe.MediaType
+	}
+	sum := sha256.Sum256(bs)
+	return bs, mediaType, "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// selectPlatform 在多平台镜像的索引中选择当前平台的清单
+func selectPlatform(bs []byte) (image.Descriptor, error) {
+	var idx image.Index
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return image.Descriptor{}, err
+	}
+	for _, d := range idx.Manifests {
+		if d.Platform != nil && d.Platform.OS == "linux" && d.Platform.Architecture == goruntime.GOARCH {
+			return d, nil
+		}
+	}
+	return image.Descriptor{}, fmt.Errorf("%w: no manifest for linux/%s", errdefs.ErrNotFound, goruntime.GOARCH)
+}
+
+// fetchBlobs 并行下载 blobs，返回遇到的第一个错误
+func (c *client) fetchBlobs(repo string, blobs []image.Descriptor, out io.Writer) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, MAX_CONCURRENT_DOWNLOADS)
+	progress := func(d image.Descriptor, status string) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(out, "%s: %s\n", shortDigest(d.Digest), status)
+	}
+	for _, d := range blobs {
+		wg.Add(1)
+		go func(d image.Descriptor) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := c.fetchBlob(repo, d, progress); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// fetchBlob 下载一个 blob 到存储。下载中的内容保存在镜像目录的 .partial-<hex> 中，
+// 已经存在时用 Range 请求从中断的位置继续，仓库不支持时重新下载
+func (c *client) fetchBlob(repo string, d image.Descriptor, progress func(image.Descriptor, string)) error {
+	if err := image.ValidateDigest(d.Digest); err != nil {
+		return err
+	}
+	if image.BlobExists(d.Digest) {
+		progress(d, "Already exists")
+		return nil
+	}
+	partial := filepath.Join(consts.PATH_IMAGE, ".partial-"+strings.TrimPrefix(d.Digest, "sha256:"))
+	// 同时拉取包含相同层的镜像时，等待另一个下载完成
+	f, err := lockPartial(partial)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if image.BlobExists(d.Digest) {
+		progress(d, "Already exists")
+		return nil
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(http.MethodGet, "/v2/"+repo+"/blobs/"+d.Digest, header, nil, pullScope(repo))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		progress(d, fmt.Sprintf("Resuming download at %d bytes", offset))
+	case resp.StatusCode == http.StatusOK:
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// 之前已经下载完整，只是还没有写入存储
+	default:
+		return responseError(resp, "blob "+d.Digest)
+	}
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return fmt.Errorf("download %s: %w", d.Digest, err)
+		}
+	}
+	// 写入存储并删除 partial 之后才关闭文件释放锁
+	if err := storeBlob(f, partial, d.Digest); err != nil {
+		return err
+	}
+	progress(d, "Download complete")
+	return nil
+}
+
+// lockPartial 打开并锁定下载中的文件。等到锁时文件可能已经被之前的下载写入存储并删除，
+// 这时锁住的是已经删除的文件，重新打开
+func lockPartial(partial string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+			f.Close()
+			return nil, err
+		}
+		var st unix.Stat_t
+		if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if st.Nlink > 0 {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// storeBlob 把下载完成的文件 f 写入存储并删除 partial，内容和摘要不一致时同样删除，下次重新下载。
+// 调用方持有 f 上的锁
+func storeBlob(f *os.File, partial, want string) error {
+	defer os.Remove(partial)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digest, _, err := image.WriteBlob(f)
+	if err != nil {
+		return err
+	}
+	if digest != want {
+		return fmt.Errorf("%w: downloaded %s has digest %s", image.ErrCorrupt, want, digest)
+	}
+	return nil
+}
+
+func shortDigest(digest string) string {
+	hexPart := strings.TrimPrefix(digest, "sha256:")
+	if len(hexPart) > 12 {
+		return hexPart[:12]
+	}
+	return hexPart
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/wlbyte/mydocker/image"
+)
+
+// uploadChunkSize 分块上传时每个 PATCH 请求的大小
+var uploadChunkSize = 5 << 20
+
+// Push 把本地镜像 name 推送到引用中的仓库，仓库中已经存在的 blob 不再上传
+func Push(name string, opts Options) error {
+	errFormat := "registry.Push %s: %w"
+	r, err := image.ParseTag(name)
+	if err != nil {
+		return fmt.Errorf(errFormat, name, err)
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	// 上传期间 blob 不能被 rmi 回收
+	release, err := image.Lease()
+	if err != nil {
+		return fmt.Errorf(errFormat, name, err)
+	}
+	defer release()
+	img, m, _, err := image.Load(name)
+	if err != nil {
+		return fmt.Errorf(errFormat, name, err)
+	}
+	bs, err := image.ReadBlob(img.Digest)
+	if err != nil {
+		return fmt.Errorf(errFormat, name, err)
+	}
+
+	c := newClient(r.Domain, opts)
+	repo := repository(r)
+	fmt.Fprintf(opts.Output, "The push refers to repository [%s/%s]\n", c.domain, repo)
+	for _, d := range append(append([]image.Descriptor{}, m.Layers...), m.Config) {
+		exists, err := c.blobExists(repo, d.Digest)
+		if err != nil {
+			return fmt.Errorf(errFormat, name, err)
+		}
+		if exists {
+			fmt.Fprintf(opts.Output, "%s: Layer already exists\n", shortDigest(d.Digest))
+			continue
+		}
+		if err := c.uploadBlob(repo, d); err != nil {
+			return fmt.Errorf(errFormat, name, err)
+		}
+		fmt.Fprintf(opts.Output, "%s: Pushed\n", shortDigest(d.Digest))
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = image.MEDIA_TYPE_MANIFEST
+	}
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(http.MethodPut, "/v2/"+repo+"/manifests/"+r.Tag, header, bs, pushScope(repo))
+	if err != nil {
+		return fmt.Errorf(errFormat, name, err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf(errFormat, name, responseError(resp, "manifest "+repo+":"+r.Tag))
+	}
+	resp.Body.Close()
+	fmt.Fprintf(opts.Output, "%s: digest: %s size: %d\n", r.Tag, img.Digest, len(bs))
+	return nil
+}
+
+func (c *client) blobExists(repo, digest string) (bool, error) {
+	resp, err := c.do(http.MethodHead, "/v2/"+repo+"/blobs/"+digest, nil, nil, pushScope(repo))
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		resp.Body.Close()
+		return true, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return false, nil
+	default:
+		return false, responseError(resp, "blob "+digest)
+	}
+}
+
+// uploadBlob 分块上传：POST 开始上传，每块用 PATCH 发送，最后用带摘要的 PUT 完成。
+// 每个响应的 Location 是下一个请求的地址
+func (c *client) uploadBlob(repo string, d image.Descriptor) error {
+	resp, err := c.do(http.MethodPost, "/v2/"+repo+"/blobs/uploads/", nil, nil, pushScope(repo))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp, "start upload of "+d.Digest)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+
+	f, err := image.OpenBlob(d.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, uploadChunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {fmt.Sprintf("%d-%d", offset, offset+int64(n)-1)},
+		}
+		resp, err := c.do(http.MethodPatch, location, header, buf[:n], pushScope(repo))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			return responseError(resp, "upload "+d.Digest)
+		}
+		resp.Body.Close()
+		location = resp.Header.Get("Location")
+		offset += int64(n)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("digest", d.Digest)
+	u.RawQuery = q.Encode()
+	resp, err = c.do(http.MethodPut, u.String(), nil, nil, pushScope(repo))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "finish upload of "+d.Digest)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,332 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+// fakeRegistry 内存中的镜像仓库，使用 bearer token 认证
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   map[string][]byte
+	// abort 第一次下载这个 blob 时只返回一半内容就断开连接
+	abort  string
+	ranges int
+	srv    *httptest.Server
+	// external 为 true 时上传地址是 storage 的完整地址，模拟把上传交给对象存储的仓库
+	external bool
+	storage  *httptest.Server
+	// storageAuth storage 收到的 Authorization 头
+	storageAuth []string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, uploads: map[string][]byte{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	f.storage = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			f.storageAuth = append(f.storageAuth, auth)
+		}
+		f.upload(w, r)
+	}))
+	t.Cleanup(f.storage.Close)
+	return f
+}
+
+func (f *fakeRegistry) uploadLocation(id string) string {
+	if f.external {
+		return f.storage.URL + "/upload/" + id
+	}
+	return "/upload/" + id
+}
+
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.srv.URL, "http://")
+}
+
+func digestOf(bs []byte) string {
+	sum := sha256.Sum256(bs)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "dev" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "t-" + r.URL.Query().Get("scope")})
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer t-") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.srv.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		if r.Method == http.MethodPut {
+			bs, _ := io.ReadAll(r.Body)
+			f.manifests[repo+":"+ref] = bs
+			f.manifests[repo+":"+digestOf(bs)] = bs
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		bs, ok := f.manifests[repo+":"+ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		w.Write(bs)
+	case strings.HasSuffix(path, "/blobs/uploads/"):
+		id := fmt.Sprint(len(f.uploads))
+		f.uploads[id] = nil
+		w.Header().Set("Location", f.uploadLocation(id))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		bs, ok := f.blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			f.ranges++
+		}
+		if digest == f.abort && r.Method == http.MethodGet {
+			f.abort = ""
+			w.Header().Set("Content-Length", fmt.Sprint(len(bs)))
+			w.Write(bs[:len(bs)/2])
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(bs))
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		f.upload(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// upload 处理分块上传，调用时持有 f.mu
+func (f *fakeRegistry) upload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/upload/")
+	bs, _ := io.ReadAll(r.Body)
+	f.uploads[id] = append(f.uploads[id], bs...)
+	if r.Method == http.MethodPut {
+		if digestOf(f.uploads[id]) != r.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[r.URL.Query().Get("digest")] = f.uploads[id]
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.Header().Set("Location", f.uploadLocation(id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+var baseData = strings.Repeat("box\n", 1000)
+
+// importBase 把旧格式的 tar 导入为只有一层的镜像 base
+func importBase(t *testing.T) *image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "etc/data", Mode: 0644, Size: int64(len(baseData))})
+	tw.Write([]byte(baseData))
+	tw.Close()
+	os.WriteFile(filepath.Join(consts.PATH_IMAGE, "base.tar"), buf.Bytes(), 0644)
+	base, err := image.Get("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestPushPull(t *testing.T) {
+	defer func(n int) { uploadChunkSize = n }(uploadChunkSize)
+	uploadChunkSize = 1000
+	reg := newFakeRegistry(t)
+	testutil.SetTestRoot(t)
+
+	base := importBase(t)
+	name := reg.host() + "/team/app:v1"
+	if err := image.Tag("base", name); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Push(name, Options{}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Push() before login = %v, want ErrUnauthorized", err)
+	}
+	if err := Login(reg.host(), "dev", "wrong", Options{}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Login() with a wrong password = %v, want ErrUnauthorized", err)
+	}
+	if err := Login(reg.host(), "dev", "secret", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push(name, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Push(name, Options{Output: &out}); err != nil || strings.Count(out.String(), "already exists") != 2 {
+		t.Fatalf("second Push() = %v, output:\n%s", err, out.String())
+	}
+	m, man, _, err := image.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 在新的镜像库中拉取，第一次下载层时连接中断，再次拉取时继续下载
+	testutil.SetTestRoot(t)
+	if _, err := Pull(name, Options{}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Pull() before login = %v, want ErrUnauthorized", err)
+	}
+	if err := Login(reg.host(), "dev", "secret", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	reg.abort = man.Layers[0].Digest
+	if _, err := Pull(name, Options{}); err == nil {
+		t.Fatal("Pull() with an aborted download succeeded")
+	}
+	img, err := Pull(name, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != base.ID || img.Digest != m.Digest || reg.ranges != 1 {
+		t.Errorf("Pull() = %+v, want %s with 1 range request (got %d)", img, base.ID, reg.ranges)
+	}
+	layers, err := image.Layers(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := os.ReadFile(filepath.Join(consts.GetPathLayer(layers[0]), "etc/data")); err != nil || string(bs) != baseData {
+		t.Errorf("pulled layer content = %d bytes, %v", len(bs), err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(consts.PATH_IMAGE, ".partial-*")); len(matches) != 0 {
+		t.Errorf("partial downloads left: %v", matches)
+	}
+
+	// 按摘要拉取，多平台镜像选择当前平台
+	idx, _ := json.Marshal(image.Index{SchemaVersion: 2, MediaType: image.MEDIA_TYPE_INDEX, Manifests: []image.Descriptor{
+		{MediaType: image.MEDIA_TYPE_MANIFEST, Digest: digestOf([]byte("other")), Platform: &image.Platform{OS: "linux", Architecture: "s390x-none"}},
+		{MediaType: image.MEDIA_TYPE_MANIFEST, Digest: m.Digest, Platform: &image.Platform{OS: "linux", Architecture: goruntime.GOARCH}},
+	}})
+	reg.manifests["team/app:multi"] = idx
+	if img, err := Pull(reg.host()+"/team/app:multi", Options{}); err != nil || img.ID != base.ID {
+		t.Errorf("Pull() of an index = %+v, %v", img, err)
+	}
+	if _, err := Pull(reg.host()+"/team/app:pinned@"+m.Digest, Options{}); err != nil {
+		t.Errorf("Pull() by digest = %v", err)
+	}
+	if _, err := Pull(reg.host()+"/team/app@"+m.Digest, Options{}); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Pull() by digest without a tag = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := Pull(reg.host()+"/team/app:none", Options{}); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Pull() of a missing tag = %v, want ErrNotFound", err)
+	}
+
+	if err := Logout(reg.host()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Logout(reg.host()); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("second Logout() = %v, want ErrNotFound", err)
+	}
+}
+
+// 上传地址指向其他主机时不能带上仓库的凭据
+func TestPushExternalUpload(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.external = true
+	testutil.SetTestRoot(t)
+	importBase(t)
+	name := reg.host() + "/team/app:v1"
+	if err := image.Tag("base", name); err != nil {
+		t.Fatal(err)
+	}
+	if err := Login(reg.host(), "dev", "secret", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push(name, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.blobs) == 0 {
+		t.Fatal("no blobs were uploaded to the storage")
+	}
+	if len(reg.storageAuth) != 0 {
+		t.Errorf("storage received credentials: %v", reg.storageAuth)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:app:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:app:pull,push" {
+		t.Errorf("parseChallenge() = %s, %v", scheme, params)
+	}
+	if scheme, params := parseChallenge(`Basic realm=registry`); scheme != "Basic" || params["realm"] != "registry" {
+		t.Errorf("parseChallenge() = %s, %v", scheme, params)
+	}
+}
+
+// 等锁期间持有锁的下载完成并删除了文件，lockPartial 返回重新创建的文件
+func TestLockPartial(t *testing.T) {
+	p := filepath.Join(t.TempDir(), ".partial-x")
+	first, err := lockPartial(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan *os.File)
+	go func() {
+		f, err := lockPartial(p)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- f
+	}()
+	time.Sleep(50 * time.Millisecond)
+	os.Remove(p)
+	first.Close()
+	f := <-done
+	if f == nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur, err := os.Stat(p); err != nil || !os.SameFile(fi, cur) {
+		t.Errorf("lockPartial() locked a removed file: %v", err)
+	}
+}
@@ -0,0 +1,794 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/cgroups"
+	"github.com/wlbyte/mydocker/cgroups/subsystems"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/image"
+	"github.com/wlbyte/mydocker/metrics"
+	"github.com/wlbyte/mydocker/network"
+	"github.com/wlbyte/mydocker/utils"
+	"golang.org/x/sys/unix"
+)
+
+// exec 子进程通过这两个环境变量把目标容器和命令传给 nsenter
+const (
+	EnvExecPid = "mydocker_pid"
+	EnvExecCmd = "mydocker_cmd"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+type CreateOptions struct {
+	Name  string
+	Image string
+	// Cmd 为空时使用镜像的 Cmd，镜像有 Entrypoint 时 Cmd 作为它的参数
+	Cmd []string
+	// TTY 为 true 时容器进程直接使用当前进程的标准输入输出，否则输出写入日志文件
+	TTY    bool
+	Detach bool
+	Volume string
+	// Env 和镜像的 Env 合并，同名的变量以 Env 为准
+	Env         []string
+	Network     string
+	PortMapping []string
+	Resources   *subsystems.ResourceConfig
+}
+
+// StartOptions TTY 容器的标准输入输出，为 nil 时使用当前进程的，daemon 用它把容器接到客户端的连接上
+type StartOptions struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type StopOptions struct {
+	// 发送 SIGTERM 后等待的时间，超时后发送 SIGKILL，为 0 时使用默认的 10 秒
+	Timeout time.Duration
+}
+
+type RemoveOptions struct {
+	// 强制删除运行中的容器
+	Force bool
+}
+
+type ExecOptions struct {
+	Cmd    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type LogsOptions struct {
+	// 读到文件末尾后继续等待新的输出，直到容器退出或调用方 Close
+	Follow bool
+}
+
+type ListOptions struct {
+	// 为 false 时只返回运行中的容器
+	All bool
+}
+
+// Create 准备容器的 rootfs 并记录为 created 状态，容器进程由 Start 启动
+func (r *Runtime) Create(opts CreateOptions) (c *container.Container, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("create", start, err) }()
+	errFormat := "runtime.Create: %w"
+	if opts.Image == "" {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: image is required", ErrInvalidArgument))
+	}
+	if opts.TTY && opts.Detach {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: tty and detach are mutually exclusive", ErrInvalidArgument))
+	}
+	c = &container.Container{
+		Name:        opts.Name,
+		ImageName:   opts.Image,
+		TTY:         opts.TTY,
+		Detach:      opts.Detach,
+		Volume:      opts.Volume,
+		Network:     opts.Network,
+		PortMapping: opts.PortMapping,
+		Status:      consts.STATUS_CREATED,
+		CreateAt:    time.Now().Format("2006-01-02 15:04:05"),
+	}
+	c.ResourceConfig = opts.Resources
+	if c.ResourceConfig == nil {
+		c.ResourceConfig = &subsystems.ResourceConfig{}
+	}
+	if c.Network == "" {
+		c.Network = r.config.DefaultNetwork.Name
+	}
+	id, err := utils.HashStr(fmt.Sprint(c, time.Now().UnixNano()))
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	c.Id = id
+	if c.Name == "" {
+		c.Name = c.Id[:12]
+	}
+
+	unlock, err := lockContainers()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	cs, err := loadContainers()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	for _, existing := range cs {
+		if existing.Name == c.Name {
+			return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: container name %s is already in use", ErrAlreadyExists, c.Name))
+		}
+	}
+	// 在容器的锁内解压镜像的层，RemoveImage 持有同一个锁，不会回收正在使用的层
+	img, err := image.Inspect(opts.Image)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	c.ImageID = img.ID
+	if err := applyImageConfig(c, &img.Config, opts); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	// 按清单摘要解压，引用在这期间被 tag 指向别的镜像也不影响
+	c.Layers, err = image.Layers(img.Digest)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+
+	rb := &utils.Rollback{}
+	defer rb.Run()
+	// c 是命名返回值，出错返回时已经被置为 nil，回滚时使用局部变量
+	ws := c
+	rb.Add("workspace", func() error {
+		container.DelWorkspace(ws)
+		return nil
+	})
+	if err := container.NewWorkspace(c); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if err := recordContainerInfo(c); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	rb.Commit()
+	containerEvent(c, events.ACTION_CREATE, nil)
+	return c, nil
+}
+
+// Start 启动 created 状态的容器。每完成一步就登记对应的撤销操作，任意一步失败都会撤销之前
+// 创建的进程、cgroup、IP、veth 等资源，容器回到 created 状态
+func (r *Runtime) Start(id string, opts StartOptions) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("start", start, err) }()
+	errFormat := "runtime.Start: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status != consts.STATUS_CREATED {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is %s", ErrConflict, c.Name, c.Status))
+	}
+
+	rb := &utils.Rollback{}
+	defer rb.Run()
+	parent, writePipe, err := container.NewParentProcess(c)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer writePipe.Close()
+	if c.TTY {
+		stdin, err := setStdio(parent, opts)
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		if stdin != nil {
+			defer stdin.Close()
+		}
+	}
+	if err := parent.Start(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	rb.Add("process", func() error {
+		if err := parent.Process.Kill(); err != nil {
+			return err
+		}
+		parent.Wait()
+		return nil
+	})
+	// 子进程阻塞在读取管道上，在发送命令之前设置好 ulimit、cgroup 和网络
+	if err := r.config.ApplyUlimits(parent.Process.Pid); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	c.CgroupPath = path.Join(r.config.CgroupParent, c.Id)
+	cgroupManager := cgroups.NewCgroupManager(c.CgroupPath)
+	rb.Add("cgroup", cgroupManager.Destroy)
+	if err := cgroupManager.Set(c.ResourceConfig); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := cgroupManager.Apply(parent.Process.Pid, c.ResourceConfig); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+
+	c.Pid = parent.Process.Pid
+	c.Status = consts.STATUS_RUNNING
+	if err := recordContainerInfo(c); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	rb.Add("status", func() error {
+		c.Pid = 0
+		c.Status = consts.STATUS_CREATED
+		return recordContainerInfo(c)
+	})
+
+	// 配置网络，network.Connect 失败时会自行撤销已经完成的部分
+	if err := network.Connect(c); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	rb.Add("network", func() error {
+		return releaseNetwork(c)
+	})
+	if e := findEndpoint(c); e != nil {
+		networkEvent(e, c, events.ACTION_CONNECT)
+	}
+
+	if err := sendInitCommand(c, writePipe); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	logrus.Debugln("send init command to pipe")
+	rb.Commit()
+	containerEvent(c, events.ACTION_START, nil)
+
+	r.mu.Lock()
+	r.procs[c.Id] = &process{cmd: parent, cgroup: cgroupManager}
+	r.mu.Unlock()
+	return nil
+}
+
+// setStdio 使用 opts 中的标准输入输出代替当前进程的。标准输入不是文件时通过管道转发：
+// 交给 exec 复制的话 Wait 会阻塞在读取上，容器退出后只要客户端不再输入就不会返回。
+// 返回的管道读端在进程启动后由调用方关闭
+func setStdio(cmd *exec.Cmd, opts StartOptions) (*os.File, error) {
+	if opts.Stdout != nil {
+		cmd.Stdout = opts.Stdout
+	}
+	if opts.Stderr != nil {
+		cmd.Stderr = opts.Stderr
+	}
+	if opts.Stdin == nil {
+		return nil, nil
+	}
+	if f, ok := opts.Stdin.(*os.File); ok {
+		cmd.Stdin = f
+		return nil, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = pr
+	// 容器退出后写管道失败，复制随之结束
+	go func() {
+		io.Copy(pw, opts.Stdin)
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+// exitStatus 把 cmd.Wait 的结果转换为退出码，被信号杀死的进程和 shell 一样返回 128+信号值
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1, err
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
+
+// Wait 等待由当前 Runtime 启动的容器退出并返回退出码。
+// 容器退出后释放网络和 cgroup，TTY 容器还会删除工作目录
+func (r *Runtime) Wait(id string) (int, error) {
+	errFormat := "runtime.Wait: %w"
+	c, err := r.Inspect(id)
+	if err != nil {
+		return -1, fmt.Errorf(errFormat, err)
+	}
+	r.mu.Lock()
+	p, ok := r.procs[c.Id]
+	delete(r.procs, c.Id)
+	r.mu.Unlock()
+	if !ok {
+		return -1, fmt.Errorf(errFormat, fmt.Errorf("%w: container %s was not started by this runtime", ErrInvalidArgument, c.Name))
+	}
+
+	exitCode, err := exitStatus(p.cmd.Wait())
+	if err != nil {
+		return -1, fmt.Errorf(errFormat, err)
+	}
+
+	unlock, err := lockContainers()
+	if err != nil {
+		return exitCode, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	// 重新读取，容器可能在等待期间被 stop
+	c, err = findContainer(c.Id)
+	if err != nil {
+		return exitCode, fmt.Errorf(errFormat, err)
+	}
+	// 被 stop/kill 的容器已经记录过 die
+	died := isActive(c)
+	if died {
+		containerDied(c, exitCode)
+	}
+	if err := releaseNetwork(c); err != nil {
+		logrus.Errorln("wait:", err)
+	}
+	logrus.Debugln("release resource")
+	if err := p.cgroup.Destroy(); err != nil {
+		logrus.Errorln("wait:", err)
+	}
+	if c.TTY {
+		logrus.Debugln("clear work dir")
+		container.DelWorkspace(c)
+	}
+	if died {
+		c.Pid = 0
+		c.Status = consts.STATUS_EXITED
+	}
+	// 被 stop 的容器也由这里回收，退出码以这里得到的为准
+	c.ExitCode = exitCode
+	if err := recordContainerInfo(c); err != nil {
+		return exitCode, fmt.Errorf(errFormat, err)
+	}
+	return exitCode, nil
+}
+
+// Stop 发送 SIGTERM 并等待容器退出，超时后发送 SIGKILL
+func (r *Runtime) Stop(id string, opts StopOptions) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("stop", start, err) }()
+	errFormat := "runtime.Stop: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status == consts.STATUS_PAUSED {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is paused, unpause it first", ErrConflict, c.Name))
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = defaultStopTimeout
+	}
+	if err := stopContainer(c, timeout); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	containerEvent(c, events.ACTION_STOP, nil)
+	return nil
+}
+
+// Kill 向容器进程发送信号，进程随之退出时容器被标记为 stopped
+func (r *Runtime) Kill(id string, sig unix.Signal) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("kill", start, err) }()
+	errFormat := "runtime.Kill: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status == consts.STATUS_PAUSED {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is paused, unpause it first", ErrConflict, c.Name))
+	}
+	if c.Status != consts.STATUS_RUNNING {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrNotRunning, c.Name))
+	}
+	if err := unix.Kill(c.Pid, sig); err != nil && err != unix.ESRCH {
+		return fmt.Errorf(errFormat, err)
+	}
+	containerEvent(c, events.ACTION_KILL, map[string]string{"signal": strconv.Itoa(int(sig))})
+	if waitExit(c.Pid, time.Second) {
+		if err := markStopped(c); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	return nil
+}
+
+// Pause 通过 freezer cgroup 冻结容器中的所有进程
+func (r *Runtime) Pause(id string) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("pause", start, err) }()
+	errFormat := "runtime.Pause: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status == consts.STATUS_PAUSED {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is already paused", ErrConflict, c.Name))
+	}
+	if c.Status != consts.STATUS_RUNNING {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrNotRunning, c.Name))
+	}
+	if err := cgroups.NewCgroupManager(c.CgroupPath).Freeze(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	c.Status = consts.STATUS_PAUSED
+	if err := recordContainerInfo(c); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	containerEvent(c, events.ACTION_PAUSE, nil)
+	return nil
+}
+
+func (r *Runtime) Unpause(id string) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("unpause", start, err) }()
+	errFormat := "runtime.Unpause: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status != consts.STATUS_PAUSED {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is not paused", ErrConflict, c.Name))
+	}
+	if err := cgroups.NewCgroupManager(c.CgroupPath).Thaw(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	c.Status = consts.STATUS_RUNNING
+	if err := recordContainerInfo(c); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	containerEvent(c, events.ACTION_UNPAUSE, nil)
+	return nil
+}
+
+// Remove 删除容器及其工作目录，运行中的容器需要指定 Force
+func (r *Runtime) Remove(id string, opts RemoveOptions) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveOperation("remove", start, err) }()
+	errFormat := "runtime.Remove: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	c, err := findContainer(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if isActive(c) {
+		if !opts.Force {
+			return fmt.Errorf(errFormat, fmt.Errorf("%w: container %s must be stopped", ErrConflict, c.Name))
+		}
+		// 被冻结的进程收不到信号，先解冻
+		if c.Status == consts.STATUS_PAUSED {
+			if err := cgroups.NewCgroupManager(c.CgroupPath).Thaw(); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+			c.Status = consts.STATUS_RUNNING
+		}
+		// 强制删除时直接 SIGKILL
+		if err := stopContainer(c, -1); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	if err := releaseNetwork(c); err != nil {
+		logrus.Warnln("remove:", err)
+	}
+	container.DelWorkspace(c)
+	if c.CgroupPath != "" {
+		if err := cgroups.NewCgroupManager(c.CgroupPath).Destroy(); err != nil {
+			logrus.Warnln("remove:", err)
+		}
+	}
+	containerEvent(c, events.ACTION_DESTROY, nil)
+	return nil
+}
+
+// Exec 在运行中的容器内执行命令
+func (r *Runtime) Exec(id string, opts ExecOptions) error {
+	errFormat := "runtime.Exec: %w"
+	if len(opts.Cmd) == 0 {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: command is required", ErrInvalidArgument))
+	}
+	c, err := r.Inspect(id)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if c.Status != consts.STATUS_RUNNING {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrNotRunning, c.Name))
+	}
+	envs, err := getEnvsByPid(c.Pid)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	// nsenter 在 Go 运行时启动前根据环境变量进入容器的 namespace 并执行命令
+	cmdStr := strings.Join(opts.Cmd, " ")
+	cmd := exec.Command("/proc/self/exe", "exec")
+	if _, ok := opts.Stdin.(*os.File); ok || opts.Stdin == nil {
+		cmd.Stdin = opts.Stdin
+	} else {
+		// 标准输入不是文件（比如网络连接）时，exec.Cmd.Wait 会一直等待复制标准输入的 goroutine，
+		// 改用 StdinPipe，命令退出时由 Wait 关闭管道
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		go func() {
+			io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	cmd.Env = append(os.Environ(), envs...)
+	cmd.Env = append(cmd.Env, EnvExecPid+"="+strconv.Itoa(c.Pid), EnvExecCmd+"="+cmdStr)
+	// 容器进程的环境变量里可能带着启动时的日志级别，以当前进程的设置为准
+	cmd.Env = append(cmd.Env, utils.ENV_LOG_LEVEL+"="+logrus.GetLevel().String())
+	logrus.Debugf("container pid: %d, command: %s", c.Pid, cmdStr)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+func (r *Runtime) List(opts ListOptions) ([]*container.Container, error) {
+	errFormat := "runtime.List: %w"
+	cs, err := loadContainers()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if opts.All {
+		return cs, nil
+	}
+	var running []*container.Container
+	for _, c := range cs {
+		if isActive(c) {
+			running = append(running, c)
+		}
+	}
+	return running, nil
+}
+
+// Inspect 按 ID、名称或唯一的 ID 前缀查找容器
+func (r *Runtime) Inspect(id string) (*container.Container, error) {
+	c, err := findContainer(id)
+	if err != nil {
+		return nil, fmt.Errorf("runtime.Inspect: %w", err)
+	}
+	return c, nil
+}
+
+// Logs 返回后台容器的日志，调用方负责关闭
+func (r *Runtime) Logs(id string, opts LogsOptions) (io.ReadCloser, error) {
+	errFormat := "runtime.Logs: %w"
+	c, err := findContainer(id)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	f, err := os.Open(logFilePath(c))
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if !opts.Follow {
+		return f, nil
+	}
+	return &followReader{f: f, id: c.Id}, nil
+}
+
+// Attach 从当前位置开始持续读取运行中的后台容器的输出，直到容器退出或调用方 Close。
+// 后台容器没有连接标准输入，只能读取输出
+func (r *Runtime) Attach(id string) (io.ReadCloser, error) {
+	errFormat := "runtime.Attach: %w"
+	c, err := findContainer(id)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if c.Status != consts.STATUS_RUNNING {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrNotRunning, c.Name))
+	}
+	if c.TTY {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: container %s is attached to a terminal", ErrInvalidArgument, c.Name))
+	}
+	f, err := os.Open(logFilePath(c))
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return &followReader{f: f, id: c.Id}, nil
+}
+
+// followReader 读到日志文件末尾时，如果容器仍在运行就等待新的输出
+type followReader struct {
+	f  *os.File
+	id string
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		c, err := findContainer(r.id)
+		if err != nil || !isActive(c) || !container.IsAlive(c.Pid) {
+			return 0, io.EOF
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (r *followReader) Close() error {
+	return r.f.Close()
+}
+
+// stopContainer timeout 小于 0 时直接发送 SIGKILL，调用方负责持有 lockContainers 锁
+func stopContainer(c *container.Container, timeout time.Duration) error {
+	errFormat := "stopContainer: %w"
+	if c.Status != consts.STATUS_RUNNING {
+		return fmt.Errorf(errFormat, fmt.Errorf("%w: %s", ErrNotRunning, c.Name))
+	}
+	sig := unix.SIGTERM
+	if timeout < 0 {
+		sig = unix.SIGKILL
+	}
+	if err := unix.Kill(c.Pid, sig); err != nil && err != unix.ESRCH {
+		return fmt.Errorf(errFormat, err)
+	}
+	if sig == unix.SIGTERM && !waitExit(c.Pid, timeout) {
+		logrus.Warnf("container %s did not exit in %s, kill it", c.Name, timeout)
+		if err := unix.Kill(c.Pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			return fmt.Errorf(errFormat, err)
+		}
+		waitExit(c.Pid, time.Second)
+	}
+	if err := markStopped(c); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// markStopped 记录 die 事件，释放网络资源并记录为 stopped
+func markStopped(c *container.Container) error {
+	containerDied(c, -1)
+	if err := releaseNetwork(c); err != nil {
+		return err
+	}
+	c.Pid = 0
+	c.Status = consts.STATUS_STOPPED
+	return recordContainerInfo(c)
+}
+
+// releaseNetwork 删除 veth、释放 IP 和端口映射，并删除 endpoint 文件。
+// endpoint 文件必须和 IP 一起删除，否则 reconcile 会再次释放可能已经分配给其他容器的 IP
+func releaseNetwork(c *container.Container) error {
+	e := findEndpoint(c)
+	if e == nil {
+		return nil
+	}
+	if err := network.DelConnect(c, e); err != nil {
+		return err
+	}
+	if err := network.RemoveEndpoint(e); err != nil {
+		return err
+	}
+	networkEvent(e, c, events.ACTION_DISCONNECT)
+	return nil
+}
+
+// waitExit 轮询等待进程退出，超时返回 false
+func waitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !container.IsAlive(pid) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func getEnvsByPid(pid int) ([]string, error) {
+	bs, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/environ")
+	if err != nil {
+		return nil, fmt.Errorf("getEnvsByPid: %w", err)
+	}
+	return strings.Split(strings.TrimRight(string(bs), "\u0000"), "\u0000"), nil
+}
+
+func sendInitCommand(c *container.Container, writePipe *os.File) error {
+	logrus.Debugf("command: %q", c.Cmds)
+	bs, err := json.Marshal(container.InitConfig{Args: c.Cmds, WorkingDir: c.WorkingDir, User: c.User})
+	if err != nil {
+		return fmt.Errorf("sendInitCommand: %w", err)
+	}
+	if _, err := writePipe.Write(bs); err != nil {
+		return fmt.Errorf("sendInitCommand: %w", err)
+	}
+	return writePipe.Close()
+}
+
+// applyImageConfig 用镜像配置补全容器的命令、环境变量、工作目录和用户。
+// 和 Docker 一样，命令是 Entrypoint 加上 opts.Cmd，opts.Cmd 为空时加上镜像的 Cmd
+func applyImageConfig(c *container.Container, cfg *image.ContainerConfig, opts CreateOptions) error {
+	args := opts.Cmd
+	if len(args) == 0 {
+		args = cfg.Cmd
+	}
+	c.Cmds = append(slices.Clone(cfg.Entrypoint), args...)
+	if len(c.Cmds) == 0 {
+		return fmt.Errorf("%w: no command specified and image %s has no Cmd or Entrypoint", ErrInvalidArgument, opts.Image)
+	}
+	c.Environment = mergeEnv(cfg.Env, opts.Env)
+	c.WorkingDir = cfg.WorkingDir
+	c.User = cfg.User
+	return nil
+}
+
+// mergeEnv 合并 KEY=VALUE 形式的环境变量，后面的同名变量覆盖前面的，保持变量第一次出现的顺序
+func mergeEnv(envs ...[]string) []string {
+	var merged []string
+	pos := map[string]int{}
+	for _, env := range envs {
+		for _, kv := range env {
+			k, _, _ := strings.Cut(kv, "=")
+			if i, ok := pos[k]; ok {
+				merged[i] = kv
+				continue
+			}
+			pos[k] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
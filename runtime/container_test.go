@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{name: "success", script: "exit 0", want: 0},
+		{name: "exit code", script: "exit 3", want: 3},
+		{name: "killed", script: "kill -KILL $$", want: 137},
+		{name: "terminated", script: "kill -TERM $$", want: 143},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exitStatus(exec.Command("sh", "-c", tt.script).Run())
+			if err != nil || got != tt.want {
+				t.Errorf("exitStatus() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+	if _, err := exitStatus(exec.Command("/nonexistent").Run()); err == nil {
+		t.Error("exitStatus() of a command that failed to start returned no error")
+	}
+}
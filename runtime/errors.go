@@ -0,0 +1,14 @@
+package runtime
+
+import "github.com/wlbyte/mydocker/errdefs"
+
+// Runtime 方法返回的错误都包装了下面的错误之一，调用方可以用 errors.Is 判断，
+// 它们同时属于 errdefs 中对应的分类
+var (
+	ErrNotFound        = errdefs.New(errdefs.ErrNotFound, "no such container")
+	ErrAmbiguous       = errdefs.New(errdefs.ErrAmbiguous, "multiple containers match")
+	ErrInvalidArgument = errdefs.ErrInvalidArgument
+	ErrConflict        = errdefs.ErrConflict
+	ErrNotRunning      = errdefs.New(errdefs.ErrNotRunning, "container is not running")
+	ErrAlreadyExists   = errdefs.ErrAlreadyExists
+)
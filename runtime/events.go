@@ -0,0 +1,49 @@
+package runtime
+
+import (
+	"strconv"
+
+	"github.com/wlbyte/mydocker/cgroups"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/network"
+)
+
+// containerEvent 记录容器事件，属性中带上名称和镜像，便于按名称过滤
+func containerEvent(c *container.Container, action string, attrs map[string]string) {
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	attrs["name"] = c.Name
+	attrs["image"] = c.ImageName
+	events.Log(events.TYPE_CONTAINER, action, c.Id, attrs)
+}
+
+func networkEvent(e *network.Endpoint, c *container.Container, action string) {
+	if e.Network == nil {
+		return
+	}
+	events.Log(events.TYPE_NETWORK, action, e.Network.Name, map[string]string{
+		"container": c.Id,
+		"ip":        e.IPAddress.String(),
+	})
+}
+
+// containerDied 记录容器进程退出，exitCode 小于 0 表示退出码未知。
+// 需要在删除 cgroup 之前调用，以便检查是否发生了 OOM
+func containerDied(c *container.Container, exitCode int) {
+	if c.CgroupPath != "" && cgroups.NewCgroupManager(c.CgroupPath).OOMKilled() {
+		containerEvent(c, events.ACTION_OOM, nil)
+	}
+	attrs := map[string]string{}
+	if exitCode >= 0 {
+		attrs["exitCode"] = strconv.Itoa(exitCode)
+	}
+	containerEvent(c, events.ACTION_DIE, attrs)
+}
+
+// isActive 容器进程存在，包括被 pause 的容器
+func isActive(c *container.Container) bool {
+	return c.Status == consts.STATUS_RUNNING || c.Status == consts.STATUS_PAUSED
+}
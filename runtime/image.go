@@ -0,0 +1,112 @@
+package runtime
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/image"
+)
+
+type RemoveImageOptions struct {
+	// 删除仍被容器使用的镜像，容器使用的层会保留到容器删除之后
+	Force bool
+}
+
+// RemoveImage 删除镜像的引用并回收不再被引用的 blob 和层。删除的是镜像的最后一个引用，
+// 并且镜像被容器（包括已停止的容器）使用时需要指定 Force
+func (r *Runtime) RemoveImage(name string, opts RemoveImageOptions) (*image.RemoveResult, error) {
+	errFormat := "runtime.RemoveImage: %w"
+	// 先持有容器的锁，避免删除镜像时有容器正在使用它创建
+	unlock, err := lockContainers()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	img, err := image.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	cs, err := loadContainers()
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var users []string
+	var keep []string
+	for _, c := range cs {
+		keep = append(keep, c.Layers...)
+		if usesImage(c, img) {
+			users = append(users, c.Name)
+		}
+	}
+	// 镜像还有别的引用时只是删除一个标签，不影响容器
+	lastRef := img.Name == "" || len(img.RepoTags) <= 1
+	if lastRef && len(users) > 0 && !opts.Force {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: image %s is used by container %s", ErrConflict, name, strings.Join(users, ", ")))
+	}
+	res, err := image.Remove(name, keep)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return res, nil
+}
+
+// usesImage 判断容器是否由 img 创建，以前的版本没有记录镜像 ID，按镜像名称判断
+func usesImage(c *container.Container, img *image.Image) bool {
+	if c.ImageID != "" {
+		return c.ImageID == img.ID
+	}
+	ref, err := image.ParseTag(c.ImageName)
+	return err == nil && slices.Contains(img.RepoTags, ref.String())
+}
+
+type CommitOptions struct {
+	Author  string
+	Message string
+	// Changes 应用到新镜像配置上的 Dockerfile 指令，如 ENV a=b、CMD ["sh"]
+	Changes []string
+	// Pause 提交期间冻结运行中的容器，保证文件系统的一致性
+	Pause bool
+}
+
+// Commit 把容器对镜像的修改提交为名为 ref 的新镜像
+func (r *Runtime) Commit(id, ref string, opts CommitOptions) (*image.Image, error) {
+	errFormat := "runtime.Commit: %w"
+	c, err := r.Inspect(id)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	if len(c.Layers) == 0 {
+		return nil, fmt.Errorf(errFormat, fmt.Errorf("%w: container %s was created without image layers", ErrInvalidArgument, c.Name))
+	}
+	if opts.Pause && c.Status == consts.STATUS_RUNNING {
+		if err := r.Pause(c.Id); err != nil {
+			return nil, fmt.Errorf(errFormat, err)
+		}
+		defer func() {
+			if err := r.Unpause(c.Id); err != nil {
+				logrus.Warnln("commit:", err)
+			}
+		}()
+	}
+	parent := c.ImageID
+	if parent == "" {
+		parent = c.ImageName
+	}
+	img, err := image.Commit(image.CommitOptions{
+		ContainerID: c.Id,
+		Parent:      parent,
+		UpperDir:    consts.GetPathUpper(c.Id),
+		Reference:   ref,
+		Author:      opts.Author,
+		Message:     opts.Message,
+		Changes:     opts.Changes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/network"
+)
+
+// Reconcile 把持久化状态和宿主机实际状态对齐：
+// 进程已经不存在的 running 容器标记为 exited，释放不再运行的容器的网络资源，重建丢失的网桥。
+// full 为 true 时还会清理泄漏的 IP、veth、DNAT 规则和挂载点
+func (r *Runtime) Reconcile(full bool) error {
+	errFormat := "runtime.Reconcile: %w"
+	unlock, err := lockContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+
+	cs, err := loadContainers()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	var errs []error
+	known := map[string]bool{}
+	running := map[string]bool{}
+	for _, c := range cs {
+		known[c.Id] = true
+		if !isActive(c) {
+			continue
+		}
+		if container.IsAlive(c.Pid) {
+			running[c.Id] = true
+			continue
+		}
+		logrus.WithField("container", c.Id).Info("reconcile: container is dead, mark as exited")
+		containerDied(c, -1)
+		c.Pid = 0
+		c.Status = consts.STATUS_EXITED
+		if err := recordContainerInfo(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := network.Reconcile(running, full); err != nil {
+		errs = append(errs, err)
+	}
+	if full {
+		if err := container.CleanupOrphanMounts(known); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package runtime 提供容器生命周期管理的 Go API，mydocker 命令行只是它的一层封装。
+//
+// 嵌入 runtime 的程序需要在 main 函数开始处调用 Reexec：容器的 init 进程是通过
+// /proc/self/exe init 重新执行当前程序创建的
+//
+//	func main() {
+//		if runtime.Reexec() {
+//			return
+//		}
+//		r, err := runtime.New(runtime.Options{Root: "/tmp/mydocker"})
+//		...
+//	}
+//
+// 数据目录保存在 consts 包的全局变量中，一个进程只能使用一套数据目录：第一次 New
+// 确定目录之后，再用其他目录调用 New 会返回错误。需要隔离的多个实例请使用多个进程
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/cgroups"
+	"github.com/wlbyte/mydocker/config"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/errdefs"
+	_ "github.com/wlbyte/mydocker/nsenter"
+)
+
+// Options 创建 Runtime 的参数，空值使用配置文件或默认值
+type Options struct {
+	// 持久化状态目录，默认 /var/lib/mydocker
+	Root string
+	// 运行时状态目录，默认 /var/run/mydocker
+	ExecRoot string
+	// 为 nil 时使用 config.Get()
+	Config *config.Config
+}
+
+// Runtime 管理容器的创建、启动、停止和删除。
+// 路径保存在 consts 包中，同一进程内的所有 Runtime 共用一套数据目录
+type Runtime struct {
+	config *config.Config
+
+	mu sync.Mutex
+	// 由当前进程启动的容器进程，Wait 需要用到
+	procs map[string]*process
+}
+
+// 第一次 New 使用的数据目录和运行时目录。其他包直接读取 consts 中的路径且不加锁，
+// 已经有 Runtime 在使用时切换目录会让它们读到另一套状态，所以只允许设置一次
+var (
+	rootMu      sync.Mutex
+	rootSet     bool
+	curRoot     string
+	curExecRoot string
+)
+
+type process struct {
+	cmd    *exec.Cmd
+	cgroup *cgroups.CgroupManager
+}
+
+func New(opts Options) (*Runtime, error) {
+	errFormat := "runtime.New: %w"
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = config.Get()
+	}
+	root, execRoot := cfg.Root, cfg.ExecRoot
+	if opts.Root != "" {
+		root = opts.Root
+	}
+	if opts.ExecRoot != "" {
+		execRoot = opts.ExecRoot
+	}
+	if err := setRoot(root, execRoot); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	config.Set(cfg)
+	if err := initDir(); err != nil {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	return &Runtime{
+		config: cfg,
+		procs:  map[string]*process{},
+	}, nil
+}
+
+// Reexec 当前进程是容器 init 进程时执行 init 逻辑并返回 true，正常情况下 init 会 exec 成用户命令不会返回
+func Reexec() bool {
+	if len(os.Args) < 2 || os.Args[1] != "init" {
+		return false
+	}
+	if err := container.RunContainerInitProcess(); err != nil {
+		logrus.Errorln("init:", err)
+		os.Exit(errdefs.ExitCode(err))
+	}
+	return true
+}
+
+// setRoot 第一次调用时设置 consts 中的路径，之后只接受相同的目录
+func setRoot(root, execRoot string) error {
+	if root == "" {
+		root = consts.DEFAULT_ROOT
+	}
+	if execRoot == "" {
+		execRoot = consts.DEFAULT_EXEC_ROOT
+	}
+	root, execRoot = filepath.Clean(root), filepath.Clean(execRoot)
+	rootMu.Lock()
+	defer rootMu.Unlock()
+	if rootSet {
+		if root != curRoot || execRoot != curExecRoot {
+			return fmt.Errorf("%w: data root is already %s (exec root %s), one process can only use one data root",
+				errdefs.ErrInvalidArgument, curRoot, curExecRoot)
+		}
+		return nil
+	}
+	consts.SetRoot(root, execRoot)
+	rootSet, curRoot, curExecRoot = true, root, execRoot
+	return nil
+}
+
+func initDir() error {
+	errFormat := "initDir %s: %w"
+	for _, dir := range []string{
+		consts.PATH_CONTAINER,
+		consts.PATH_FS_ROOT,
+		consts.PATH_IMAGE,
+		consts.PATH_IPAM,
+		consts.PATH_NETWORK_NETWORK,
+		// 运行时目录
+		consts.PATH_EXEC_ROOT,
+	} {
+		if err := os.MkdirAll(dir, consts.MODE_0755); err != nil {
+			return fmt.Errorf(errFormat, dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package runtime
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/wlbyte/mydocker/config"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/errdefs"
+)
+
+func TestNewSingleRoot(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Root: filepath.Join(dir, "root"), ExecRoot: filepath.Join(dir, "run"), Config: &config.Config{}}
+	if _, err := New(opts); err != nil {
+		t.Fatal(err)
+	}
+	if consts.PATH_HOME != opts.Root {
+		t.Fatalf("PATH_HOME = %s, want %s", consts.PATH_HOME, opts.Root)
+	}
+	// 相同的目录可以再创建 Runtime，换一个目录不行
+	if _, err := New(opts); err != nil {
+		t.Errorf("New() with the same root = %v", err)
+	}
+	other := opts
+	other.Root = filepath.Join(dir, "other")
+	if _, err := New(other); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("New() with another root = %v, want ErrInvalidArgument", err)
+	}
+	if consts.PATH_HOME != opts.Root {
+		t.Errorf("PATH_HOME changed to %s", consts.PATH_HOME)
+	}
+}
@@ -0,0 +1,114 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/container"
+	"github.com/wlbyte/mydocker/network"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// lockContainers 获取容器状态的跨进程文件锁，读取-修改-写回 config.json 的操作需要在锁内进行
+func lockContainers() (func(), error) {
+	return utils.LockFile(consts.PATH_CONTAINER_LOCK)
+}
+
+// recordContainerInfo 原子地写入 config.json，调用方负责持有 lockContainers 锁
+func recordContainerInfo(ci *container.Container) error {
+	errFormat := "recordContainerInfo: %w"
+	curPath := filepath.Join(consts.PATH_CONTAINER, ci.Id)
+	container.MkDir(curPath)
+	bs, err := json.Marshal(ci)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(curPath, "config.json"), bs, consts.MODE_0755); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// loadContainers 读取所有容器的 config.json，按创建时间排序
+func loadContainers() ([]*container.Container, error) {
+	errFormat := "loadContainers: %w"
+	entries, err := os.ReadDir(consts.PATH_CONTAINER)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(errFormat, err)
+	}
+	var cs []*container.Container
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		bs, err := os.ReadFile(filepath.Join(consts.PATH_CONTAINER, entry.Name(), "config.json"))
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logrus.Warnln("loadContainers:", err)
+			}
+			continue
+		}
+		c := &container.Container{}
+		if err := json.Unmarshal(bs, c); err != nil {
+			logrus.Warnln("loadContainers:", entry.Name(), err)
+			continue
+		}
+		cs = append(cs, c)
+	}
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].CreateAt < cs[j].CreateAt })
+	return cs, nil
+}
+
+// findContainer 按完整 ID、名称或唯一的 ID 前缀查找容器
+func findContainer(ref string) (*container.Container, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("%w: empty container id or name", ErrInvalidArgument)
+	}
+	cs, err := loadContainers()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*container.Container
+	for _, c := range cs {
+		if c.Id == ref || c.Name == ref {
+			return c, nil
+		}
+		if strings.HasPrefix(c.Id, ref) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrAmbiguous, ref)
+	}
+}
+
+// findEndpoint 查找容器在其网络上的 endpoint，不存在时返回 nil
+func findEndpoint(c *container.Container) *network.Endpoint {
+	endpoints, err := network.ListEndpoints()
+	if err != nil {
+		logrus.Warnln("findEndpoint:", err)
+		return nil
+	}
+	for _, e := range endpoints {
+		if strings.HasPrefix(e.ID, c.Id+"-") {
+			return e
+		}
+	}
+	return nil
+}
+
+func logFilePath(c *container.Container) string {
+	return filepath.Join(consts.PATH_CONTAINER, c.Id, c.Id+".log")
+}
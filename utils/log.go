@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 日志级别和格式，可以通过全局参数 --log-level/--log-format 或环境变量修改
+const (
+	DEFAULT_LOG_LEVEL  = "info"
+	DEFAULT_LOG_FORMAT = LOG_FORMAT_TEXT
+	LOG_FORMAT_TEXT    = "text"
+	LOG_FORMAT_JSON    = "json"
+	ENV_LOG_LEVEL      = "MYDOCKER_LOG_LEVEL"
+	ENV_LOG_FORMAT     = "MYDOCKER_LOG_FORMAT"
+)
+
+// SetupLogging 设置全局 logrus 的级别和格式，日志统一写到 stderr，stdout 只留给命令输出，方便管道处理。
+// 设置结果同时写回环境变量，容器 init 进程和 exec 子进程会继承相同的配置
+func SetupLogging(level, format string) error {
+	errFormat := "setupLogging: %w"
+	if level == "" {
+		level = DEFAULT_LOG_LEVEL
+	}
+	if format == "" {
+		format = DEFAULT_LOG_FORMAT
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	switch format {
+	case LOG_FORMAT_TEXT:
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case LOG_FORMAT_JSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf(errFormat, fmt.Errorf("unknown log format %q, must be %s or %s", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON))
+	}
+	logrus.SetLevel(lvl)
+	logrus.SetOutput(os.Stderr)
+	os.Setenv(ENV_LOG_LEVEL, lvl.String())
+	os.Setenv(ENV_LOG_FORMAT, format)
+	return nil
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rollback 记录已经完成的步骤对应的撤销操作，某一步失败时按相反顺序撤销之前的所有步骤。
+// 全部步骤成功后调用 Commit 丢弃撤销操作。
+//
+//	rb := &utils.Rollback{}
+//	defer rb.Run()
+//	rb.Add("xxx", undoXXX)
+//	...
+//	rb.Commit()
+type Rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+func (r *Rollback) Add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// Commit 表示事务成功，之后的 Run 不再执行任何撤销操作
+func (r *Rollback) Commit() {
+	r.steps = nil
+}
+
+// Run 按相反顺序执行撤销操作，某个撤销操作失败不影响后续的撤销
+func (r *Rollback) Run() error {
+	var errs []error
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		s := r.steps[i]
+		logrus.Debugf("rollback: %s", s.name)
+		if err := s.undo(); err != nil {
+			logrus.Errorf("rollback %s: %s", s.name, err)
+			errs = append(errs, fmt.Errorf("rollback %s: %w", s.name, err))
+		}
+	}
+	r.steps = nil
+	return errors.Join(errs...)
+}
@@ -3,8 +3,12 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
 )
 
 func HashStr(v any) (string, error) {
@@ -20,3 +24,91 @@ func PathNotExist(path string) bool {
 	_, err := os.Stat(path)
 	return os.IsNotExist(err)
 }
+
+// LockFile 对 path 加 flock 排他锁，阻塞直到拿到锁，返回的函数用于释放锁。
+// 每次 CLI 调用都是独立进程，进程内的 sync.Mutex 无法互斥，需要借助文件锁。
+// 注意 flock 锁属于打开的文件描述，同一进程重复加锁同一文件也会阻塞
+func LockFile(path string) (func(), error) {
+	return lockFile(path, unix.LOCK_EX)
+}
+
+// TryLockFile 与 LockFile 相同，但锁被其他进程持有时立即返回 ErrLocked
+func TryLockFile(path string) (func(), error) {
+	return lockFile(path, unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// RLockFile 对 path 加 flock 共享锁，多个进程可以同时持有，和排他锁互斥
+func RLockFile(path string) (func(), error) {
+	return lockFile(path, unix.LOCK_SH)
+}
+
+var ErrLocked = errors.New("locked by another process")
+
+func lockFile(path string, how int) (func(), error) {
+	errFormat := "lockFile %s: %w"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), how)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err == unix.EWOULDBLOCK {
+		err = ErrLocked
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf(errFormat, path, err)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// WriteFileAtomic 先写同目录下的临时文件并 fsync，再 rename 覆盖目标文件，
+// 保证进程崩溃时目标文件要么是旧内容要么是新内容，不会出现截断的文件
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	errFormat := "writeFileAtomic %s: %w"
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	// 临时文件不能以 .json 结尾，避免被按扩展名遍历的逻辑读到
+	f, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return fmt.Errorf(errFormat, path, err)
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf(errFormat, path, err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return fmt.Errorf(errFormat, path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf(errFormat, path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(errFormat, path, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf(errFormat, path, err)
+	}
+	// rename 之后同步目录项，确保掉电后重命名也已落盘
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -1,7 +1,14 @@
 package utils
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestHashStr(t *testing.T) {
@@ -39,4 +46,124 @@ func TestHashStr(t *testing.T) {
 	if str != expectedHash {
 		t.Errorf("Expected hash %s, got %s", expectedHash, str)
 	}
-}
\ No newline at end of file
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "config.json")
+	if err := WriteFileAtomic(p, []byte("old"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	if err := WriteFileAtomic(p, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	bs, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(bs) != "new" {
+		t.Errorf("Expected content new, got %s", bs)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected temp files to be cleaned up, got %d entries", len(entries))
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.lock")
+	unlock, err := LockFile(p)
+	if err != nil {
+		t.Fatalf("LockFile: %v", err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := LockFile(p)
+		if err != nil {
+			t.Errorf("LockFile: %v", err)
+			close(acquired)
+			return
+		}
+		close(acquired)
+		unlock2()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected second LockFile to block while the lock is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected second LockFile to succeed after unlock")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var order []string
+	rb := &Rollback{}
+	rb.Add("first", func() error {
+		order = append(order, "first")
+		return nil
+	})
+	rb.Add("second", func() error {
+		order = append(order, "second")
+		return errors.New("undo failed")
+	})
+	rb.Add("third", func() error {
+		order = append(order, "third")
+		return nil
+	})
+	if err := rb.Run(); err == nil {
+		t.Errorf("Expected error from failed undo")
+	}
+	if strings.Join(order, ",") != "third,second,first" {
+		t.Errorf("Expected undo in reverse order, got %v", order)
+	}
+	order = nil
+	rb.Add("committed", func() error {
+		order = append(order, "committed")
+		return nil
+	})
+	rb.Commit()
+	if err := rb.Run(); err != nil || len(order) != 0 {
+		t.Errorf("Expected no undo after Commit, got %v %v", order, err)
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	t.Setenv(ENV_LOG_LEVEL, "")
+	t.Setenv(ENV_LOG_FORMAT, "")
+	defer logrus.SetLevel(logrus.InfoLevel)
+	tests := []struct {
+		level   string
+		format  string
+		want    logrus.Level
+		wantErr bool
+	}{
+		{"", "", logrus.InfoLevel, false},
+		{"debug", "json", logrus.DebugLevel, false},
+		{"warn", "text", logrus.WarnLevel, false},
+		{"verbose", "text", 0, true},
+		{"info", "xml", 0, true},
+	}
+	for _, tt := range tests {
+		err := SetupLogging(tt.level, tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("SetupLogging(%q, %q) error = %v, wantErr %v", tt.level, tt.format, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if got := logrus.GetLevel(); got != tt.want {
+			t.Errorf("SetupLogging(%q, %q) level = %s, want %s", tt.level, tt.format, got, tt.want)
+		}
+		if got := os.Getenv(ENV_LOG_LEVEL); got != tt.want.String() {
+			t.Errorf("SetupLogging(%q, %q) %s = %q, want %q", tt.level, tt.format, ENV_LOG_LEVEL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+// Package webhook 把 events 日志中的事件投递到配置的 webhook。
+//
+// 事件先从日志复制到持久化的 outbox（每个 webhook 一个文件），再从 outbox 投递，
+// 读取日志的位置保存在 cursor 文件中。mydocker 在投递前退出时事件仍保留在日志或 outbox 中，
+// 下次运行 mydocker 命令、daemon 或 webhook deliver 时继续投递
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wlbyte/mydocker/config"
+	"github.com/wlbyte/mydocker/consts"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/utils"
+)
+
+// 请求头，接收方用 HEADER_SIGNATURE 校验请求体，用 HEADER_DELIVERY 对重试的请求去重
+const (
+	HEADER_SIGNATURE = "X-Mydocker-Signature"
+	HEADER_EVENT     = "X-Mydocker-Event"
+	HEADER_DELIVERY  = "X-Mydocker-Delivery"
+)
+
+const (
+	requestTimeout = 5 * time.Second
+	backoffBase    = time.Second
+	backoffMax     = 5 * time.Minute
+)
+
+// Payload 请求体
+type Payload struct {
+	Delivery string        `json:"delivery"`
+	Event    *events.Event `json:"event"`
+}
+
+// delivery outbox 中的一条待投递记录
+type delivery struct {
+	ID          string        `json:"id"`
+	URL         string        `json:"url"`
+	Event       *events.Event `json:"event"`
+	Attempts    int           `json:"attempts"`
+	NextAttempt time.Time     `json:"nextAttempt"`
+	LastError   string        `json:"lastError,omitempty"`
+}
+
+type hook struct {
+	config.Webhook
+	filter events.Filter
+}
+
+type Dispatcher struct {
+	hooks  []*hook
+	client *http.Client
+	now    func() time.Time
+}
+
+func New(webhooks []config.Webhook) (*Dispatcher, error) {
+	d := &Dispatcher{
+		client: &http.Client{Timeout: requestTimeout},
+		now:    time.Now,
+	}
+	for _, w := range webhooks {
+		filters, err := events.ParseFilters(w.Filters)
+		if err != nil {
+			return nil, fmt.Errorf("webhook.New: %w", err)
+		}
+		if w.MaxAttempts == 0 {
+			w.MaxAttempts = config.DEFAULT_WEBHOOK_MAX_ATTEMPTS
+		}
+		d.hooks = append(d.hooks, &hook{Webhook: w, filter: events.Filter{Filters: filters}})
+	}
+	return d, nil
+}
+
+func outboxDir() string {
+	return filepath.Join(consts.PATH_WEBHOOK, "outbox")
+}
+
+// failedDir 超过重试次数或不可重试的记录移到这里，需要人工处理
+func failedDir() string {
+	return filepath.Join(consts.PATH_WEBHOOK, "failed")
+}
+
+func cursorPath() string {
+	return filepath.Join(consts.PATH_WEBHOOK, "cursor")
+}
+
+// Init 第一次启用 webhook 时从日志末尾开始，不投递启用之前的历史事件
+func (d *Dispatcher) Init() error {
+	errFormat := "webhook.Init: %w"
+	if !utils.PathNotExist(cursorPath()) {
+		return nil
+	}
+	var size int64
+	if fi, err := os.Stat(consts.PATH_EVENTS); err == nil {
+		size = fi.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := writeCursor(size); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// RunOnce 把新的事件复制到 outbox 并投递所有到期的记录。
+// 其他进程正在投递时直接返回，ctx 结束时停止投递剩下的记录
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	errFormat := "webhook.RunOnce: %w"
+	unlock, err := utils.TryLockFile(consts.PATH_WEBHOOK_LOCK)
+	if errors.Is(err, utils.ErrLocked) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer unlock()
+	if err := d.Init(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := d.collect(); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := d.deliverDue(ctx); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// Run 每隔 interval 执行一次 RunOnce，直到 done 被关闭
+func (d *Dispatcher) Run(done <-chan struct{}, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.RunOnce(ctx); err != nil {
+			logrus.Warnln("webhook:", err)
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect 读取 cursor 之后的完整事件行，为每个匹配的 webhook 写入一条 outbox 记录后再移动 cursor。
+// 记录的 ID 由事件在日志中的位置和 URL 决定，写入后崩溃导致重复 collect 时只会覆盖同一个文件
+func (d *Dispatcher) collect() error {
+	errFormat := "collect: %w"
+	offset, err := readCursor()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	f, err := os.Open(consts.PATH_EVENTS)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if fi.Size() < offset {
+		// 日志被删除后重新创建，从头开始
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	if err := os.MkdirAll(outboxDir(), consts.MODE_0755); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+
+	r := bufio.NewReader(f)
+	start := offset
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// 没有换行符的半行可能还在写入，下次再读
+			break
+		}
+		if err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+		lineOffset := offset
+		offset += int64(len(line))
+		var e events.Event
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			logrus.Warnln("webhook: skip bad event:", err)
+			continue
+		}
+		for _, h := range d.hooks {
+			if !h.filter.Match(&e) {
+				continue
+			}
+			del := &delivery{
+				ID:          fmt.Sprintf("%016x-%s", lineOffset, urlHash(h.URL)),
+				URL:         h.URL,
+				Event:       &e,
+				NextAttempt: d.now(),
+			}
+			if err := writeDelivery(outboxDir(), del); err != nil {
+				return fmt.Errorf(errFormat, err)
+			}
+		}
+	}
+	if offset == start {
+		return nil
+	}
+	if err := writeCursor(offset); err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	return nil
+}
+
+// deliverDue 按事件顺序投递到期的记录
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	errFormat := "deliverDue: %w"
+	entries, err := os.ReadDir(outboxDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(errFormat, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil
+		}
+		del, err := readDelivery(filepath.Join(outboxDir(), name))
+		if err != nil {
+			logrus.Warnln("webhook:", err)
+			continue
+		}
+		if del.NextAttempt.After(d.now()) {
+			continue
+		}
+		if err := d.attempt(ctx, del); err != nil {
+			return fmt.Errorf(errFormat, err)
+		}
+	}
+	return nil
+}
+
+// attempt 投递一次，根据结果删除记录、安排重试或者移到 failed 目录
+func (d *Dispatcher) attempt(ctx context.Context, del *delivery) error {
+	h := d.findHook(del.URL)
+	if h == nil {
+		del.LastError = "webhook is no longer configured"
+		return moveToFailed(del)
+	}
+	del.Attempts++
+	permanent, err := d.send(ctx, h, del)
+	if err == nil {
+		return os.Remove(filepath.Join(outboxDir(), del.ID+".json"))
+	}
+	if ctx.Err() != nil {
+		// 被调用方取消的请求不计入重试次数
+		return nil
+	}
+	del.LastError = err.Error()
+	if permanent || del.Attempts >= h.MaxAttempts {
+		logrus.Warnf("webhook: give up delivery %s to %s after %d attempts: %v", del.ID, del.URL, del.Attempts, err)
+		return moveToFailed(del)
+	}
+	del.NextAttempt = d.now().Add(backoff(del.Attempts))
+	return writeDelivery(outboxDir(), del)
+}
+
+// send 返回的 permanent 为 true 表示重试也不会成功，比如 4xx 响应
+func (d *Dispatcher) send(ctx context.Context, h *hook, del *delivery) (permanent bool, err error) {
+	body, err := json.Marshal(Payload{Delivery: del.ID, Event: del.Event})
+	if err != nil {
+		return true, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mydocker-webhook")
+	req.Header.Set(HEADER_EVENT, del.Event.Type+"."+del.Event.Action)
+	req.Header.Set(HEADER_DELIVERY, del.ID)
+	if h.Secret != "" {
+		req.Header.Set(HEADER_SIGNATURE, Sign(h.Secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return !retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// Sign 返回 "sha256=" 加上以 secret 为密钥的请求体 HMAC-SHA256 十六进制值
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff 第 n 次失败后等待 1s、2s、4s ... 最长 5 分钟
+func backoff(attempts int) time.Duration {
+	wait := backoffBase
+	for i := 1; i < attempts && wait < backoffMax; i++ {
+		wait *= 2
+	}
+	return min(wait, backoffMax)
+}
+
+func (d *Dispatcher) findHook(url string) *hook {
+	for _, h := range d.hooks {
+		if h.URL == url {
+			return h
+		}
+	}
+	return nil
+}
+
+func urlHash(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:4])
+}
+
+func readCursor() (int64, error) {
+	bs, err := os.ReadFile(cursorPath())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(bs)), 10, 64)
+}
+
+func writeCursor(offset int64) error {
+	if err := os.MkdirAll(consts.PATH_WEBHOOK, consts.MODE_0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(cursorPath(), []byte(strconv.FormatInt(offset, 10)), 0644)
+}
+
+func readDelivery(path string) (*delivery, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var del delivery
+	if err := json.Unmarshal(bs, &del); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &del, nil
+}
+
+// writeDelivery outbox 记录只允许所有者读取
+func writeDelivery(dir string, del *delivery) error {
+	bs, err := json.Marshal(del)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, del.ID+".json"), bs, 0600)
+}
+
+func moveToFailed(del *delivery) error {
+	if err := os.MkdirAll(failedDir(), consts.MODE_0755); err != nil {
+		return err
+	}
+	if err := writeDelivery(failedDir(), del); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(outboxDir(), del.ID+".json"))
+}
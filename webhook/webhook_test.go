@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wlbyte/mydocker/config"
+	"github.com/wlbyte/mydocker/events"
+	"github.com/wlbyte/mydocker/internal/testutil"
+)
+
+func logEvent(t *testing.T, action, exitCode string) {
+	t.Helper()
+	e := &events.Event{Type: events.TYPE_CONTAINER, Action: action, ID: "abc", Time: time.Now()}
+	if exitCode != "" {
+		e.Attributes = map[string]string{"exitCode": exitCode}
+	}
+	if err := events.Append(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiver 记录收到的事件，前 failures 次请求返回 500
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	secret   string
+	got      []Payload
+	badSig   int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	if r.secret != "" && req.Header.Get(HEADER_SIGNATURE) != Sign(r.secret, body) {
+		r.badSig++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var p Payload
+	json.Unmarshal(body, &p)
+	r.got = append(r.got, p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestDeliverWithRetry(t *testing.T) {
+	testutil.SetTestRoot(t)
+	recv := &receiver{failures: 2, secret: "s3cret"}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	hooks := []config.Webhook{{URL: srv.URL, Secret: "s3cret", Filters: []string{"event=die", "exitCode=nonzero"}}}
+	d, err := New(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	logEvent(t, events.ACTION_START, "")
+	logEvent(t, events.ACTION_DIE, "0")
+	logEvent(t, events.ACTION_DIE, "137")
+
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := d.RunOnce(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// 跳过退避时间
+		now = now.Add(backoffMax)
+	}
+	if recv.badSig != 0 {
+		t.Errorf("%d requests with bad signature", recv.badSig)
+	}
+	if len(recv.got) != 1 || recv.got[0].Event.Attributes["exitCode"] != "137" {
+		t.Fatalf("got %+v, want one die event with exitCode 137", recv.got)
+	}
+	if entries, _ := os.ReadDir(outboxDir()); len(entries) != 0 {
+		t.Errorf("outbox has %d entries after delivery", len(entries))
+	}
+}
+
+// TestOutboxSurvivesRestart 第一个 Dispatcher 投递失败后退出，新的 Dispatcher 从 outbox 继续投递
+func TestOutboxSurvivesRestart(t *testing.T) {
+	testutil.SetTestRoot(t)
+	recv := &receiver{failures: 1}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	hooks := []config.Webhook{{URL: srv.URL}}
+
+	d1, err := New(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d1.Init(); err != nil {
+		t.Fatal(err)
+	}
+	logEvent(t, events.ACTION_OOM, "")
+	if err := d1.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recv.got) != 0 {
+		t.Fatalf("first attempt should fail, got %+v", recv.got)
+	}
+
+	d2, err := New(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2.now = func() time.Time { return time.Now().Add(backoffMax) }
+	if err := d2.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recv.got) != 1 || recv.got[0].Event.Action != events.ACTION_OOM {
+		t.Fatalf("got %+v, want the oom event", recv.got)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	testutil.SetTestRoot(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	d, err := New([]config.Webhook{{URL: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	logEvent(t, events.ACTION_STOP, "")
+	if err := d.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 4xx 不重试，直接移到 failed
+	if entries, _ := os.ReadDir(failedDir()); len(entries) != 1 {
+		t.Errorf("failed has %d entries, want 1", len(entries))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{30, backoffMax},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}